@@ -44,6 +44,10 @@ function handleCreate(id, password) {
 
 function handleRevealPassword(id) {
     getPassword(id, token, (resp) => {
+        if (!resp.retrievable) {
+            alert(`The password for ${id} is stored as a one-way hash and cannot be revealed.`);
+            return;
+        }
         const updated = updateCachedPassword(id, resp.password);
         if (updated) {
             renderEntries(entries);
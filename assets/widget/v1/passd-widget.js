@@ -0,0 +1,159 @@
+// passd validation widget, v1
+//
+// Renders a password prompt for a passd entry & checks it with /validate, so
+// that sites don't need to write their own. Include it where the prompt should
+// appear:
+//
+//     <div id="contact" hidden></div>
+//     <script src="{{ .BaseUrl }}/widget/v1/passd-widget.js"
+//             data-passd-id="party"
+//             data-passd-reveal="#contact"
+//             data-passd-callback="onPartyUnlocked"></script>
+//
+// Attributes:
+//     data-passd-id        (required) ID of the entry to validate against
+//     data-passd-target    Selector of the element to render the prompt into
+//                          (default: right after the script tag)
+//     data-passd-reveal    Selector of elements to unhide once the password
+//                          matches. If the entry has a text payload, it is
+//                          placed in them as text.
+//     data-passd-callback  Name of a global function to call with the result
+//     data-passd-session   If "true", also request a session token
+//
+// A "passd:validated" event with the same result is dispatched on the
+// document after each attempt.
+(function () {
+    var PASSD_URL = "{{ .BaseUrl }}";
+    var VERSION = "1";
+
+    var script = document.currentScript;
+    if (!script || !script.dataset.passdId) {
+        console.error("passd-widget: script tag must have a data-passd-id attribute");
+        return;
+    }
+    var id = script.dataset.passdId;
+
+    function textPayload(payload) {
+        if (!payload || payload.encoding !== "text") {
+            return null;
+        }
+        return payload.content;
+    }
+
+    function reveal(selector, payload) {
+        if (!selector) {
+            return;
+        }
+        var text = textPayload(payload);
+        document.querySelectorAll(selector).forEach(function (el) {
+            if (text !== null) {
+                el.textContent = text;
+            }
+            el.hidden = false;
+        });
+    }
+
+    function notify(result) {
+        var callbackName = script.dataset.passdCallback;
+        if (callbackName && typeof window[callbackName] === "function") {
+            window[callbackName](result);
+        }
+        document.dispatchEvent(new CustomEvent("passd:validated", { detail: result }));
+    }
+
+    function validate(password) {
+        var body = new URLSearchParams();
+        body.set("name", id);
+        body.set("password", password);
+        if (script.dataset.passdSession === "true") {
+            body.set("session", "true");
+        }
+        // A form-encoded POST is a "simple" CORS request, so no preflight is
+        // needed.
+        return fetch(PASSD_URL + "/validate", {
+            method: "POST",
+            body: body,
+        }).then(function (response) {
+            if (response.status === 429) {
+                return { result: false, error: "Too many failed attempts; try again later." };
+            }
+            if (!response.ok) {
+                return { result: false, error: "Something went wrong; please try again later." };
+            }
+            return response.json().then(function (json) {
+                if (!json.result) {
+                    json.error = "Incorrect password.";
+                }
+                return json;
+            });
+        });
+    }
+
+    function render() {
+        var form = document.createElement("form");
+        form.className = "passd-widget";
+        form.dataset.passdVersion = VERSION;
+
+        var input = document.createElement("input");
+        input.type = "password";
+        input.name = "password";
+        input.required = true;
+        input.autocomplete = "current-password";
+        input.placeholder = "Password";
+
+        var button = document.createElement("button");
+        button.type = "submit";
+        button.textContent = "Continue";
+
+        var error = document.createElement("span");
+        error.className = "passd-widget-error";
+        error.hidden = true;
+
+        form.appendChild(input);
+        form.appendChild(button);
+        form.appendChild(error);
+
+        form.addEventListener("submit", function (e) {
+            e.preventDefault();
+            button.disabled = true;
+            error.hidden = true;
+            validate(input.value).then(function (response) {
+                var result = {
+                    id: id,
+                    result: response.result,
+                    payload: response.payload || null,
+                    token: response.token || null,
+                    expiresAt: response.expires_at || null,
+                };
+                if (response.result) {
+                    form.remove();
+                    reveal(script.dataset.passdReveal, result.payload);
+                } else {
+                    error.textContent = response.error;
+                    error.hidden = false;
+                    input.value = "";
+                }
+                notify(result);
+            }).catch(function (err) {
+                console.error("passd-widget: failed to validate password", err);
+                error.textContent = "Something went wrong; please try again later.";
+                error.hidden = false;
+            }).finally(function () {
+                button.disabled = false;
+            });
+        });
+
+        var target = script.dataset.passdTarget ? document.querySelector(script.dataset.passdTarget) : null;
+        if (target) {
+            target.appendChild(form);
+        } else {
+            script.insertAdjacentElement("afterend", form);
+        }
+    }
+
+    if (document.readyState === "loading") {
+        document.addEventListener("DOMContentLoaded", render);
+    } else {
+        render();
+    }
+})();
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/utils"
+)
+
+// SystemAuditSubject identifies actions that passd performed on its own,
+// rather than on behalf of an admin.
+const SystemAuditSubject string = "passd"
+
+// CliAuditSubject identifies actions taken through the commands that operate
+// on the DB directly. Their username is that of the OS user who ran them.
+const CliAuditSubject string = "cli"
+
+// adminIdentity extracts the subject & username from the access token
+// validated by the auth middleware. Both are empty if auth is disabled.
+func adminIdentity(ctx *fiber.Ctx) (string, string) {
+	token, ok := ctx.Locals(TokenLocalName).(*jwt.Token)
+	if !ok || token == nil {
+		return "", ""
+	}
+	subject, _ := (*token).Subject()
+	var username string
+	if err := (*token).Get("preferred_username", &username); err != nil {
+		username = ""
+	}
+	return subject, username
+}
+
+// recordAdminAction appends an entry to the admin audit trail. Failures are
+// logged rather than surfaced, since the action itself has already happened.
+func recordAdminAction(ctx *fiber.Ctx, action passddb.AdminAction, id string) {
+	recordCredentialAction(ctx, action, id, "")
+}
+
+// recordCredentialAction is recordAdminAction for actions on one of an
+// entry's credentials.
+func recordCredentialAction(ctx *fiber.Ctx, action passddb.AdminAction, id string, credential string) {
+	subject, username := adminIdentity(ctx)
+	record := passddb.AdminAuditRecord{
+		OccurredOn: time.Now(),
+		Action:     action,
+		EntryId:    id,
+		Subject:    subject,
+		Username:   username,
+		ClientIP:   clientIP(ctx),
+		RequestId:  fmt.Sprint(ctx.Locals(RequestIdLocalName)),
+		Credential: credential,
+	}
+	if err := DB.RecordAdminAction(record); err != nil {
+		slog.Error("failed to write admin audit record",
+			"action", action,
+			"id", id,
+			"credential", credential,
+			"subject", subject,
+			"err", err)
+	}
+}
+
+// recordCliAction is recordCredentialAction for the commands that operate on
+// db directly.
+func recordCliAction(db passddb.Store, action passddb.AdminAction, id string, credential string) {
+	var username string
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	record := passddb.AdminAuditRecord{
+		OccurredOn: time.Now(),
+		Action:     action,
+		EntryId:    id,
+		Subject:    CliAuditSubject,
+		Username:   username,
+		Credential: credential,
+	}
+	if err := db.RecordAdminAction(record); err != nil {
+		slog.Error("failed to write admin audit record",
+			"action", action,
+			"id", id,
+			"credential", credential,
+			"err", err)
+	}
+}
+
+func parseAuditFilter(ctx *fiber.Ctx) (passddb.AuditFilter, error) {
+	filter := passddb.AuditFilter{
+		EntryId: ctx.Query("id"),
+		Action:  passddb.AdminAction(ctx.Query("action")),
+		Subject: ctx.Query("subject"),
+		Limit:   ctx.QueryInt("limit", DefaultPageSize),
+		Offset:  ctx.QueryInt("offset", 0),
+	}
+	if filter.Limit <= 0 || filter.Limit > MaxPageSize {
+		return filter, fmt.Errorf("limit must be between 1 and %d", MaxPageSize)
+	}
+	if filter.Offset < 0 {
+		return filter, fmt.Errorf("offset must not be negative")
+	}
+	var err error
+	if since := ctx.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("since must be an RFC 3339 timestamp")
+		}
+	}
+	if until := ctx.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("until must be an RFC 3339 timestamp")
+		}
+	}
+	return filter, nil
+}
+
+func writeAuditRecords(w io.Writer, format string, records []passddb.AdminAuditRecord) error {
+	switch format {
+	case FormatJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(utils.Map(records, newAuditRecordResponse))
+	case FormatCsv:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"audit_id", "occurred_on", "action", "entry_id", "subject", "username", "client_ip", "request_id", "credential"})
+		for _, r := range records {
+			writer.Write([]string{
+				strconv.FormatInt(r.AuditId, 10),
+				r.OccurredOn.Format(time.RFC3339),
+				string(r.Action),
+				r.EntryId,
+				r.Subject,
+				r.Username,
+				r.ClientIP,
+				r.RequestId,
+				r.Credential,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatTable:
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tTIME\tACTION\tENTRY\tCREDENTIAL\tUSER\tSUBJECT\tCLIENT IP")
+		for _, r := range records {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.AuditId,
+				r.OccurredOn.Format(time.RFC3339),
+				r.Action,
+				r.EntryId,
+				r.Credential,
+				r.Username,
+				r.Subject,
+				r.ClientIP)
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+}
+
+func Audit(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	id := flags.String("id", "", "")
+	action := flags.String("action", "", "")
+	subject := flags.String("subject", "", "")
+	since := flags.String("since", "", "")
+	until := flags.String("until", "", "")
+	format := flags.String("format", FormatTable, "")
+	if err := flags.Parse(args); err != nil {
+		printHelp()
+		return 1
+	}
+
+	filter := passddb.AuditFilter{
+		EntryId: *id,
+		Action:  passddb.AdminAction(*action),
+		Subject: *subject,
+	}
+	var err error
+	if *since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			slog.Error("--since must be an RFC 3339 timestamp", "value", *since)
+			return 1
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, *until); err != nil {
+			slog.Error("--until must be an RFC 3339 timestamp", "value", *until)
+			return 1
+		}
+	}
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	records, _, err := db.ListAdminAuditRecords(filter)
+	if err != nil {
+		slog.Error("failed to load audit records", "err", err)
+		return 1
+	}
+	if err := writeAuditRecords(os.Stdout, *format, records); err != nil {
+		slog.Error("failed to write audit records", "err", err)
+		return 1
+	}
+	return 0
+}
+
+type AuditRecordResponse struct {
+	AuditId    int64     `json:"audit_id"`
+	OccurredOn time.Time `json:"occurred_on"`
+	Action     string    `json:"action"`
+	EntryId    string    `json:"entry_id"`
+	Subject    string    `json:"subject"`
+	Username   string    `json:"username"`
+	ClientIP   string    `json:"client_ip"`
+	RequestId  string    `json:"request_id"`
+	Credential string    `json:"credential"`
+}
+
+func newAuditRecordResponse(r passddb.AdminAuditRecord) AuditRecordResponse {
+	return AuditRecordResponse{
+		AuditId:    r.AuditId,
+		OccurredOn: r.OccurredOn,
+		Action:     string(r.Action),
+		EntryId:    r.EntryId,
+		Subject:    r.Subject,
+		Username:   r.Username,
+		ClientIP:   r.ClientIP,
+		RequestId:  r.RequestId,
+		Credential: r.Credential,
+	}
+}
+
+type ListAuditRecordsResponse struct {
+	Records []AuditRecordResponse `json:"records"`
+	Total   int                   `json:"total"`
+	Limit   int                   `json:"limit"`
+	Offset  int                   `json:"offset"`
+}
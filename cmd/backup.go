@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+)
+
+// resolveSqliteDbPath is resolveDbPath for commands that only work with the
+// SQLite backend.
+func resolveSqliteDbPath(command string) (string, error) {
+	if backend := dbBackend(); backend != DBBackendSQLite {
+		return "", fmt.Errorf("%s is only supported for the %s backend, not %s", command, DBBackendSQLite, backend)
+	}
+	return resolveDbPath()
+}
+
+func Backup(args []string) int {
+	if len(args) != 1 {
+		printHelp()
+		return 1
+	}
+	dest := args[0]
+
+	dbPath, err := resolveSqliteDbPath("backup")
+	if err != nil {
+		slog.Error("failed to resolve DB", "err", err)
+		return 1
+	}
+	if err := passddb.BackupFile(dbPath, dest); err != nil {
+		slog.Error("failed to back up DB", "path", dbPath, "dest", dest, "err", err)
+		return 1
+	}
+	slog.Info("backed up DB", "path", dbPath, "dest", dest)
+	return 0
+}
+
+func Restore(args []string) int {
+	if len(args) != 1 {
+		printHelp()
+		return 1
+	}
+	src := args[0]
+	if _, err := os.Stat(src); err != nil {
+		slog.Error("failed to open backup", "src", src, "err", err)
+		return 1
+	}
+
+	dbPath, err := resolveSqliteDbPath("restore")
+	if err != nil {
+		slog.Error("failed to resolve DB", "err", err)
+		return 1
+	}
+	keyPath, err := resolveKeyPath()
+	if err != nil {
+		slog.Error("failed to resolve key path", "err", err)
+		return 1
+	}
+	keyring, err := loadKeyring(keyPath)
+	if err != nil {
+		slog.Error("failed to load key file", "path", keyPath, "err", err)
+		return 1
+	}
+
+	_, statErr := os.Stat(dbPath)
+	verified, err := passddb.Restore(src, dbPath, keyring)
+	if errors.Is(err, passddb.ErrPreviousDbMoved) {
+		slog.Error("failed to restore DB; the existing DB could not be moved back into place", "src", src, "path", dbPath, "previous", dbPath+".pre-restore", "err", err)
+		return 1
+	}
+	if err != nil {
+		slog.Error("failed to restore DB; the existing DB was not changed", "src", src, "path", dbPath, "err", err)
+		return 1
+	}
+	if statErr == nil {
+		slog.Info("restored DB", "src", src, "path", dbPath, "verified", verified, "previous", dbPath+".pre-restore")
+	} else {
+		slog.Info("restored DB", "src", src, "path", dbPath, "verified", verified)
+	}
+	return 0
+}
+
+// sendBackup streams a snapshot of the DB to the client.
+func sendBackup(ctx *fiber.Ctx) error {
+	dir, err := os.MkdirTemp("", "passd-backup-")
+	if err != nil {
+		slog.Error("failed to create backup directory", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to back up DB"})
+	}
+	// The snapshot stays readable through the open file once this removes it.
+	defer os.RemoveAll(dir)
+
+	snapshot := filepath.Join(dir, DefaultPassdDatabaseName)
+	if err := DB.Backup(snapshot); errors.Is(err, passddb.ErrBackupUnsupported) {
+		return ctx.Status(fiber.StatusNotImplemented).JSON(passdapi.ErrorResponse{Message: err.Error()})
+	} else if err != nil {
+		slog.Error("failed to back up DB", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to back up DB"})
+	}
+
+	file, err := os.Open(snapshot)
+	if err != nil {
+		slog.Error("failed to open DB backup", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to back up DB"})
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		slog.Error("failed to stat DB backup", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to back up DB"})
+	}
+
+	recordAdminAction(ctx, passddb.ActionBackup, "")
+	ctx.Attachment(fmt.Sprintf("passd-%s.sqlite", time.Now().UTC().Format("20060102T150405Z")))
+	ctx.Set(fiber.HeaderContentType, "application/vnd.sqlite3")
+	return ctx.SendStream(file, int(info.Size()))
+}
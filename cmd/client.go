@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/prompt"
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+	"github.com/mrshanahan/simple-password-service/pkg/client"
+	"golang.org/x/oauth2"
+)
+
+var (
+	DefaultClientId       string = "passd"
+	DefaultTokenCacheName string = "token.json"
+)
+
+// clientSecret returns the secret for the client credentials grant from
+// PASSD_CLIENT_SECRET or PASSD_CLIENT_SECRET_FILE, or an empty string if
+// neither is set.
+func clientSecret() (string, error) {
+	if secret := os.Getenv("PASSD_CLIENT_SECRET"); secret != "" {
+		return secret, nil
+	}
+	if secretFile := os.Getenv("PASSD_CLIENT_SECRET_FILE"); secretFile != "" {
+		secret, err := os.ReadFile(secretFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read client secret file %s: %w", secretFile, err)
+		}
+		return string(bytes.TrimRight(secret, "\r\n")), nil
+	}
+	return "", nil
+}
+
+func tokenCache() client.FileTokenCache {
+	if path := os.Getenv("PASSD_TOKEN_CACHE"); path != "" {
+		return client.FileTokenCache(path)
+	}
+	return client.FileTokenCache(filepath.Join(DefaultPassdDirectory, DefaultTokenCacheName))
+}
+
+func promptDeviceLogin(response *oauth2.DeviceAuthResponse) {
+	if response.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "To log in, visit %s\n", response.VerificationURIComplete)
+	} else {
+		fmt.Fprintf(os.Stderr, "To log in, visit %s & enter the code %s\n", response.VerificationURI, response.UserCode)
+	}
+}
+
+// newClient returns a client for the passd instance at PASSD_URL, logging in
+// to the provider at PASSD_AUTH_PROVIDER_URL if need be. Without a provider,
+// requests are sent without a token, as for instances with auth disabled.
+func newClient(ctx context.Context) (*client.Client, error) {
+	baseUrl := clientBaseUrl()
+	providerUrl := os.Getenv("PASSD_AUTH_PROVIDER_URL")
+	if providerUrl == "" {
+		slog.Warn("PASSD_AUTH_PROVIDER_URL is not set; sending requests without a token")
+		return client.New(baseUrl, nil), nil
+	}
+	login, err := newLogin(providerUrl)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := login.TokenSource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in: %w", err)
+	}
+	return client.New(baseUrl, tokens), nil
+}
+
+// clientBaseUrl returns the URL of the passd instance to send requests to.
+func clientBaseUrl() string {
+	if baseUrl := os.Getenv("PASSD_URL"); baseUrl != "" {
+		return baseUrl
+	}
+	return fmt.Sprintf("http://localhost:%d", DefaultPort)
+}
+
+func newLogin(providerUrl string) (client.Login, error) {
+	clientId := os.Getenv("PASSD_CLIENT_ID")
+	if clientId == "" {
+		clientId = DefaultClientId
+	}
+	secret, err := clientSecret()
+	if err != nil {
+		return client.Login{}, err
+	}
+	return client.Login{
+		ProviderUrl:  providerUrl,
+		ClientId:     clientId,
+		ClientSecret: secret,
+		Cache:        tokenCache(),
+		Prompt:       promptDeviceLogin,
+	}, nil
+}
+
+func Client(args []string) int {
+	if len(args) < 1 {
+		printHelp()
+		return 1
+	}
+	switch args[0] {
+	case "login":
+		return clientLogin(args[1:])
+	case "logout":
+		return clientLogout(args[1:])
+	case "list":
+		return clientList(args[1:])
+	case "get":
+		return clientGet(args[1:])
+	case "set":
+		return clientSet(args[1:])
+	case "delete":
+		return clientDelete(args[1:])
+	case "validate":
+		return clientValidate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "error: invalid client command: %s\n", args[0])
+		printHelp()
+		return 1
+	}
+}
+
+func clientLogin(args []string) int {
+	if len(args) != 0 {
+		printHelp()
+		return 1
+	}
+	providerUrl := os.Getenv("PASSD_AUTH_PROVIDER_URL")
+	if providerUrl == "" {
+		slog.Error("PASSD_AUTH_PROVIDER_URL must be set to log in")
+		return 1
+	}
+	cache := tokenCache()
+	if err := cache.Clear(); err != nil {
+		slog.Error("failed to clear cached token", "err", err)
+		return 1
+	}
+	login, err := newLogin(providerUrl)
+	if err != nil {
+		slog.Error("failed to configure login", "err", err)
+		return 1
+	}
+	tokens, err := login.TokenSource(context.Background())
+	if err != nil {
+		slog.Error("failed to log in", "err", err)
+		return 1
+	}
+	// Saves the token to the cache.
+	if _, err := tokens.Token(); err != nil {
+		slog.Error("failed to log in", "err", err)
+		return 1
+	}
+	slog.Info("logged in", "cache", string(cache))
+	return 0
+}
+
+func clientLogout(args []string) int {
+	if len(args) != 0 {
+		printHelp()
+		return 1
+	}
+	cache := tokenCache()
+	if err := cache.Clear(); err != nil {
+		slog.Error("failed to clear cached token", "err", err)
+		return 1
+	}
+	slog.Info("logged out", "cache", string(cache))
+	return 0
+}
+
+func clientList(args []string) int {
+	flags := flag.NewFlagSet("client list", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		printHelp()
+		return 1
+	}
+
+	ctx := context.Background()
+	c, err := newClient(ctx)
+	if err != nil {
+		slog.Error("failed to create client", "err", err)
+		return 1
+	}
+	entries, err := c.List(ctx)
+	if err != nil {
+		slog.Error("failed to load entries", "err", err)
+		return 1
+	}
+	if err := writeEntries(os.Stdout, *format, entries); err != nil {
+		slog.Error("failed to write entries", "err", err)
+		return 1
+	}
+	return 0
+}
+
+func clientGet(args []string) int {
+	flags := flag.NewFlagSet("client get", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, "")
+	reveal := flags.Bool("reveal", false, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	id := flags.Arg(0)
+	if *format != FormatTable && *format != FormatJson {
+		slog.Error("--format must be table or json", "value", *format)
+		return 1
+	}
+
+	ctx := context.Background()
+	c, err := newClient(ctx)
+	if err != nil {
+		slog.Error("failed to create client", "err", err)
+		return 1
+	}
+
+	if !*reveal {
+		entry, err := c.GetMetadata(ctx, id)
+		if err != nil {
+			slog.Error("failed to load entry", "id", id, "err", err)
+			return 1
+		}
+		if err := writeEntry(os.Stdout, *format, *entry); err != nil {
+			slog.Error("failed to write entry", "err", err)
+			return 1
+		}
+		return 0
+	}
+
+	response, err := c.Get(ctx, id)
+	if err != nil {
+		slog.Error("failed to retrieve password", "id", id, "err", err)
+		return 1
+	}
+	if !response.Retrievable {
+		slog.Error("password cannot be revealed", "id", id, "err", passddb.ErrNotRetrievable)
+		return 1
+	}
+	if *format == FormatJson {
+		err = writeJson(os.Stdout, response)
+	} else {
+		_, err = fmt.Println(response.Password)
+	}
+	if err != nil {
+		slog.Error("failed to write password", "err", err)
+		return 1
+	}
+	return 0
+}
+
+func clientSet(args []string) int {
+	flags := flag.NewFlagSet("client set", flag.ContinueOnError)
+	storageMode := flags.String("storage-mode", "", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	id := flags.Arg(0)
+
+	password, err := prompt.ReadNewSecret("Password: ")
+	if err != nil {
+		slog.Error("failed to read password", "err", err)
+		return 1
+	}
+	if len(password) == 0 {
+		slog.Error("password must not be empty")
+		return 1
+	}
+
+	ctx := context.Background()
+	c, err := newClient(ctx)
+	if err != nil {
+		slog.Error("failed to create client", "err", err)
+		return 1
+	}
+	request := passdapi.UpsertPasswordRequest{Password: string(password), StorageMode: *storageMode}
+	if err := c.Upsert(ctx, id, request); err != nil {
+		slog.Error("failed to upsert password", "id", id, "err", err)
+		return 1
+	}
+	slog.Info("set password", "id", id)
+	return 0
+}
+
+func clientDelete(args []string) int {
+	if len(args) != 1 {
+		printHelp()
+		return 1
+	}
+	id := args[0]
+
+	ctx := context.Background()
+	c, err := newClient(ctx)
+	if err != nil {
+		slog.Error("failed to create client", "err", err)
+		return 1
+	}
+	if err := c.Delete(ctx, id); err != nil {
+		slog.Error("failed to delete entry", "id", id, "err", err)
+		return 1
+	}
+	slog.Info("deleted entry", "id", id)
+	return 0
+}
+
+func clientValidate(args []string) int {
+	flags := flag.NewFlagSet("client validate", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	id := flags.Arg(0)
+	if *format != FormatTable && *format != FormatJson {
+		slog.Error("--format must be table or json", "value", *format)
+		return 1
+	}
+
+	password, err := prompt.ReadSecret("Password: ")
+	if err != nil {
+		slog.Error("failed to read password", "err", err)
+		return 1
+	}
+
+	// /validate is anonymous, so there's no need to log in.
+	c := client.New(clientBaseUrl(), nil)
+	response, err := c.Validate(context.Background(), passdapi.ValidatePasswordRequest{Id: id, Password: string(password)})
+	if err != nil {
+		slog.Error("failed to validate password", "id", id, "err", err)
+		return 1
+	}
+	if *format == FormatJson {
+		err = writeJson(os.Stdout, response)
+	} else {
+		_, err = fmt.Println(response.Result)
+	}
+	if err != nil {
+		slog.Error("failed to write result", "err", err)
+		return 1
+	}
+	if !response.Result {
+		return CheckRejectedExitCode
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	ValidateCorsMethods       string        = strings.Join([]string{fiber.MethodPost, fiber.MethodOptions}, ", ")
+	ValidateCorsHeaders       string        = fiber.HeaderContentType
+	ValidateCorsExposeHeaders string        = fiber.HeaderRetryAfter
+	ValidateCorsMaxAge        time.Duration = 10 * time.Minute
+)
+
+// allowValidateOrigin answers every cross-origin request to validate a
+// password with CORS headers, so that pages can read the result. Entries'
+// allowed origins are enforced by allowsOrigin instead, which rejects other
+// origins with the same response as a wrong password, so that restricted
+// entries can't be told apart from missing ones.
+func allowValidateOrigin(ctx *fiber.Ctx) {
+	ctx.Vary(fiber.HeaderOrigin)
+	if origin := ctx.Get(fiber.HeaderOrigin); origin != "" {
+		ctx.Set(fiber.HeaderAccessControlAllowOrigin, origin)
+		ctx.Set(fiber.HeaderAccessControlExposeHeaders, ValidateCorsExposeHeaders)
+	}
+}
+
+// allowsOrigin reports whether the entry with the given id may be validated
+// by the request's origin. Requests that don't come from a web page are
+// always allowed, as are unknown ids.
+func allowsOrigin(ctx *fiber.Ctx, id string) (bool, error) {
+	origin := ctx.Get(fiber.HeaderOrigin)
+	if origin == "" {
+		return true, nil
+	}
+	entry, err := DB.GetEntryMetadata(id)
+	if err != nil {
+		slog.Error("failed to load entry metadata", "id", id, "err", err)
+		return false, err
+	}
+	if entry != nil && !entry.AllowsOrigin(origin) {
+		slog.Info("rejecting validation attempt from disallowed origin", "id", id, "origin", origin)
+		return false, nil
+	}
+	return true, nil
+}
+
+// validatePreflight answers CORS preflight requests for /validate. The
+// origin is allowed if any entry allows it; the entry itself is only checked
+// by the request that follows, so that its restrictions are rate limited &
+// don't reveal whether it exists.
+func validatePreflight(ctx *fiber.Ctx) error {
+	ctx.Vary(fiber.HeaderOrigin)
+	origin := ctx.Get(fiber.HeaderOrigin)
+	if origin == "" {
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+
+	allowed, err := DB.IsOriginAllowed(origin)
+	if err != nil {
+		slog.Error("failed to check allowed origins", "origin", origin, "err", err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+	if !allowed {
+		slog.Debug("rejecting CORS preflight from disallowed origin", "origin", origin)
+		return ctx.SendStatus(fiber.StatusForbidden)
+	}
+
+	ctx.Set(fiber.HeaderAccessControlAllowOrigin, origin)
+	ctx.Set(fiber.HeaderAccessControlAllowMethods, ValidateCorsMethods)
+	ctx.Set(fiber.HeaderAccessControlAllowHeaders, ValidateCorsHeaders)
+	ctx.Set(fiber.HeaderAccessControlMaxAge, strconv.Itoa(int(ValidateCorsMaxAge.Seconds())))
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/prompt"
+	"github.com/mrshanahan/simple-password-service/internal/utils"
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+)
+
+// Exit code of the check command when the password would be rejected, to
+// tell it apart from failing to check at all.
+const CheckRejectedExitCode int = 2
+
+type CheckPasswordResponse struct {
+	Id         string `json:"id"`
+	Result     string `json:"result"`
+	Credential string `json:"credential,omitempty"`
+}
+
+func writeJson(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// formatTime formats t for tables, with nil shown as a dash.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatUses(e passdapi.GetPasswordEntryResponse) string {
+	if e.MaxUses == nil {
+		return fmt.Sprint(e.UseCount)
+	}
+	return fmt.Sprintf("%d/%d", e.UseCount, *e.MaxUses)
+}
+
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+func writeEntries(w io.Writer, format string, entries []passdapi.GetPasswordEntryResponse) error {
+	switch format {
+	case FormatJson:
+		return writeJson(w, entries)
+	case FormatTable:
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tCREDENTIALS\tTAGS\tUSES\tEXPIRES\tLAST VALIDATED")
+		for _, e := range entries {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.Id,
+				formatList(utils.Map(e.Credentials, func(c passdapi.CredentialResponse) string { return c.Name })),
+				formatList(e.Tags),
+				formatUses(e),
+				formatTime(e.ExpiresAt),
+				formatTime(e.LastValidatedOn))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+}
+
+func writeEntry(w io.Writer, format string, entry passdapi.GetPasswordEntryResponse) error {
+	switch format {
+	case FormatJson:
+		return writeJson(w, entry)
+	case FormatTable:
+		payload := "-"
+		if p := entry.Payload; p != nil {
+			payload = fmt.Sprintf("%s (%s, %d bytes)", p.Filename, p.ContentType, p.Size)
+		}
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(writer, "ID:\t%s\n", entry.Id)
+		fmt.Fprintf(writer, "Description:\t%s\n", entry.Description)
+		fmt.Fprintf(writer, "Tags:\t%s\n", formatList(entry.Tags))
+		fmt.Fprintf(writer, "Created:\t%s\n", formatTime(&entry.CreatedOn))
+		fmt.Fprintf(writer, "Updated:\t%s\n", formatTime(&entry.UpdatedOn))
+		fmt.Fprintf(writer, "Last validated:\t%s\n", formatTime(entry.LastValidatedOn))
+		fmt.Fprintf(writer, "Validations:\t%d\n", entry.ValidationCount)
+		fmt.Fprintf(writer, "Not before:\t%s\n", formatTime(entry.NotBefore))
+		fmt.Fprintf(writer, "Expires:\t%s\n", formatTime(entry.ExpiresAt))
+		fmt.Fprintf(writer, "Uses:\t%s\n", formatUses(entry))
+		fmt.Fprintf(writer, "Allowed origins:\t%s\n", formatList(entry.AllowedOrigins))
+		fmt.Fprintf(writer, "Payload:\t%s\n", payload)
+		fmt.Fprintf(writer, "Redirect:\t%t\n", entry.HasRedirect)
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w)
+		writer = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "CREDENTIAL\tSTORAGE MODE\tCREATED\tNOT BEFORE\tEXPIRES")
+		for _, c := range entry.Credentials {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n",
+				c.Name,
+				c.StorageMode,
+				formatTime(&c.CreatedOn),
+				formatTime(c.NotBefore),
+				formatTime(c.ExpiresAt))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+}
+
+func List(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		printHelp()
+		return 1
+	}
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	entries, err := db.ListEntryMetadata()
+	if err != nil {
+		slog.Error("failed to load entries", "err", err)
+		return 1
+	}
+	if err := writeEntries(os.Stdout, *format, utils.Map(entries, newGetPasswordEntryResponse)); err != nil {
+		slog.Error("failed to write entries", "err", err)
+		return 1
+	}
+	return 0
+}
+
+func Get(args []string) int {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, "")
+	credential := flags.String("credential", passddb.DefaultCredentialName, "")
+	reveal := flags.Bool("reveal", false, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	id := flags.Arg(0)
+	if *format != FormatTable && *format != FormatJson {
+		slog.Error("--format must be table or json", "value", *format)
+		return 1
+	}
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	if !*reveal {
+		entry, err := db.GetEntryMetadata(id)
+		if err != nil {
+			slog.Error("failed to load entry", "id", id, "err", err)
+			return 1
+		}
+		if entry == nil {
+			slog.Error("no entry found", "id", id)
+			return 1
+		}
+		if err := writeEntry(os.Stdout, *format, newGetPasswordEntryResponse(*entry)); err != nil {
+			slog.Error("failed to write entry", "err", err)
+			return 1
+		}
+		return 0
+	}
+
+	password, err := db.GetCredentialPassword(id, *credential)
+	if errors.Is(err, passddb.ErrNotRetrievable) {
+		slog.Error("credential cannot be revealed", "id", id, "credential", *credential, "err", err)
+		return 1
+	}
+	if err != nil {
+		slog.Error("failed to retrieve credential", "id", id, "credential", *credential, "err", err)
+		return 1
+	}
+	if password == nil {
+		slog.Error("no credential found", "id", id, "credential", *credential)
+		return 1
+	}
+	recordCliAction(db, passddb.ActionReadPlaintext, id, *credential)
+	if *format == FormatJson {
+		err = writeJson(os.Stdout, GetCredentialResponse{*credential, string(password), string(passddb.StorageModeEncrypted), true})
+	} else {
+		_, err = fmt.Println(string(password))
+	}
+	if err != nil {
+		slog.Error("failed to write credential", "err", err)
+		return 1
+	}
+	return 0
+}
+
+func Set(args []string) int {
+	flags := flag.NewFlagSet("set", flag.ContinueOnError)
+	credential := flags.String("credential", passddb.DefaultCredentialName, "")
+	storageMode := flags.String("storage-mode", "", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	id := flags.Arg(0)
+	mode, err := passddb.ParseStorageMode(*storageMode)
+	if err != nil {
+		slog.Error("invalid storage mode", "value", *storageMode, "err", err)
+		return 1
+	}
+
+	password, err := prompt.ReadNewSecret("Password: ")
+	if err != nil {
+		slog.Error("failed to read password", "err", err)
+		return 1
+	}
+	if len(password) == 0 {
+		slog.Error("password must not be empty")
+		return 1
+	}
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	if *credential == passddb.DefaultCredentialName {
+		created, err := db.UpsertPassword(id, string(password), mode)
+		if err != nil {
+			slog.Error("failed to upsert password", "id", id, "err", err)
+			return 1
+		}
+		if created {
+			recordCliAction(db, passddb.ActionCreate, id, "")
+			slog.Info("created entry", "id", id, "storage_mode", mode)
+		} else {
+			recordCliAction(db, passddb.ActionUpdate, id, "")
+			slog.Info("updated entry", "id", id, "storage_mode", mode)
+		}
+		return 0
+	}
+
+	created, err := db.SetCredential(id, *credential, passddb.CredentialUpdate{Password: string(password), StorageMode: mode})
+	if err != nil {
+		slog.Error("failed to set credential", "id", id, "credential", *credential, "err", err)
+		return 1
+	}
+	if created {
+		recordCliAction(db, passddb.ActionAddCredential, id, *credential)
+		slog.Info("added credential", "id", id, "credential", *credential, "storage_mode", mode)
+	} else {
+		recordCliAction(db, passddb.ActionUpdateCredential, id, *credential)
+		slog.Info("updated credential", "id", id, "credential", *credential, "storage_mode", mode)
+	}
+	return 0
+}
+
+func Delete(args []string) int {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	credential := flags.String("credential", "", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	id := flags.Arg(0)
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	if *credential == "" {
+		deleted, err := db.DeleteEntry(id)
+		if err != nil {
+			slog.Error("failed to delete entry", "id", id, "err", err)
+			return 1
+		}
+		if !deleted {
+			slog.Error("no entry found", "id", id)
+			return 1
+		}
+		recordCliAction(db, passddb.ActionDelete, id, "")
+		slog.Info("deleted entry", "id", id)
+		return 0
+	}
+
+	removed, err := db.RemoveCredential(id, *credential)
+	if err != nil {
+		slog.Error("failed to remove credential", "id", id, "credential", *credential, "err", err)
+		return 1
+	}
+	if !removed {
+		slog.Error("no credential found", "id", id, "credential", *credential)
+		return 1
+	}
+	recordCliAction(db, passddb.ActionRemoveCredential, id, *credential)
+	slog.Info("removed credential", "id", id, "credential", *credential)
+	return 0
+}
+
+func Check(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	id := flags.Arg(0)
+	if *format != FormatTable && *format != FormatJson {
+		slog.Error("--format must be table or json", "value", *format)
+		return 1
+	}
+
+	password, err := prompt.ReadSecret("Password: ")
+	if err != nil {
+		slog.Error("failed to read password", "err", err)
+		return 1
+	}
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	result, credential, err := db.CheckPassword(id, string(password))
+	if err != nil {
+		slog.Error("failed to check password", "id", id, "err", err)
+		return 1
+	}
+	if *format == FormatJson {
+		err = writeJson(os.Stdout, CheckPasswordResponse{id, string(result), credential})
+	} else if credential != "" {
+		_, err = fmt.Printf("%s (credential %s)\n", result, credential)
+	} else {
+		_, err = fmt.Println(result)
+	}
+	if err != nil {
+		slog.Error("failed to write result", "err", err)
+		return 1
+	}
+	if result != passddb.ResultSuccess {
+		return CheckRejectedExitCode
+	}
+	return 0
+}
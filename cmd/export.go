@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrshanahan/simple-password-service/internal/archive"
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/prompt"
+	"github.com/mrshanahan/simple-password-service/internal/utils"
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+)
+
+// exportPassphrase supplies the passphrase for an export archive, checking
+// PASSD_EXPORT_PASSPHRASE, then PASSD_EXPORT_PASSPHRASE_FILE, then falling
+// back to reading it from stdin, twice if confirm is set & stdin is a
+// terminal.
+func exportPassphrase(confirm bool) crypto.PassphraseFunc {
+	return func() ([]byte, error) {
+		if passphrase := os.Getenv("PASSD_EXPORT_PASSPHRASE"); passphrase != "" {
+			return []byte(passphrase), nil
+		}
+		if passphraseFile := os.Getenv("PASSD_EXPORT_PASSPHRASE_FILE"); passphraseFile != "" {
+			passphrase, err := os.ReadFile(passphraseFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read passphrase file %s: %w", passphraseFile, err)
+			}
+			return bytes.TrimRight(passphrase, "\r\n"), nil
+		}
+		slog.Info("reading export passphrase from stdin")
+		if confirm {
+			return prompt.ReadNewSecret("Export passphrase: ")
+		}
+		return prompt.ReadSecret("Export passphrase: ")
+	}
+}
+
+func GenerateExportKey(args []string) int {
+	if len(args) != 1 {
+		printHelp()
+		return 1
+	}
+	path := args[0]
+
+	key, err := crypto.GenerateX25519Key()
+	if err != nil {
+		slog.Error("failed to generate key", "err", err)
+		return 1
+	}
+	private, err := crypto.MarshalX25519PrivateKey(key)
+	if err != nil {
+		slog.Error("failed to encode key", "err", err)
+		return 1
+	}
+	public, err := crypto.MarshalX25519PublicKey(key.PublicKey())
+	if err != nil {
+		slog.Error("failed to encode key", "err", err)
+		return 1
+	}
+
+	if err := writeNewFile(path, private, 0600); err != nil {
+		slog.Error("failed to write private key", "path", path, "err", err)
+		return 1
+	}
+	if err := writeNewFile(path+".pub", public, 0644); err != nil {
+		slog.Error("failed to write public key", "path", path+".pub", "err", err)
+		return 1
+	}
+	slog.Info("generated export key; export with the public key & keep the private key to import",
+		"private", path,
+		"public", path+".pub",
+		"fingerprint", crypto.Fingerprint(key.PublicKey()))
+	return 0
+}
+
+// writeNewFile writes data to path, which must not exist yet.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func Export(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	recipientPath := flags.String("recipient", "", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	dest := flags.Arg(0)
+
+	var recipient *ecdh.PublicKey
+	var passphrase []byte
+	if *recipientPath != "" {
+		data, err := os.ReadFile(*recipientPath)
+		if err != nil {
+			slog.Error("failed to read recipient key", "path", *recipientPath, "err", err)
+			return 1
+		}
+		if recipient, err = crypto.ParseX25519PublicKey(data); err != nil {
+			slog.Error("failed to load recipient key", "path", *recipientPath, "err", err)
+			return 1
+		}
+	} else {
+		var err error
+		if passphrase, err = exportPassphrase(true)(); err != nil {
+			slog.Error("failed to read export passphrase", "err", err)
+			return 1
+		}
+		if len(passphrase) == 0 {
+			slog.Error("failed to read export passphrase", "err", crypto.ErrEmptyPassphrase)
+			return 1
+		}
+	}
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	entries, err := db.ExportEntries()
+	if err != nil {
+		slog.Error("failed to export entries", "err", err)
+		return 1
+	}
+	buf := new(bytes.Buffer)
+	if err := archive.Write(buf, entries, passphrase, recipient); err != nil {
+		slog.Error("failed to export entries", "err", err)
+		return 1
+	}
+	if err := writeNewFile(dest, buf.Bytes(), 0600); err != nil {
+		slog.Error("failed to write archive", "dest", dest, "err", err)
+		return 1
+	}
+	slog.Info("exported entries", "count", len(entries), "dest", dest)
+	return 0
+}
+
+func Import(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	identityPath := flags.String("identity", "", "")
+	onConflict := flags.String("on-conflict", string(passddb.ConflictFail), "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		printHelp()
+		return 1
+	}
+	src := flags.Arg(0)
+
+	policy, err := passddb.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		slog.Error("invalid --on-conflict", "err", err)
+		return 1
+	}
+	var identity *ecdh.PrivateKey
+	if *identityPath != "" {
+		data, err := os.ReadFile(*identityPath)
+		if err != nil {
+			slog.Error("failed to read identity key", "path", *identityPath, "err", err)
+			return 1
+		}
+		if identity, err = crypto.ParseX25519PrivateKey(data); err != nil {
+			slog.Error("failed to load identity key", "path", *identityPath, "err", err)
+			return 1
+		}
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		slog.Error("failed to open archive", "src", src, "err", err)
+		return 1
+	}
+	defer f.Close()
+	entries, err := archive.Read(f, exportPassphrase(false), identity)
+	if err != nil {
+		slog.Error("failed to read archive", "src", src, "err", err)
+		return 1
+	}
+
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	result, err := db.ImportEntries(entries, policy)
+	if err != nil {
+		slog.Error("failed to import entries; nothing was imported", "src", src, "err", err)
+		return 1
+	}
+	slog.Info("imported entries",
+		"created", len(result.Created),
+		"overwritten", len(result.Overwritten),
+		"skipped", result.Skipped)
+	for _, r := range result.SkippedRoutes {
+		slog.Warn("skipped route that already leads to another entry", "host", r.Host, "pathPrefix", r.PathPrefix, "id", r.EntryId)
+	}
+	return 0
+}
+
+type ExportRequest struct {
+	Passphrase string `json:"passphrase" xml:"passphrase" form:"passphrase"`
+	// Recipient is a PEM-encoded X25519 public key, used if there is no
+	// passphrase.
+	Recipient string `json:"recipient" xml:"recipient" form:"recipient"`
+}
+
+type ImportResponse struct {
+	Created       []string        `json:"created"`
+	Overwritten   []string        `json:"overwritten"`
+	Skipped       []string        `json:"skipped"`
+	SkippedRoutes []RouteResponse `json:"skipped_routes"`
+}
+
+// sendExport writes every entry to an archive sealed as the request asks.
+func sendExport(ctx *fiber.Ctx) error {
+	requestPayload := new(ExportRequest)
+	if err := ctx.BodyParser(requestPayload); err != nil {
+		slog.Debug("invalid request body for export", "err", err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "could not parse request body"})
+	}
+	var recipient *ecdh.PublicKey
+	if requestPayload.Passphrase == "" {
+		if requestPayload.Recipient == "" {
+			return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "passphrase or recipient is required"})
+		}
+		var err error
+		if recipient, err = crypto.ParseX25519PublicKey([]byte(requestPayload.Recipient)); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "recipient must be a PEM-encoded X25519 public key"})
+		}
+	}
+
+	entries, err := DB.ExportEntries()
+	if err != nil {
+		slog.Error("failed to export entries", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to export entries"})
+	}
+	buf := new(bytes.Buffer)
+	if err := archive.Write(buf, entries, []byte(requestPayload.Passphrase), recipient); err != nil {
+		slog.Error("failed to write archive", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to export entries"})
+	}
+
+	for _, e := range entries {
+		recordAdminAction(ctx, passddb.ActionExport, e.Metadata.Id)
+	}
+	ctx.Type("json")
+	ctx.Attachment(fmt.Sprintf("passd-export-%s.json", time.Now().UTC().Format("20060102T150405Z")))
+	return ctx.SendStream(buf)
+}
+
+// formText returns the named field of a multipart form, whether it was sent
+// as a value or as a file, or nil if it wasn't sent.
+func formText(ctx *fiber.Ctx, name string) ([]byte, error) {
+	if value := ctx.FormValue(name); value != "" {
+		return []byte(value), nil
+	}
+	header, err := ctx.FormFile(name)
+	if err != nil {
+		return nil, nil
+	}
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// receiveImport imports the archive uploaded as the archive field of a
+// multipart form, opened with its passphrase or identity field.
+func receiveImport(ctx *fiber.Ctx) error {
+	policy, err := passddb.ParseConflictPolicy(ctx.FormValue("on_conflict"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "on_conflict must be fail, skip or overwrite"})
+	}
+	var identity *ecdh.PrivateKey
+	pem, err := formText(ctx, "identity")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "could not read identity"})
+	}
+	if pem != nil {
+		if identity, err = crypto.ParseX25519PrivateKey(pem); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "identity must be a PEM-encoded X25519 private key"})
+		}
+	}
+	var passphrase crypto.PassphraseFunc
+	if p := ctx.FormValue("passphrase"); p != "" {
+		passphrase = func() ([]byte, error) { return []byte(p), nil }
+	}
+
+	header, err := ctx.FormFile("archive")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "archive file is required"})
+	}
+	f, err := header.Open()
+	if err != nil {
+		slog.Error("failed to open uploaded archive", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to import entries"})
+	}
+	defer f.Close()
+	entries, err := archive.Read(f, passphrase, identity)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: err.Error()})
+	}
+
+	result, err := DB.ImportEntries(entries, policy)
+	if errors.Is(err, passddb.ErrImportConflict) {
+		return ctx.Status(fiber.StatusConflict).JSON(passdapi.ErrorResponse{Message: err.Error()})
+	} else if errors.Is(err, passddb.ErrInvalidImport) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: err.Error()})
+	} else if err != nil {
+		slog.Error("failed to import entries", "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to import entries"})
+	}
+
+	for _, id := range append(result.Created, result.Overwritten...) {
+		recordAdminAction(ctx, passddb.ActionImport, id)
+	}
+	return ctx.JSON(ImportResponse{
+		Created:       result.Created,
+		Overwritten:   result.Overwritten,
+		Skipped:       result.Skipped,
+		SkippedRoutes: utils.Map(result.SkippedRoutes, newRouteResponse),
+	})
+}
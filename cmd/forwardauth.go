@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/session"
+)
+
+var (
+	DefaultSessionCookieName string = "passd_session"
+	ForwardAuthEntryHeader   string = "X-Passd-Entry"
+
+	// Headers the proxy reports the original request in: X-Forwarded-* are
+	// sent by Traefik & Caddy, X-Original-* are the usual nginx convention.
+	ForwardedHostHeaders []string = []string{fiber.HeaderXForwardedHost, "X-Original-Host"}
+	ForwardedUriHeaders  []string = []string{"X-Forwarded-Uri", "X-Original-URI"}
+)
+
+//go:embed templates/login.html
+var loginPageTemplate string
+
+var loginPage = template.Must(template.New("login").Parse(loginPageTemplate))
+
+type LoginPage struct {
+	Redirect string
+	Error    string
+}
+
+func sessionCookieName() string {
+	if name := os.Getenv("PASSD_SESSION_COOKIE"); name != "" {
+		return name
+	}
+	return DefaultSessionCookieName
+}
+
+// sessionCookieSecure reports whether the session cookie is only sent over
+// HTTPS, which is the default. passd usually sits behind a proxy that
+// terminates TLS, so the protocol it sees says nothing about what the browser
+// used; PASSD_SESSION_COOKIE_SECURE=false allows plain HTTP, e.g. locally.
+func sessionCookieSecure() bool {
+	value := strings.TrimSpace(os.Getenv("PASSD_SESSION_COOKIE_SECURE"))
+	if value == "" {
+		return true
+	}
+	secure, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("invalid value for PASSD_SESSION_COOKIE_SECURE; using secure cookies", "value", value)
+		return true
+	}
+	return secure
+}
+
+// loginRedirect returns the URL of the login page at login, with rd set to
+// the page to return to afterwards.
+func loginRedirect(login string, rd string) string {
+	u, err := url.Parse(safeRedirect(login))
+	if err != nil {
+		u = &url.URL{Path: "/"}
+	}
+	query := u.Query()
+	query.Set("rd", rd)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func firstHeader(ctx *fiber.Ctx, names []string) string {
+	for _, name := range names {
+		if value := ctx.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// forwardedHost returns the host the client originally requested, falling
+// back to the Host header for requests proxied straight through.
+func forwardedHost(ctx *fiber.Ctx) string {
+	if host := firstHeader(ctx, ForwardedHostHeaders); host != "" {
+		return host
+	}
+	return ctx.Hostname()
+}
+
+// safeRedirect returns rd if it is a path on the same host, or "/" otherwise,
+// so that the login page can't be used as an open redirect.
+func safeRedirect(rd string) string {
+	if !strings.HasPrefix(rd, "/") || strings.HasPrefix(rd, "//") || strings.HasPrefix(rd, "/\\") {
+		return "/"
+	}
+	return rd
+}
+
+func renderLoginPage(ctx *fiber.Ctx, status int, page LoginPage) error {
+	buf := new(bytes.Buffer)
+	if err := loginPage.Execute(buf, page); err != nil {
+		slog.Error("failed to render login page", "err", err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+	ctx.Type("html")
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.Status(status).SendStream(buf)
+}
+
+// forwardAuth answers nginx auth_request & Traefik/Caddy forward auth
+// subrequests: 200 if the request carries a session for the entry that
+// protects the requested host & path, 401 if it doesn't & 403 if no entry
+// protects it. If a login query parameter is given, unauthenticated requests
+// are instead redirected there, for proxies that pass the response through.
+func forwardAuth(ctx *fiber.Ctx) error {
+	host, uri := forwardedHost(ctx), firstHeader(ctx, ForwardedUriHeaders)
+	if uri == "" {
+		uri = "/"
+	}
+	route, err := DB.ResolveRoute(host, uri)
+	if errors.Is(err, passddb.ErrInvalidPath) {
+		slog.Info("rejecting forward auth request with invalid path", "host", host, "uri", uri)
+		return ctx.SendStatus(fiber.StatusForbidden)
+	}
+	if err != nil {
+		slog.Error("failed to resolve route", "host", host, "uri", uri, "err", err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+	if route == nil {
+		slog.Info("rejecting forward auth request with no matching route", "host", host, "uri", uri)
+		return ctx.SendStatus(fiber.StatusForbidden)
+	}
+
+	if token := requestSessionToken(ctx); token != "" {
+		s, err := verifySessionToken(token)
+		if err == nil && s.EntryId == route.EntryId {
+			ctx.Set(ForwardAuthEntryHeader, route.EntryId)
+			return ctx.SendStatus(fiber.StatusOK)
+		}
+		if err != nil && !errors.Is(err, session.ErrInvalidToken) {
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+		slog.Debug("rejecting invalid session for forward auth", "host", host, "uri", uri, "id", route.EntryId, "err", err)
+	}
+
+	if login := ctx.Query("login"); login != "" {
+		return ctx.Redirect(loginRedirect(login, uri))
+	}
+	return ctx.SendStatus(fiber.StatusUnauthorized)
+}
+
+func forwardAuthLoginPage(ctx *fiber.Ctx) error {
+	return renderLoginPage(ctx, fiber.StatusOK, LoginPage{Redirect: safeRedirect(ctx.Query("rd"))})
+}
+
+// forwardAuthLogin checks the password posted from the login page against the
+// entry protecting the page being redirected to, &, if it matches, sets the
+// session cookie that forwardAuth looks for.
+func forwardAuthLogin(ctx *fiber.Ctx) error {
+	page := LoginPage{Redirect: safeRedirect(ctx.FormValue("rd"))}
+	host := forwardedHost(ctx)
+	route, err := DB.ResolveRoute(host, page.Redirect)
+	if errors.Is(err, passddb.ErrInvalidPath) {
+		page.Error = "This page isn't password protected."
+		return renderLoginPage(ctx, fiber.StatusBadRequest, page)
+	}
+	if err != nil {
+		slog.Error("failed to resolve route", "host", host, "uri", page.Redirect, "err", err)
+		page.Error = "Something went wrong; please try again later."
+		return renderLoginPage(ctx, fiber.StatusInternalServerError, page)
+	}
+	if route == nil {
+		page.Error = "This page isn't password protected."
+		return renderLoginPage(ctx, fiber.StatusNotFound, page)
+	}
+
+	result, credential, wait, err := checkPassword(ctx, route.EntryId, ctx.FormValue("password"), false)
+	if err != nil {
+		page.Error = "Something went wrong; please try again later."
+		return renderLoginPage(ctx, fiber.StatusInternalServerError, page)
+	}
+	if wait > 0 {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		page.Error = "Too many failed attempts; try again later."
+		return renderLoginPage(ctx, fiber.StatusTooManyRequests, page)
+	}
+	if result != passddb.ResultSuccess {
+		page.Error = "Incorrect password."
+		return renderLoginPage(ctx, fiber.StatusUnauthorized, page)
+	}
+
+	token, expiresAt, err := issueSession(route.EntryId, credential)
+	if err != nil {
+		page.Error = "Something went wrong; please try again later."
+		return renderLoginPage(ctx, fiber.StatusInternalServerError, page)
+	}
+	ctx.Cookie(&fiber.Cookie{
+		Name:     sessionCookieName(),
+		Value:    token,
+		Path:     "/",
+		Domain:   os.Getenv("PASSD_SESSION_COOKIE_DOMAIN"),
+		Expires:  expiresAt,
+		Secure:   sessionCookieSecure(),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return ctx.Redirect(page.Redirect, fiber.StatusSeeOther)
+}
+
+func forwardAuthLogout(ctx *fiber.Ctx) error {
+	ctx.Cookie(&fiber.Cookie{
+		Name:     sessionCookieName(),
+		Path:     "/",
+		Domain:   os.Getenv("PASSD_SESSION_COOKIE_DOMAIN"),
+		Expires:  time.Unix(0, 0),
+		Secure:   sessionCookieSecure(),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return ctx.Redirect(safeRedirect(ctx.Query("rd")), fiber.StatusSeeOther)
+}
+
+type RouteResponse struct {
+	Host       string    `json:"host"`
+	PathPrefix string    `json:"path_prefix"`
+	EntryId    string    `json:"entry_id"`
+	CreatedOn  time.Time `json:"created_on"`
+}
+
+func newRouteResponse(r passddb.Route) RouteResponse {
+	return RouteResponse{
+		Host:       r.Host,
+		PathPrefix: r.PathPrefix,
+		EntryId:    r.EntryId,
+		CreatedOn:  r.CreatedOn,
+	}
+}
+
+type SetRouteRequest struct {
+	Host       string `json:"host" xml:"host" form:"host"`
+	PathPrefix string `json:"path_prefix" xml:"path_prefix" form:"path_prefix"`
+	EntryId    string `json:"entry_id" xml:"entry_id" form:"entry_id"`
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/session"
+)
+
+// newForwardAuthApp points the package's globals at a fresh in-memory store
+// protecting party.example.com/party with the entry "party", & returns an app
+// serving the forward auth endpoints.
+func newForwardAuthApp(t *testing.T) *fiber.App {
+	t.Helper()
+	key, err := crypto.GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	store, err := passddb.NewMemoryStore(key)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	manager, err := session.NewManager(store, DefaultSessionIssuer, DefaultSessionAudience, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create session manager: %v", err)
+	}
+	oldDb, oldSessions, oldLimiter := DB, Sessions, Limiter
+	DB, Sessions, Limiter = store, manager, nil
+	t.Cleanup(func() { DB, Sessions, Limiter = oldDb, oldSessions, oldLimiter })
+
+	if err := store.CreatePassword("party", "secret", passddb.StorageModeEncrypted); err != nil {
+		t.Fatalf("failed to create entry: %v", err)
+	}
+	if _, err := store.SetRoute(passddb.Route{Host: "party.example.com", PathPrefix: "/party", EntryId: "party"}); err != nil {
+		t.Fatalf("failed to create route: %v", err)
+	}
+
+	app := fiber.New()
+	app.All("/forward-auth", forwardAuth)
+	app.Route("/forward-auth", func(login fiber.Router) {
+		login.Get("/login", forwardAuthLoginPage)
+		login.Post("/login", forwardAuthLogin)
+		login.Get("/logout", forwardAuthLogout)
+	})
+	return app
+}
+
+func send(t *testing.T, app *fiber.App, req *http.Request) *http.Response {
+	t.Helper()
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func authRequest(uri string, cookie *http.Cookie) *http.Request {
+	return authRequestTo("/forward-auth", uri, cookie)
+}
+
+func authRequestTo(target string, uri string, cookie *http.Cookie) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set(fiber.HeaderXForwardedHost, "party.example.com")
+	req.Header.Set("X-Forwarded-Uri", uri)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func login(t *testing.T, app *fiber.App, password string, rd string) *http.Response {
+	t.Helper()
+	form := url.Values{"password": {password}, "rd": {rd}}
+	req := httptest.NewRequest(http.MethodPost, "/forward-auth/login", strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.Header.Set(fiber.HeaderXForwardedHost, "party.example.com")
+	return send(t, app, req)
+}
+
+func sessionCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	t.Helper()
+	for _, c := range resp.Cookies() {
+		if c.Name == DefaultSessionCookieName {
+			return c
+		}
+	}
+	t.Fatalf("expected a session cookie")
+	return nil
+}
+
+func TestForwardAuth(t *testing.T) {
+	app := newForwardAuthApp(t)
+
+	for uri, expected := range map[string]int{
+		"/party/photos":         fiber.StatusUnauthorized,
+		"/public":               fiber.StatusForbidden,
+		"/partyphotos":          fiber.StatusForbidden,
+		"/public/../party":      fiber.StatusUnauthorized,
+		"/%70arty":              fiber.StatusUnauthorized,
+		"/party%2fphotos":       fiber.StatusForbidden,
+		"/public?x=/party":      fiber.StatusForbidden,
+		"/public/..%2fparty":    fiber.StatusForbidden,
+		"/public/%2e%2e/party/": fiber.StatusUnauthorized,
+	} {
+		if resp := send(t, app, authRequest(uri, nil)); resp.StatusCode != expected {
+			t.Errorf("%s: expected %d, got %d", uri, expected, resp.StatusCode)
+		}
+	}
+
+	// Proxies that pass the response through get sent to the login page
+	resp := send(t, app, authRequestTo("/forward-auth?login=/.passd/login", "/party/photos?size=large", nil))
+	location, _ := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if resp.StatusCode != fiber.StatusFound || location.Path != "/.passd/login" || location.Query().Get("rd") != "/party/photos?size=large" {
+		t.Fatalf("expected a redirect to the login page, got %d to %s", resp.StatusCode, location)
+	}
+}
+
+func TestForwardAuthLogin(t *testing.T) {
+	app := newForwardAuthApp(t)
+
+	resp := login(t, app, "wrong", "/party/photos")
+	if resp.StatusCode != fiber.StatusUnauthorized || len(resp.Cookies()) != 0 {
+		t.Fatalf("expected a wrong password to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := login(t, app, "secret", "/public"); resp.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected a page without a route to be refused, got %d", resp.StatusCode)
+	}
+
+	// Redirects off the site are replaced, so the form can't be used as an
+	// open redirect
+	for rd, expected := range map[string]string{
+		"/party/photos":        "/party/photos",
+		"https://evil.example": "/",
+		"//evil.example/party": "/",
+		"/\\evil.example":      "/",
+	} {
+		page := send(t, app, httptest.NewRequest(http.MethodGet, "/forward-auth/login?rd="+url.QueryEscape(rd), nil))
+		body, _ := io.ReadAll(page.Body)
+		if page.StatusCode != fiber.StatusOK || !strings.Contains(string(body), `value="`+expected+`"`) {
+			t.Errorf("%s: expected the login page to return to %s:\n%s", rd, expected, body)
+		}
+	}
+
+	resp = login(t, app, "secret", "/party/photos")
+	if resp.StatusCode != fiber.StatusSeeOther || resp.Header.Get(fiber.HeaderLocation) != "/party/photos" {
+		t.Fatalf("expected a redirect back to the page, got %d to %s", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+	cookie := sessionCookie(t, resp)
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected a secure, HTTP only, same site cookie: %+v", cookie)
+	}
+
+	resp = send(t, app, authRequest("/party/photos", cookie))
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get(ForwardAuthEntryHeader) != "party" {
+		t.Fatalf("expected the session to be accepted, got %d", resp.StatusCode)
+	}
+
+	// Sessions end with the password they were issued for
+	if _, err := DB.UpsertPassword("party", "changed", passddb.StorageModeEncrypted); err != nil {
+		t.Fatalf("failed to change password: %v", err)
+	}
+	if resp := send(t, app, authRequest("/party/photos", cookie)); resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected the session to end with the password, got %d", resp.StatusCode)
+	}
+
+	resp = send(t, app, httptest.NewRequest(http.MethodGet, "/forward-auth/logout?rd=/party", nil))
+	if resp.StatusCode != fiber.StatusSeeOther || resp.Header.Get(fiber.HeaderLocation) != "/party" {
+		t.Fatalf("expected a redirect after logging out, got %d", resp.StatusCode)
+	}
+	if cookie := sessionCookie(t, resp); cookie.Value != "" || !cookie.Expires.Before(time.Now()) {
+		t.Fatalf("expected the cookie to be cleared: %+v", cookie)
+	}
+}
+
+// A session for one entry doesn't unlock pages protected by another.
+func TestForwardAuthOtherEntry(t *testing.T) {
+	app := newForwardAuthApp(t)
+	if err := DB.CreatePassword("other", "other", passddb.StorageModeEncrypted); err != nil {
+		t.Fatalf("failed to create entry: %v", err)
+	}
+	if _, err := DB.SetRoute(passddb.Route{Host: "party.example.com", PathPrefix: "/other", EntryId: "other"}); err != nil {
+		t.Fatalf("failed to create route: %v", err)
+	}
+
+	cookie := sessionCookie(t, login(t, app, "other", "/other"))
+	if resp := send(t, app, authRequest("/other/x", cookie)); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected the session to be accepted for its own entry, got %d", resp.StatusCode)
+	}
+	if resp := send(t, app, authRequest("/party/photos", cookie)); resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected the session to be rejected for another entry, got %d", resp.StatusCode)
+	}
+}
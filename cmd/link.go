@@ -0,0 +1,100 @@
+package main
+
+import (
+	"html"
+	"log/slog"
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrshanahan/simple-password-service/internal/cache"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/render"
+)
+
+var LinkPromptFile string = "link.html"
+
+// renderLinkPrompt renders the password prompt of an entry's /p/:id link from
+// the static files, showing errorMessage if it isn't empty.
+func renderLinkPrompt(ctx *fiber.Ctx, files cache.Cache, status int, id string, errorMessage string) error {
+	content, err := files.Get(LinkPromptFile)
+	if err != nil {
+		slog.Error("failed to get file from cache", "filename", LinkPromptFile, "error", err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+	renderer, err := render.NewRenderer(map[string]string{
+		// The form posts back to ./<id>, so id is escaped as a path segment
+		"Id":    html.EscapeString(url.PathEscape(id)),
+		"Error": html.EscapeString(errorMessage),
+	})
+	if err != nil {
+		slog.Error("failed to create renderer", "filename", LinkPromptFile, "error", err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	ctx.Type("html")
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.Status(status).Send(renderer.Render(content))
+}
+
+// linkId is the id of the entry a /p/:id link is for. The path segment is
+// still percent-encoded, so it is decoded to match the entry's id.
+func linkId(ctx *fiber.Ctx) (string, bool) {
+	id, err := url.PathUnescape(ctx.Params("id"))
+	if err != nil {
+		slog.Debug("rejecting link with invalid id", "id", ctx.Params("id"), "err", err)
+		return "", false
+	}
+	return id, true
+}
+
+// showLink renders the password prompt of an entry's /p/:id link.
+func showLink(ctx *fiber.Ctx, files cache.Cache) error {
+	id, ok := linkId(ctx)
+	if !ok {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+	return renderLinkPrompt(ctx, files, fiber.StatusOK, id, "")
+}
+
+// followLink checks the password posted to an entry's /p/:id link &, if it
+// matches, redirects to the entry's target. Otherwise the prompt is rendered
+// again with the reason.
+func followLink(ctx *fiber.Ctx, files cache.Cache) error {
+	id, ok := linkId(ctx)
+	if !ok {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+	result, _, wait, err := checkPassword(ctx, id, ctx.FormValue("password"), false)
+	if err != nil {
+		return renderLinkPrompt(ctx, files, fiber.StatusInternalServerError, id, "Something went wrong; please try again later.")
+	}
+	if wait > 0 {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return renderLinkPrompt(ctx, files, fiber.StatusTooManyRequests, id, "Too many failed attempts; try again later.")
+	}
+	if result != passddb.ResultSuccess {
+		return renderLinkPrompt(ctx, files, fiber.StatusUnauthorized, id, "Incorrect password.")
+	}
+
+	target, err := DB.GetRedirect(id)
+	if err != nil {
+		slog.Error("failed to load redirect", "id", id, "err", err)
+		return renderLinkPrompt(ctx, files, fiber.StatusInternalServerError, id, "Something went wrong; please try again later.")
+	}
+	if target == "" {
+		return renderLinkPrompt(ctx, files, fiber.StatusNotFound, id, "This link doesn't lead anywhere yet.")
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.Redirect(target, fiber.StatusSeeOther)
+}
+
+type SetRedirectRequest struct {
+	Url string `json:"url" xml:"url" form:"url"`
+}
+
+type GetRedirectResponse struct {
+	Id  string `json:"id"`
+	Url string `json:"url"`
+}
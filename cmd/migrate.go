@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+)
+
+func Migrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := flags.Bool("status", false, "")
+	target := flags.Int("to", 0, "")
+	if err := flags.Parse(args); err != nil {
+		printHelp()
+		return 1
+	}
+	if *status && *target != 0 {
+		slog.Error("--status & --to cannot be used together")
+		return 1
+	}
+	if *target < 0 {
+		slog.Error("--to must be a positive schema version", "value", *target)
+		return 1
+	}
+
+	db, err := resolveSchemaTarget()
+	if err != nil {
+		slog.Error("failed to resolve DB", "err", err)
+		return 1
+	}
+
+	if *status {
+		statuses, err := db.status(db.dsn)
+		if err != nil {
+			slog.Error("failed to load schema status", "db", db.name, "err", err)
+			return 1
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied && s.AppliedOn.IsZero() {
+				applied = "yes (pre-dates schema_version)"
+			} else if s.Applied {
+				applied = s.AppliedOn.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		if err := writer.Flush(); err != nil {
+			slog.Error("failed to write schema status", "err", err)
+			return 1
+		}
+		return 0
+	}
+
+	from, to, err := db.migrate(db.dsn, *target)
+	if err != nil {
+		slog.Error("failed to migrate DB schema", "db", db.name, "err", err)
+		return 1
+	}
+	if from == to {
+		slog.Info("no migrations to apply", "db", db.name, "version", from)
+	} else {
+		slog.Info("migrated DB schema", "db", db.name, "from", from, "to", to)
+	}
+	return 0
+}
+
+// schemaTarget is a DB whose schema is managed by the migrate command.
+type schemaTarget struct {
+	dsn string
+	// name describes the DB in logs. Postgres URLs aren't logged since they
+	// may contain credentials.
+	name    string
+	status  func(dsn string) ([]passddb.MigrationStatus, error)
+	migrate func(dsn string, target int) (int, int, error)
+}
+
+func resolveSchemaTarget() (*schemaTarget, error) {
+	switch backend := dbBackend(); backend {
+	case DBBackendSQLite:
+		dbPath, err := resolveDbPath()
+		if err != nil {
+			return nil, err
+		}
+		return &schemaTarget{dbPath, dbPath, passddb.SchemaStatus, passddb.Migrate}, nil
+	case DBBackendPostgres:
+		dbUrl, err := resolveDbUrl()
+		if err != nil {
+			return nil, err
+		}
+		return &schemaTarget{dbUrl, DBBackendPostgres, passddb.PostgresSchemaStatus, passddb.MigratePostgres}, nil
+	default:
+		return nil, fmt.Errorf("the %s backend has no schema to migrate", backend)
+	}
+}
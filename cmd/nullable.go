@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Nullable is a request field that tells leaving it out apart from setting it
+// to null (or, in forms, to an empty value), so that updates only change the
+// fields they were given.
+type Nullable[T any] struct {
+	// Set is true if the field was given at all, even as null.
+	Set   bool
+	Value T
+}
+
+func (n *Nullable[T]) UnmarshalJSON(data []byte) error {
+	*n = Nullable[T]{Set: true}
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &n.Value)
+}
+
+func (n *Nullable[T]) UnmarshalText(text []byte) error {
+	*n = Nullable[T]{Set: true}
+	if len(text) == 0 {
+		return nil
+	}
+	switch value := any(&n.Value).(type) {
+	case *time.Time:
+		return value.UnmarshalText(text)
+	case *int:
+		i, err := strconv.Atoi(string(text))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q: %w", text, err)
+		}
+		*value = i
+		return nil
+	default:
+		return fmt.Errorf("cannot parse %T from text", n.Value)
+	}
+}
+
+// Ptr returns nil if the field wasn't given & otherwise a pointer to its
+// value, which is the zero value if it was null.
+func (n Nullable[T]) Ptr() *T {
+	if !n.Set {
+		return nil
+	}
+	return &n.Value
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/session"
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+)
+
+var (
+	MaxPayloadSize     int    = 1 << 20
+	DefaultPayloadType string = fiber.MIMETextPlainCharsetUTF8
+)
+
+// isTextPayload reports whether content of the given type can be embedded in
+// JSON without encoding it.
+func isTextPayload(contentType string, content []byte) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	isText := strings.HasPrefix(mediaType, "text/") || mediaType == fiber.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json")
+	return isText && utf8.Valid(content)
+}
+
+func newPayloadResponse(p passddb.Payload) passdapi.PayloadResponse {
+	response := passdapi.PayloadResponse{ContentType: p.ContentType, Filename: p.Filename}
+	if isTextPayload(p.ContentType, p.Content) {
+		response.Encoding, response.Content = passdapi.PayloadEncodingText, string(p.Content)
+	} else {
+		response.Encoding, response.Content = passdapi.PayloadEncodingBase64, base64.StdEncoding.EncodeToString(p.Content)
+	}
+	return response
+}
+
+func newPayloadInfoResponse(p *passddb.PayloadInfo) *passdapi.PayloadInfoResponse {
+	if p == nil {
+		return nil
+	}
+	return &passdapi.PayloadInfoResponse{
+		ContentType: p.ContentType,
+		Filename:    p.Filename,
+		Size:        p.Size,
+		UpdatedOn:   p.UpdatedOn,
+	}
+}
+
+// sendPayload writes a payload as the raw response body.
+func sendPayload(ctx *fiber.Ctx, p *passddb.Payload) error {
+	if p.Filename != "" {
+		ctx.Attachment(p.Filename)
+	}
+	ctx.Set(fiber.HeaderContentType, p.ContentType)
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.Send(p.Content)
+}
+
+// sessionPayload releases the payload of the entry that the request's session
+// token (from /validate or the forward auth login page) was issued for.
+func sessionPayload(ctx *fiber.Ctx) error {
+	token := requestSessionToken(ctx)
+	if token == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(passdapi.ErrorResponse{Message: "session token must be provided"})
+	}
+	s, err := verifySessionToken(token)
+	if errors.Is(err, session.ErrInvalidToken) {
+		slog.Debug("rejecting invalid session token", "err", err)
+		return ctx.Status(fiber.StatusUnauthorized).JSON(passdapi.ErrorResponse{Message: "invalid session token"})
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to verify session"})
+	}
+	if id := ctx.Query("id"); id != "" && id != s.EntryId {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(passdapi.ErrorResponse{Message: "invalid session token"})
+	}
+
+	payload, err := DB.GetPayload(s.EntryId)
+	if err != nil {
+		slog.Error("failed to load payload", "id", s.EntryId, "err", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to load payload"})
+	}
+	if payload == nil {
+		return ctx.Status(fiber.StatusNotFound).JSON(passdapi.ErrorResponse{Message: "entry has no payload"})
+	}
+	return sendPayload(ctx, payload)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+	"github.com/mrshanahan/simple-password-service/internal/session"
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+)
+
+var (
+	Sessions *session.Manager
+
+	DefaultSessionIssuer       string        = "passd"
+	DefaultSessionAudience     string        = "passd-session"
+	DefaultSessionTTL          time.Duration = time.Hour
+	SigningKeyReloadInterval   time.Duration = 5 * time.Minute
+	JwksCacheMaxAge            time.Duration = 5 * time.Minute
+	SessionAuthorizationPrefix string        = "Bearer "
+)
+
+func newSessionManager(store session.Store) (*session.Manager, error) {
+	issuer := os.Getenv("PASSD_SESSION_ISSUER")
+	if issuer == "" {
+		issuer = DefaultSessionIssuer
+	}
+	audience := os.Getenv("PASSD_SESSION_AUDIENCE")
+	if audience == "" {
+		audience = DefaultSessionAudience
+	}
+	return session.NewManager(store, issuer, audience, envDuration("PASSD_SESSION_TTL", DefaultSessionTTL))
+}
+
+// reloadSigningKeysInBackground periodically picks up signing keys rotated
+// with the rotate-signing-key command & drops ones that are no longer needed.
+func reloadSigningKeysInBackground(manager *session.Manager) {
+	for {
+		time.Sleep(SigningKeyReloadInterval)
+		if err := manager.Reload(); err != nil {
+			slog.Error("failed to reload session signing keys", "err", err)
+		}
+	}
+}
+
+func RotateSigningKey() int {
+	db, err := openDb()
+	if err != nil {
+		slog.Error("failed to open DB", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	manager, err := newSessionManager(db)
+	if err != nil {
+		slog.Error("failed to load session signing keys", "err", err)
+		return 1
+	}
+	id, err := manager.Rotate()
+	if err != nil {
+		slog.Error("failed to rotate session signing key", "err", err)
+		return 1
+	}
+	slog.Info("rotated session signing key; tokens signed with the previous key remain valid until they expire",
+		"id", id,
+		"ttl", manager.TTL())
+	return 0
+}
+
+// requestSessionToken returns the session token sent with a request, either
+// in the forward auth session cookie or as a bearer token.
+func requestSessionToken(ctx *fiber.Ctx) string {
+	if token := ctx.Cookies(sessionCookieName()); token != "" {
+		return token
+	}
+	if authorization := ctx.Get(fiber.HeaderAuthorization); strings.HasPrefix(authorization, SessionAuthorizationPrefix) {
+		return strings.TrimPrefix(authorization, SessionAuthorizationPrefix)
+	}
+	return ""
+}
+
+// issueSession issues a session token for the entry with the given id, whose
+// credential with the given name was just validated. Sessions don't outlive
+// the entry they were issued for, nor the credential; see verifySessionToken.
+func issueSession(id string, credential string) (string, time.Time, error) {
+	entry, err := DB.GetEntryMetadata(id)
+	if err != nil {
+		slog.Error("failed to load entry metadata", "id", id, "err", err)
+		return "", time.Time{}, err
+	}
+	if entry == nil {
+		slog.Error("entry was deleted before its session could be issued", "id", id)
+		return "", time.Time{}, passddb.ErrEntryNotFound
+	}
+	token, expiresAt, err := Sessions.Issue(id, entry.CredentialFingerprint(credential), entry.ExpiresAt)
+	if err != nil {
+		slog.Error("failed to issue session token", "id", id, "err", err)
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// verifySessionToken checks a session token & that the entry & credential it
+// was issued for haven't changed since: tokens are rejected with
+// session.ErrInvalidToken once the entry is deleted or the credential's
+// password is replaced or removed. Other errors are failures to check.
+func verifySessionToken(token string) (session.Session, error) {
+	s, err := Sessions.Verify(token)
+	if err != nil {
+		return session.Session{}, err
+	}
+	entry, err := DB.GetEntryMetadata(s.EntryId)
+	if err != nil {
+		slog.Error("failed to load entry metadata", "id", s.EntryId, "err", err)
+		return session.Session{}, err
+	}
+	if entry == nil {
+		return session.Session{}, fmt.Errorf("%w: entry no longer exists", session.ErrInvalidToken)
+	}
+	for _, c := range entry.Credentials {
+		if subtle.ConstantTimeCompare([]byte(entry.CredentialFingerprint(c.Name)), []byte(s.Credential)) == 1 {
+			return s, nil
+		}
+	}
+	return session.Session{}, fmt.Errorf("%w: credential has changed", session.ErrInvalidToken)
+}
+
+// verifySession checks a session token passed either in the request body or
+// as a bearer token, optionally requiring it to be for a particular entry.
+func verifySession(ctx *fiber.Ctx) error {
+	requestPayload := new(VerifySessionRequest)
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(requestPayload); err != nil {
+			slog.Debug("invalid request body for verifying session", "err", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "could not parse request body"})
+		}
+	}
+	if requestPayload.Id == "" {
+		requestPayload.Id = ctx.Query("id")
+	}
+	if authorization := ctx.Get(fiber.HeaderAuthorization); requestPayload.Token == "" && strings.HasPrefix(authorization, SessionAuthorizationPrefix) {
+		requestPayload.Token = strings.TrimPrefix(authorization, SessionAuthorizationPrefix)
+	}
+	if requestPayload.Token == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(passdapi.ErrorResponse{Message: "token must be provided"})
+	}
+
+	s, err := verifySessionToken(requestPayload.Token)
+	if errors.Is(err, session.ErrInvalidToken) {
+		slog.Debug("rejecting invalid session token", "err", err)
+		return ctx.JSON(VerifySessionResponse{Valid: false})
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(passdapi.ErrorResponse{Message: "failed to verify session"})
+	}
+	if requestPayload.Id != "" && requestPayload.Id != s.EntryId {
+		return ctx.JSON(VerifySessionResponse{Valid: false})
+	}
+	return ctx.JSON(VerifySessionResponse{
+		Valid:     true,
+		Id:        s.EntryId,
+		ExpiresAt: &s.ExpiresAt,
+	})
+}
+
+type VerifySessionRequest struct {
+	Token string `json:"token" xml:"token" form:"token"`
+	Id    string `json:"id" xml:"id" form:"id"`
+}
+
+type VerifySessionResponse struct {
+	Valid     bool       `json:"valid"`
+	Id        string     `json:"id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
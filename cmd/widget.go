@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mrshanahan/simple-password-service/internal/cache"
+	"github.com/mrshanahan/simple-password-service/internal/render"
+)
+
+var (
+	// WidgetDir holds the widget's versions, e.g. widget/v1/passd-widget.js,
+	// within the static files directory. Published versions should never
+	// change incompatibly, since sites embed them directly.
+	WidgetDir         string        = "widget"
+	WidgetCacheMaxAge time.Duration = 5 * time.Minute
+)
+
+// publicBaseUrl returns the URL that passd is reachable at by the browsers of
+// sites embedding the widget.
+func publicBaseUrl(ctx *fiber.Ctx) string {
+	if baseUrl := os.Getenv("PASSD_PUBLIC_URL"); baseUrl != "" {
+		return strings.TrimSuffix(baseUrl, "/")
+	}
+	return ctx.BaseURL()
+}
+
+// serveWidget renders a version of the validation widget from the static
+// files, pointing it at this service's public URL.
+func serveWidget(ctx *fiber.Ctx, files cache.Cache) error {
+	filename := path.Clean("/" + ctx.Params("*"))
+	if !strings.HasSuffix(filename, ".js") {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+	content, err := files.Get(path.Join(WidgetDir, filename))
+	if os.IsNotExist(err) {
+		return ctx.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		slog.Error("failed to get file from cache", "filename", filename, "error", err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+	renderer, err := render.NewRenderer(map[string]string{
+		"BaseUrl": publicBaseUrl(ctx),
+	})
+	if err != nil {
+		slog.Error("failed to create renderer", "filename", filename, "error", err)
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	ctx.Type(".js")
+	ctx.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(WidgetCacheMaxAge.Seconds())))
+	return ctx.Send(renderer.Render(content))
+}
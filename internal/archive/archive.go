@@ -0,0 +1,241 @@
+// Package archive reads & writes the portable format passd exports entries
+// in: a versioned JSON file whose entries are sealed under an export
+// passphrase or recipient public key rather than any instance's keyring, so
+// that they can be imported by an instance with a different key.
+package archive
+
+import (
+	"crypto/ecdh"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+)
+
+const (
+	archiveFormat  string = "passd-export"
+	archiveVersion int    = 1
+)
+
+// associatedData binds the sealed entries to the format & version they are
+// written in.
+var associatedData []byte = fmt.Appendf(nil, "%s:%d", archiveFormat, archiveVersion)
+
+// file is what is written out. Only the envelope's ciphertext holds anything
+// about the entries, not even how many there are.
+type file struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedOn time.Time `json:"created_on"`
+	crypto.Envelope
+}
+
+type contents struct {
+	Entries []entry `json:"entries"`
+}
+
+type entry struct {
+	Id              string     `json:"id"`
+	CreatedOn       time.Time  `json:"created_on"`
+	UpdatedOn       time.Time  `json:"updated_on"`
+	LastValidatedOn *time.Time `json:"last_validated_on"`
+	ValidationCount int        `json:"validation_count"`
+	Description     string     `json:"description"`
+	Tags            []string   `json:"tags"`
+	NotBefore       *time.Time `json:"not_before"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	// MaxUses is nil if the entry can be used any number of times.
+	MaxUses        *int         `json:"max_uses"`
+	UseCount       int          `json:"use_count"`
+	AllowedOrigins []string     `json:"allowed_origins"`
+	Credentials    []credential `json:"credentials"`
+	Payload        *payload     `json:"payload"`
+	Redirect       string       `json:"redirect"`
+	Routes         []route      `json:"routes"`
+}
+
+type credential struct {
+	Name        string `json:"name"`
+	StorageMode string `json:"storage_mode"`
+	// Secret is the password, or its verifier if StorageMode is hash.
+	Secret    string     `json:"secret"`
+	CreatedOn time.Time  `json:"created_on"`
+	NotBefore *time.Time `json:"not_before"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type payload struct {
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename"`
+	Content     []byte    `json:"content"`
+	UpdatedOn   time.Time `json:"updated_on"`
+}
+
+type route struct {
+	Host       string    `json:"host"`
+	PathPrefix string    `json:"path_prefix"`
+	CreatedOn  time.Time `json:"created_on"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func requiredTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func newEntry(e passddb.ExportedEntry) entry {
+	m := e.Metadata
+	a := entry{
+		Id:              m.Id,
+		CreatedOn:       m.CreatedOn,
+		UpdatedOn:       m.UpdatedOn,
+		LastValidatedOn: optionalTime(m.LastValidatedOn),
+		ValidationCount: m.ValidationCount,
+		Description:     m.Description,
+		Tags:            m.Tags,
+		NotBefore:       optionalTime(m.NotBefore),
+		ExpiresAt:       optionalTime(m.ExpiresAt),
+		UseCount:        m.UseCount,
+		AllowedOrigins:  m.AllowedOrigins,
+		Credentials:     []credential{},
+		Redirect:        e.Redirect,
+		Routes:          []route{},
+	}
+	if m.MaxUses > 0 {
+		maxUses := m.MaxUses
+		a.MaxUses = &maxUses
+	}
+	for _, c := range e.Credentials {
+		a.Credentials = append(a.Credentials, credential{
+			Name:        c.Name,
+			StorageMode: string(c.StorageMode),
+			Secret:      c.Secret,
+			CreatedOn:   c.CreatedOn,
+			NotBefore:   optionalTime(c.NotBefore),
+			ExpiresAt:   optionalTime(c.ExpiresAt),
+		})
+	}
+	if p := e.Payload; p != nil {
+		a.Payload = &payload{p.ContentType, p.Filename, p.Content, p.UpdatedOn}
+	}
+	for _, r := range e.Routes {
+		a.Routes = append(a.Routes, route{r.Host, r.PathPrefix, r.CreatedOn})
+	}
+	return a
+}
+
+func (a entry) exported() passddb.ExportedEntry {
+	e := passddb.ExportedEntry{
+		Metadata: passddb.EntryMetadata{
+			Id:              a.Id,
+			CreatedOn:       a.CreatedOn,
+			UpdatedOn:       a.UpdatedOn,
+			LastValidatedOn: requiredTime(a.LastValidatedOn),
+			ValidationCount: a.ValidationCount,
+			Description:     a.Description,
+			Tags:            a.Tags,
+			NotBefore:       requiredTime(a.NotBefore),
+			ExpiresAt:       requiredTime(a.ExpiresAt),
+			UseCount:        a.UseCount,
+			AllowedOrigins:  a.AllowedOrigins,
+		},
+		Redirect: a.Redirect,
+	}
+	if a.MaxUses != nil {
+		e.Metadata.MaxUses = *a.MaxUses
+	}
+	for _, c := range a.Credentials {
+		e.Credentials = append(e.Credentials, passddb.ExportedCredential{
+			Credential: passddb.Credential{
+				Name:        c.Name,
+				StorageMode: passddb.StorageMode(c.StorageMode),
+				CreatedOn:   c.CreatedOn,
+				NotBefore:   requiredTime(c.NotBefore),
+				ExpiresAt:   requiredTime(c.ExpiresAt),
+			},
+			Secret: c.Secret,
+		})
+	}
+	if p := a.Payload; p != nil {
+		e.Payload = &passddb.Payload{ContentType: p.ContentType, Filename: p.Filename, Content: p.Content, UpdatedOn: p.UpdatedOn}
+	}
+	for _, r := range a.Routes {
+		e.Routes = append(e.Routes, passddb.Route{Host: r.Host, PathPrefix: r.PathPrefix, EntryId: a.Id, CreatedOn: r.CreatedOn})
+	}
+	return e
+}
+
+// Write writes entries to w, sealed under passphrase or, if it is empty, for
+// recipient.
+func Write(w io.Writer, entries []passddb.ExportedEntry, passphrase []byte, recipient *ecdh.PublicKey) error {
+	c := contents{Entries: []entry{}}
+	for _, e := range entries {
+		c.Entries = append(c.Entries, newEntry(e))
+	}
+	plaintext, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode entries: %w", err)
+	}
+
+	var envelope *crypto.Envelope
+	switch {
+	case len(passphrase) > 0:
+		envelope, err = crypto.SealWithPassphrase(plaintext, passphrase, associatedData)
+	case recipient != nil:
+		envelope, err = crypto.SealForRecipient(plaintext, recipient, associatedData)
+	default:
+		err = fmt.Errorf("a passphrase or recipient key is required")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to seal entries: %w", err)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(file{archiveFormat, archiveVersion, time.Now().UTC(), *envelope}); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// Read reads the entries in the archive r, opening it with passphrase or
+// identity depending on how it was sealed. passphrase is only invoked if the
+// archive was sealed with one, & either may be nil if it isn't expected.
+func Read(r io.Reader, passphrase crypto.PassphraseFunc, identity *ecdh.PrivateKey) ([]passddb.ExportedEntry, error) {
+	var f file
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse archive: %w", err)
+	}
+	if f.Format != archiveFormat {
+		return nil, fmt.Errorf("unrecognized archive format: %q", f.Format)
+	}
+	if f.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version: %d", f.Version)
+	}
+
+	plaintext, err := f.Open(associatedData, passphrase, identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	var c contents
+	if err := json.Unmarshal(plaintext, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse archive entries: %w", err)
+	}
+
+	entries := []passddb.ExportedEntry{}
+	for _, a := range c.Entries {
+		entries = append(entries, a.exported())
+	}
+	return entries, nil
+}
@@ -0,0 +1,139 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+	passddb "github.com/mrshanahan/simple-password-service/internal/db"
+)
+
+func testEntries(t *testing.T) []passddb.ExportedEntry {
+	verifier, err := crypto.NewVerifier([]byte("guests"))
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	on := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []passddb.ExportedEntry{
+		{
+			Metadata: passddb.EntryMetadata{
+				Id:              "party",
+				CreatedOn:       on,
+				UpdatedOn:       on.Add(time.Hour),
+				LastValidatedOn: on.Add(2 * time.Hour),
+				ValidationCount: 3,
+				Description:     "Party",
+				Tags:            []string{"a", "b"},
+				NotBefore:       on,
+				ExpiresAt:       on.Add(24 * time.Hour),
+				MaxUses:         10,
+				UseCount:        3,
+				AllowedOrigins:  []string{"https://party.example.com"},
+			},
+			Credentials: []passddb.ExportedCredential{
+				{Credential: passddb.Credential{Name: "default", StorageMode: passddb.StorageModeEncrypted, CreatedOn: on}, Secret: "secret"},
+				{Credential: passddb.Credential{Name: "guests", StorageMode: passddb.StorageModeHash, CreatedOn: on, ExpiresAt: on.Add(time.Hour)}, Secret: verifier},
+			},
+			Payload:  &passddb.Payload{ContentType: "text/plain", Filename: "address.txt", Content: []byte("1 Main St"), UpdatedOn: on},
+			Redirect: "https://party.example.com/details",
+			Routes:   []passddb.Route{{Host: "party.example.com", PathPrefix: "/", EntryId: "party", CreatedOn: on}},
+		},
+		{
+			Metadata: passddb.EntryMetadata{
+				Id:             "plain",
+				CreatedOn:      on,
+				UpdatedOn:      on,
+				Tags:           []string{},
+				AllowedOrigins: []string{},
+			},
+			Credentials: []passddb.ExportedCredential{
+				{Credential: passddb.Credential{Name: "default", StorageMode: passddb.StorageModeEncrypted, CreatedOn: on}, Secret: "plain"},
+			},
+		},
+	}
+}
+
+func TestPassphraseRoundTrip(t *testing.T) {
+	entries := testEntries(t)
+	var b bytes.Buffer
+	if err := Write(&b, entries, []byte("hunter2"), nil); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	if strings.Contains(b.String(), "party") || strings.Contains(b.String(), "secret") {
+		t.Fatalf("expected nothing about the entries in the clear: %s", b.String())
+	}
+
+	read, err := Read(bytes.NewReader(b.Bytes()), func() ([]byte, error) { return []byte("hunter2"), nil }, nil)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Fatalf("expected the entries to round-trip:\nwrote %+v\n read %+v", entries, read)
+	}
+
+	if _, err := Read(bytes.NewReader(b.Bytes()), func() ([]byte, error) { return []byte("wrong"), nil }, nil); !errors.Is(err, crypto.ErrIncorrectPassphrase) {
+		t.Fatalf("expected ErrIncorrectPassphrase, got %v", err)
+	}
+}
+
+func TestRecipientRoundTrip(t *testing.T) {
+	identity, err := crypto.GenerateX25519Key()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	entries := testEntries(t)
+	var b bytes.Buffer
+	if err := Write(&b, entries, nil, identity.PublicKey()); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	read, err := Read(bytes.NewReader(b.Bytes()), nil, identity)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Fatalf("expected the entries to round-trip:\nwrote %+v\n read %+v", entries, read)
+	}
+
+	if err := Write(&b, entries, nil, nil); err == nil {
+		t.Fatalf("expected writing without a passphrase or recipient to fail")
+	}
+}
+
+// The format & version are bound to the ciphertext, so changing them can't
+// make an archive be read as another format.
+func TestArchiveHeader(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, testEntries(t), []byte("hunter2"), nil); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(b.Bytes(), &raw); err != nil {
+		t.Fatalf("failed to parse archive: %v", err)
+	}
+
+	for name, change := range map[string]func(map[string]any){
+		"format":  func(f map[string]any) { f["format"] = "other" },
+		"version": func(f map[string]any) { f["version"] = archiveVersion + 1 },
+		"method":  func(f map[string]any) { f["method"] = "rot13" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := map[string]any{}
+			for k, v := range raw {
+				f[k] = v
+			}
+			change(f)
+			changed, err := json.Marshal(f)
+			if err != nil {
+				t.Fatalf("failed to encode archive: %v", err)
+			}
+			if _, err := Read(bytes.NewReader(changed), func() ([]byte, error) { return []byte("hunter2"), nil }, nil); err == nil {
+				t.Fatalf("expected an archive with a changed %s to be rejected", name)
+			}
+		})
+	}
+}
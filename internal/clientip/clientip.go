@@ -0,0 +1,76 @@
+package clientip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Resolver determines the address of the client that originated a request,
+// taking into account reverse proxies that report it via a header such as
+// X-Forwarded-For. The header is only honored when the request came directly
+// from a trusted proxy, since anyone else can set it to whatever they like.
+type Resolver struct {
+	Header  string
+	Trusted []netip.Prefix
+}
+
+// ParseTrustedProxies parses a comma-separated list of IPs and/or CIDRs.
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %w", part, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		} else {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy IP %q: %w", part, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	return prefixes, nil
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range r.Trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP given the address of the peer that sent the
+// request & the value of the configured proxy header. Addresses in the header
+// are walked from right to left (i.e. from the closest proxy outwards) & the
+// first one that isn't itself a trusted proxy is returned.
+func (r *Resolver) Resolve(remoteAddr string, headerValue string) string {
+	remote, err := netip.ParseAddr(remoteAddr)
+	if err != nil || r.Header == "" || headerValue == "" || !r.isTrusted(remote) {
+		return remoteAddr
+	}
+
+	hops := strings.Split(headerValue, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Anything to the left of a garbled entry can't be trusted
+			break
+		}
+		if !r.isTrusted(hop) {
+			return hop.Unmap().String()
+		}
+		remote = hop
+	}
+	return remote.Unmap().String()
+}
@@ -0,0 +1,80 @@
+package clientip
+
+import (
+	"testing"
+)
+
+func newResolver(t *testing.T, trusted string) *Resolver {
+	t.Helper()
+	prefixes, err := ParseTrustedProxies(trusted)
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+	return &Resolver{Header: "X-Forwarded-For", Trusted: prefixes}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies(" 10.0.0.0/8, 192.168.1.7 ,, ::ffff:172.16.0.1, fd00::/8,")
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+	expected := []string{"10.0.0.0/8", "192.168.1.7/32", "172.16.0.1/32", "fd00::/8"}
+	if len(prefixes) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, prefixes)
+	}
+	for i, p := range prefixes {
+		if p.String() != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, prefixes)
+		}
+	}
+
+	if prefixes, err := ParseTrustedProxies(""); err != nil || len(prefixes) != 0 {
+		t.Fatalf("expected no trusted proxies, got %v, %v", prefixes, err)
+	}
+	for _, invalid := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.1, 300.0.0.1"} {
+		if _, err := ParseTrustedProxies(invalid); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r := newResolver(t, "10.0.0.0/8, 192.168.1.7")
+	for _, tt := range []struct {
+		name     string
+		remote   string
+		header   string
+		expected string
+	}{
+		{"no header", "10.0.0.1", "", "10.0.0.1"},
+		{"untrusted peer", "203.0.113.9", "198.51.100.1", "203.0.113.9"},
+		{"trusted peer", "10.0.0.1", "198.51.100.1", "198.51.100.1"},
+		{"single trusted proxy", "192.168.1.7", "198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1", "198.51.100.1, 10.1.1.1, 10.2.2.2", "198.51.100.1"},
+		// Whatever the client put in the header itself is to the left of
+		// the first untrusted address & must be ignored
+		{"spoofed hops", "10.0.0.1", "1.2.3.4, 198.51.100.1, 10.1.1.1", "198.51.100.1"},
+		{"garbled hop", "10.0.0.1", "1.2.3.4, garbage, 10.1.1.1", "10.1.1.1"},
+		{"every hop trusted", "10.0.0.1", "10.3.3.3, 10.1.1.1", "10.3.3.3"},
+		{"mapped addresses", "::ffff:10.0.0.1", "::ffff:198.51.100.1", "198.51.100.1"},
+		{"IPv6 client", "10.0.0.1", "2001:db8::1", "2001:db8::1"},
+		{"unparseable peer", "not-an-ip", "198.51.100.1", "not-an-ip"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := r.Resolve(tt.remote, tt.header); actual != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+
+	// Without a configured header, or without trusted proxies, the header is
+	// never believed
+	for name, r := range map[string]*Resolver{
+		"no header":  {Trusted: r.Trusted},
+		"no proxies": {Header: "X-Forwarded-For"},
+	} {
+		if actual := r.Resolve("10.0.0.1", "198.51.100.1"); actual != "10.0.0.1" {
+			t.Fatalf("%s: expected the peer address, got %s", name, actual)
+		}
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"fmt"
 	"io"
-	"os"
 )
 
 const (
@@ -33,23 +33,18 @@ func GeneratePassdKey() (PassdKey, error) {
 	return PassdKey{key}, nil
 }
 
-func Load(keyFile *os.File) (PassdKey, error) {
-	keyData, err := io.ReadAll(keyFile)
-	if err != nil {
-		return PassdKey{}, fmt.Errorf("failed to read key file: %w", err)
-	}
-
-	return NewPassdKey(keyData)
+// Encrypt seals plaintext with AES-GCM. additionalData is authenticated but
+// not encrypted, & the same value must be provided to Decrypt.
+func (k *PassdKey) Encrypt(plaintext []byte, additionalData []byte) ([]byte, error) {
+	return k.seal(nil, plaintext, additionalData)
 }
 
-func (k *PassdKey) Save(path string) error {
-	if err := os.WriteFile(path, k.key, 0o600); err != nil {
-		return fmt.Errorf("failed to write key: %w", err)
-	}
-	return nil
+func (k *PassdKey) Decrypt(ciphertext []byte, additionalData []byte) ([]byte, error) {
+	return k.open(ciphertext, additionalData)
 }
 
-func (k *PassdKey) Encrypt(plaintext []byte) ([]byte, error) {
+// seal encrypts plaintext & appends the nonce and resulting ciphertext to dst.
+func (k *PassdKey) seal(dst []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(k.key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize cipher block: %w", err)
@@ -65,12 +60,12 @@ func (k *PassdKey) Encrypt(plaintext []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to generate GCM nonce: %w", err)
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := gcm.Seal(append(dst, nonce...), nonce, plaintext, additionalData)
 
 	return ciphertext, nil
 }
 
-func (k *PassdKey) Decrypt(ciphertext []byte) ([]byte, error) {
+func (k *PassdKey) open(ciphertext []byte, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(k.key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize cipher block: %w", err)
@@ -88,7 +83,7 @@ func (k *PassdKey) Decrypt(ciphertext []byte) ([]byte, error) {
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt ciphertext: %w", err)
 	}
@@ -96,11 +91,11 @@ func (k *PassdKey) Decrypt(ciphertext []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-func Hash(data []byte) ([]byte, error) {
-	hash := sha256.New()
-	n, err := hash.Write(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash bytes (%d/%d bytes): %w", n, len(data), err)
-	}
-	return hash.Sum(nil), nil
+// ComparePasswords reports whether expected & actual are equal without
+// leaking where they differ. Both are hashed first so that the comparison
+// doesn't leak their lengths either.
+func ComparePasswords(expected []byte, actual []byte) bool {
+	expectedHash := sha256.Sum256(expected)
+	actualHash := sha256.Sum256(actual)
+	return subtle.ConstantTimeCompare(expectedHash[:], actualHash[:]) == 1
 }
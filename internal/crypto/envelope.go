@@ -0,0 +1,230 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	EnvelopePassphrase string = "passphrase"
+	EnvelopeX25519     string = "x25519"
+
+	envelopeX25519Info string = "passd-envelope-x25519:1"
+)
+
+var (
+	ErrNoPassphrase     error = errors.New("sealed with a passphrase but no passphrase was provided")
+	ErrNoIdentity       error = errors.New("sealed for a recipient key but no private key was provided")
+	ErrIncorrectKey     error = errors.New("private key does not match the recipient")
+	ErrInvalidX25519Key error = errors.New("not a PEM-encoded X25519 key")
+	ErrUnknownEnvelope  error = errors.New("unsupported envelope method")
+	ErrTamperedEnvelope error = errors.New("envelope is corrupt or has been tampered with")
+)
+
+// Envelope holds data sealed for use outside of passd, so under something
+// other than the keyring: either a key derived from a passphrase (as for
+// wrapped key files) or an X25519 key pair, of which only the holder of the
+// private key can open it.
+type Envelope struct {
+	Method string `json:"method"`
+	// Kdf is set for EnvelopePassphrase.
+	Kdf *kdfParams `json:"kdf,omitempty"`
+	// Recipient is the fingerprint of the public key an EnvelopeX25519 was
+	// sealed for, & EphemeralKey the public half of the key pair it was
+	// sealed with.
+	Recipient    string `json:"recipient,omitempty"`
+	EphemeralKey []byte `json:"ephemeral_key,omitempty"`
+	Ciphertext   []byte `json:"ciphertext"`
+}
+
+// SealWithPassphrase seals plaintext under a key derived from passphrase with
+// Argon2id. associatedData must be given again to open it.
+func SealWithPassphrase(plaintext []byte, passphrase []byte, associatedData []byte) (*Envelope, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+	params, err := newKdfParams()
+	if err != nil {
+		return nil, err
+	}
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := key.Encrypt(plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Method: EnvelopePassphrase, Kdf: &params, Ciphertext: ciphertext}, nil
+}
+
+// SealForRecipient seals plaintext so that only the holder of the private key
+// matching recipient can open it. associatedData must be given again to open
+// it.
+func SealForRecipient(plaintext []byte, recipient *ecdh.PublicKey, associatedData []byte) (*Envelope, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to agree on key: %w", err)
+	}
+	key, err := x25519Key(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := key.Encrypt(plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Method:       EnvelopeX25519,
+		Recipient:    Fingerprint(recipient),
+		EphemeralKey: ephemeral.PublicKey().Bytes(),
+		Ciphertext:   ciphertext,
+	}, nil
+}
+
+// x25519Key derives the key an EnvelopeX25519 is sealed with from the secret
+// shared between the ephemeral & recipient keys, binding both into it.
+func x25519Key(shared []byte, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) (PassdKey, error) {
+	salt := append(bytes.Clone(ephemeral.Bytes()), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, envelopeX25519Info, KeySize)
+	if err != nil {
+		return PassdKey{}, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return NewPassdKey(key)
+}
+
+// Open opens the envelope with associatedData, using passphrase or identity
+// depending on how it was sealed. passphrase is only invoked if it is needed,
+// & either may be nil if it isn't expected.
+func (e *Envelope) Open(associatedData []byte, passphrase PassphraseFunc, identity *ecdh.PrivateKey) ([]byte, error) {
+	switch e.Method {
+	case EnvelopePassphrase:
+		if e.Kdf == nil {
+			return nil, ErrTamperedEnvelope
+		}
+		if passphrase == nil {
+			return nil, ErrNoPassphrase
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if len(pass) == 0 {
+			return nil, ErrEmptyPassphrase
+		}
+		key, err := e.Kdf.deriveKey(pass)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := key.Decrypt(e.Ciphertext, associatedData)
+		if err != nil {
+			return nil, ErrIncorrectPassphrase
+		}
+		return plaintext, nil
+	case EnvelopeX25519:
+		if identity == nil {
+			return nil, ErrNoIdentity
+		}
+		if e.Recipient != Fingerprint(identity.PublicKey()) {
+			return nil, fmt.Errorf("%w (sealed for %s, not %s)", ErrIncorrectKey, e.Recipient, Fingerprint(identity.PublicKey()))
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(e.EphemeralKey)
+		if err != nil {
+			return nil, ErrTamperedEnvelope
+		}
+		shared, err := identity.ECDH(ephemeral)
+		if err != nil {
+			return nil, fmt.Errorf("failed to agree on key: %w", err)
+		}
+		key, err := x25519Key(shared, ephemeral, identity.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := key.Decrypt(e.Ciphertext, associatedData)
+		if err != nil {
+			return nil, ErrTamperedEnvelope
+		}
+		return plaintext, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEnvelope, e.Method)
+	}
+}
+
+// GenerateX25519Key generates a key pair for SealForRecipient.
+func GenerateX25519Key() (*ecdh.PrivateKey, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// Fingerprint identifies a public key by the start of its SHA-256 hash.
+func Fingerprint(key *ecdh.PublicKey) string {
+	hash := sha256.Sum256(key.Bytes())
+	return hex.EncodeToString(hash[:8])
+}
+
+// MarshalX25519PrivateKey encodes key as a PKCS #8 PEM block, as written by
+// `openssl genpkey -algorithm x25519`.
+func MarshalX25519PrivateKey(key *ecdh.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalX25519PublicKey encodes key as a PKIX PEM block, as written by
+// `openssl pkey -pubout`.
+func MarshalX25519PublicKey(key *ecdh.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func ParseX25519PrivateKey(data []byte) (*ecdh.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, ErrInvalidX25519Key
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(*ecdh.PrivateKey)
+	if !ok || key.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidX25519Key
+	}
+	return key, nil
+}
+
+func ParseX25519PublicKey(data []byte) (*ecdh.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, ErrInvalidX25519Key
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	key, ok := parsed.(*ecdh.PublicKey)
+	if !ok || key.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidX25519Key
+	}
+	return key, nil
+}
@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func passphrase(p string) PassphraseFunc {
+	return func() ([]byte, error) { return []byte(p), nil }
+}
+
+func TestPassphraseEnvelope(t *testing.T) {
+	envelope, err := SealWithPassphrase([]byte("entries"), []byte("hunter2"), []byte("ad"))
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	plaintext, err := envelope.Open([]byte("ad"), passphrase("hunter2"), nil)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if string(plaintext) != "entries" {
+		t.Fatalf("unexpected plaintext: %q", plaintext)
+	}
+
+	if _, err := envelope.Open([]byte("ad"), passphrase("hunter3"), nil); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Fatalf("expected ErrIncorrectPassphrase, got %v", err)
+	}
+	if _, err := envelope.Open([]byte("other"), passphrase("hunter2"), nil); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Fatalf("expected other associated data to fail, got %v", err)
+	}
+	if _, err := envelope.Open([]byte("ad"), nil, nil); !errors.Is(err, ErrNoPassphrase) {
+		t.Fatalf("expected ErrNoPassphrase, got %v", err)
+	}
+	if _, err := SealWithPassphrase([]byte("entries"), nil, nil); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Fatalf("expected ErrEmptyPassphrase, got %v", err)
+	}
+}
+
+func TestRecipientEnvelope(t *testing.T) {
+	identity, err := GenerateX25519Key()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	other, err := GenerateX25519Key()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	envelope, err := SealForRecipient([]byte("entries"), identity.PublicKey(), []byte("ad"))
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	if envelope.Recipient != Fingerprint(identity.PublicKey()) {
+		t.Fatalf("expected the recipient's fingerprint, got %s", envelope.Recipient)
+	}
+	plaintext, err := envelope.Open([]byte("ad"), nil, identity)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if string(plaintext) != "entries" {
+		t.Fatalf("unexpected plaintext: %q", plaintext)
+	}
+
+	if _, err := envelope.Open([]byte("ad"), nil, other); !errors.Is(err, ErrIncorrectKey) {
+		t.Fatalf("expected ErrIncorrectKey, got %v", err)
+	}
+	if _, err := envelope.Open([]byte("ad"), nil, nil); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("expected ErrNoIdentity, got %v", err)
+	}
+	if _, err := envelope.Open([]byte("other"), nil, identity); !errors.Is(err, ErrTamperedEnvelope) {
+		t.Fatalf("expected other associated data to fail, got %v", err)
+	}
+
+	tampered := *envelope
+	tampered.Ciphertext = bytes.Clone(envelope.Ciphertext)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 1
+	if _, err := tampered.Open([]byte("ad"), nil, identity); !errors.Is(err, ErrTamperedEnvelope) {
+		t.Fatalf("expected ErrTamperedEnvelope, got %v", err)
+	}
+	tampered = *envelope
+	tampered.EphemeralKey = other.PublicKey().Bytes()
+	if _, err := tampered.Open([]byte("ad"), nil, identity); !errors.Is(err, ErrTamperedEnvelope) {
+		t.Fatalf("expected a swapped ephemeral key to fail, got %v", err)
+	}
+	tampered = *envelope
+	tampered.Method = "rot13"
+	if _, err := tampered.Open([]byte("ad"), nil, identity); !errors.Is(err, ErrUnknownEnvelope) {
+		t.Fatalf("expected ErrUnknownEnvelope, got %v", err)
+	}
+}
+
+func TestX25519KeyEncoding(t *testing.T) {
+	key, err := GenerateX25519Key()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	privatePem, err := MarshalX25519PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode private key: %v", err)
+	}
+	publicPem, err := MarshalX25519PublicKey(key.PublicKey())
+	if err != nil {
+		t.Fatalf("failed to encode public key: %v", err)
+	}
+
+	parsed, err := ParseX25519PrivateKey(privatePem)
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	if !parsed.Equal(key) {
+		t.Fatalf("expected the private key to round-trip")
+	}
+	parsedPublic, err := ParseX25519PublicKey(publicPem)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+	if !parsedPublic.Equal(key.PublicKey()) {
+		t.Fatalf("expected the public key to round-trip")
+	}
+
+	if _, err := ParseX25519PrivateKey(publicPem); !errors.Is(err, ErrInvalidX25519Key) {
+		t.Fatalf("expected a public key to be rejected as a private key, got %v", err)
+	}
+	if _, err := ParseX25519PublicKey([]byte("not a key")); !errors.Is(err, ErrInvalidX25519Key) {
+		t.Fatalf("expected ErrInvalidX25519Key, got %v", err)
+	}
+}
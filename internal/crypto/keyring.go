@@ -0,0 +1,402 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// LegacyKeyId is the ID given to a bare, unversioned key file (i.e. the
+	// raw 32 bytes written by older versions of passd). Ciphertexts sealed
+	// with this key have no header.
+	LegacyKeyId uint32 = 0
+
+	keyringFileFormat  string = "passd-keyring"
+	keyringFileVersion int    = 1
+
+	// Version 1 ciphertexts did not authenticate their header or any
+	// associated data; they can only be opened via DecryptLegacy.
+	legacyCiphertextVersion byte = 1
+	ciphertextVersion       byte = 2
+	ciphertextHeaderSize    int  = 5
+)
+
+// Keyring holds every key passd knows about, each identified by a numeric ID.
+// New ciphertexts are always sealed with the primary key & prefixed with a
+// header containing that key's ID, so older ciphertexts remain decryptable
+// after a rotation as long as their key is still on the ring. A Keyring is
+// safe for concurrent use, so one can be shared & reloaded in place.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[uint32]keyringEntry
+	primary uint32
+
+	// passphrase, if set, is used to wrap the keyring whenever it is saved.
+	passphrase []byte
+}
+
+type keyringEntry struct {
+	key       PassdKey
+	createdOn time.Time
+}
+
+type keyringFile struct {
+	Format  string             `json:"format"`
+	Version int                `json:"version"`
+	Primary uint32             `json:"primary"`
+	Keys    []keyringFileEntry `json:"keys"`
+}
+
+type keyringFileEntry struct {
+	Id        uint32    `json:"id"`
+	Key       []byte    `json:"key"`
+	CreatedOn time.Time `json:"created_on"`
+}
+
+// NewKeyring creates a keyring whose only (and therefore primary) key is key.
+func NewKeyring(id uint32, key PassdKey) *Keyring {
+	return &Keyring{
+		keys:    map[uint32]keyringEntry{id: {key, time.Now().UTC()}},
+		primary: id,
+	}
+}
+
+// GenerateKeyring creates a keyring containing a single freshly-generated key.
+func GenerateKeyring() (*Keyring, error) {
+	key, err := GeneratePassdKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(LegacyKeyId+1, key), nil
+}
+
+// LoadKeyring reads a keyring from r. The JSON keyring format, the
+// passphrase-wrapped keyring format & the legacy format (a file containing
+// nothing but the raw key) are all supported; the latter yields a keyring
+// whose only key has ID LegacyKeyId. passphrase is only invoked if the file
+// is wrapped, & may be nil if a passphrase is not expected.
+func LoadKeyring(r io.Reader, passphrase PassphraseFunc) (*Keyring, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	if len(data) == KeySize {
+		key, err := NewPassdKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyring(LegacyKeyId, key), nil
+	}
+
+	if isWrappedKeyring(data) {
+		return unwrapKeyring(data, passphrase)
+	}
+	return parseKeyring(data)
+}
+
+func parseKeyring(data []byte) (*Keyring, error) {
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if file.Format != keyringFileFormat {
+		return nil, fmt.Errorf("unrecognized key file format: %q", file.Format)
+	}
+	if file.Version != keyringFileVersion {
+		return nil, fmt.Errorf("unsupported key file version: %d", file.Version)
+	}
+
+	keyring := &Keyring{keys: map[uint32]keyringEntry{}, primary: file.Primary}
+	for _, e := range file.Keys {
+		key, err := NewPassdKey(e.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key with id %d: %w", e.Id, err)
+		}
+		if _, exists := keyring.keys[e.Id]; exists {
+			return nil, fmt.Errorf("duplicate key id in key file: %d", e.Id)
+		}
+		keyring.keys[e.Id] = keyringEntry{key, e.CreatedOn}
+	}
+	if _, ok := keyring.keys[keyring.primary]; !ok {
+		return nil, fmt.Errorf("primary key id %d not found in key file", keyring.primary)
+	}
+	return keyring, nil
+}
+
+// Reload replaces the keys of k with those read from r, as by LoadKeyring,
+// so that everything sharing k picks up a key file that changed on disk. If
+// the file is wrapped & passphrase is nil, k's own passphrase is tried.
+func (k *Keyring) Reload(r io.Reader, passphrase PassphraseFunc) error {
+	if passphrase == nil {
+		k.mu.RLock()
+		current := k.passphrase
+		k.mu.RUnlock()
+		if len(current) > 0 {
+			passphrase = func() ([]byte, error) { return current, nil }
+		}
+	}
+	loaded, err := LoadKeyring(r, passphrase)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys, k.primary, k.passphrase = loaded.keys, loaded.primary, loaded.passphrase
+	return nil
+}
+
+func (k *Keyring) marshal() ([]byte, error) {
+	file := keyringFile{
+		Format:  keyringFileFormat,
+		Version: keyringFileVersion,
+		Primary: k.primary,
+		Keys:    []keyringFileEntry{},
+	}
+	for _, id := range k.ids() {
+		e := k.keys[id]
+		file.Keys = append(file.Keys, keyringFileEntry{id, e.key.key, e.createdOn})
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// Save writes the keyring to path, wrapped with the keyring's passphrase if
+// it has one. The file is written to a temporary location first & then
+// renamed over path, so a crash mid-write never leaves a truncated key file
+// behind.
+func (k *Keyring) Save(path string) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	data, err := k.marshal()
+	if err != nil {
+		return fmt.Errorf("failed to serialize keyring: %w", err)
+	}
+	if len(k.passphrase) > 0 {
+		if data, err = k.wrap(data); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set key file permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to flush key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close key file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to move key file into place: %w", err)
+	}
+	return nil
+}
+
+// Ids returns the IDs of every key on the ring in ascending order.
+func (k *Keyring) Ids() []uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.ids()
+}
+
+func (k *Keyring) ids() []uint32 {
+	ids := make([]uint32, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Primary returns the ID of the key used for all new encryptions.
+func (k *Keyring) Primary() uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+// Rotate generates a new key, adds it to the ring & makes it the primary key.
+// Existing keys are retained so their ciphertexts can still be decrypted.
+func (k *Keyring) Rotate() (uint32, error) {
+	key, err := GeneratePassdKey()
+	if err != nil {
+		return 0, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := slices.Max(k.ids()) + 1
+	k.keys[id] = keyringEntry{key, time.Now().UTC()}
+	k.primary = id
+	return id, nil
+}
+
+// Prune removes every key except the primary key from the ring & returns the
+// IDs of the removed keys. Callers must ensure nothing is still sealed with
+// the removed keys.
+func (k *Keyring) Prune() []uint32 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	removed := []uint32{}
+	for _, id := range k.ids() {
+		if id != k.primary {
+			delete(k.keys, id)
+			removed = append(removed, id)
+		}
+	}
+	return removed
+}
+
+// Encrypt seals plaintext with the primary key. The ciphertext header & the
+// given associatedData are both authenticated, so the ciphertext can only be
+// opened by passing the same associatedData to Decrypt.
+func (k *Keyring) Encrypt(plaintext []byte, associatedData []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	entry := k.keys[k.primary]
+	header := make([]byte, ciphertextHeaderSize, ciphertextHeaderSize+len(plaintext)+64)
+	header[0] = ciphertextVersion
+	binary.BigEndian.PutUint32(header[1:], k.primary)
+	return entry.key.seal(header, plaintext, withHeader(header, associatedData))
+}
+
+// Decrypt opens a ciphertext sealed by any key on the ring with the given
+// associatedData. Legacy ciphertexts are rejected; see DecryptLegacy.
+func (k *Keyring) Decrypt(ciphertext []byte, associatedData []byte) ([]byte, error) {
+	version, id, ok := parseHeader(ciphertext)
+	if !ok || version != ciphertextVersion {
+		return nil, fmt.Errorf("ciphertext is not in the current format")
+	}
+	k.mu.RLock()
+	entry, exists := k.keys[id]
+	k.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("ciphertext was sealed by unknown key %d", id)
+	}
+	header := ciphertext[:ciphertextHeaderSize]
+	return entry.key.open(ciphertext[ciphertextHeaderSize:], withHeader(header, associatedData))
+}
+
+// DecryptLegacy opens a ciphertext written before associated data was
+// supported: either a version 1 ciphertext or, if the legacy key is present,
+// an unversioned one. It exists only so that old data can be upgraded.
+func (k *Keyring) DecryptLegacy(ciphertext []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if version, id, ok := parseHeader(ciphertext); ok && version == legacyCiphertextVersion {
+		if entry, exists := k.keys[id]; exists {
+			plaintext, err := entry.key.open(ciphertext[ciphertextHeaderSize:], nil)
+			if err == nil {
+				return plaintext, nil
+			}
+			if _, hasLegacy := k.keys[LegacyKeyId]; !hasLegacy {
+				return nil, err
+			}
+		}
+	}
+
+	// An unversioned ciphertext is just nonce+ciphertext, so it can
+	// coincidentally look like it has a header; GCM authentication lets us
+	// safely fall back.
+	legacy, ok := k.keys[LegacyKeyId]
+	if !ok {
+		return nil, fmt.Errorf("ciphertext was not sealed by any known key")
+	}
+	return legacy.key.open(ciphertext, nil)
+}
+
+// IsLegacy reports whether ciphertext predates the current format & must be
+// opened with DecryptLegacy. An unversioned ciphertext has roughly a 2^-40
+// chance of being mistaken for a current one, which we accept.
+func (k *Keyring) IsLegacy(ciphertext []byte) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.isLegacy(ciphertext)
+}
+
+func (k *Keyring) isLegacy(ciphertext []byte) bool {
+	version, id, ok := parseHeader(ciphertext)
+	if !ok || version != ciphertextVersion {
+		return true
+	}
+	_, exists := k.keys[id]
+	return !exists
+}
+
+// IsCurrent reports whether ciphertext is in the current format & sealed with
+// the primary key.
+func (k *Keyring) IsCurrent(ciphertext []byte) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	version, id, ok := parseHeader(ciphertext)
+	return ok && version == ciphertextVersion && id == k.primary
+}
+
+// IsStale reports whether ciphertext should be re-sealed with the primary
+// key: it is legacy or sealed with an older key. Ciphertexts sealed with a
+// newer key than the primary are left alone, since whoever wrote them has
+// already loaded a rotated key file that this keyring has yet to see.
+func (k *Keyring) IsStale(ciphertext []byte) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	version, id, ok := parseHeader(ciphertext)
+	if ok && version == ciphertextVersion && id > k.primary {
+		return false
+	}
+	return k.isLegacy(ciphertext) || id < k.primary
+}
+
+// KeyId returns the ID of the key that sealed ciphertext. Unversioned
+// ciphertexts, & version 1 ones naming a key that isn't on the ring, are
+// taken to have been sealed by LegacyKeyId, just as DecryptLegacy does.
+func (k *Keyring) KeyId(ciphertext []byte) uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	version, id, ok := parseHeader(ciphertext)
+	if !ok {
+		return LegacyKeyId
+	}
+	if _, exists := k.keys[id]; !exists && version == legacyCiphertextVersion {
+		return LegacyKeyId
+	}
+	return id
+}
+
+func parseHeader(ciphertext []byte) (byte, uint32, bool) {
+	if len(ciphertext) < ciphertextHeaderSize {
+		return 0, 0, false
+	}
+	version := ciphertext[0]
+	if version != ciphertextVersion && version != legacyCiphertextVersion {
+		return 0, 0, false
+	}
+	return version, binary.BigEndian.Uint32(ciphertext[1:ciphertextHeaderSize]), true
+}
+
+func withHeader(header []byte, associatedData []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(associatedData))
+	ad = append(ad, header...)
+	return append(ad, associatedData...)
+}
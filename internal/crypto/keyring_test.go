@@ -0,0 +1,237 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecryptLegacy(t *testing.T) {
+	legacyKey, err := GeneratePassdKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyring := NewKeyring(LegacyKeyId, legacyKey)
+	if _, err := keyring.Rotate(); err != nil {
+		t.Fatalf("failed to rotate keyring: %v", err)
+	}
+
+	unversioned, err := legacyKey.Encrypt([]byte("unversioned"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	sealed, err := legacyKey.Encrypt([]byte("version 1"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	versioned := append([]byte{legacyCiphertextVersion, 0, 0, 0, byte(LegacyKeyId)}, sealed...)
+	current, err := keyring.Encrypt([]byte("current"), []byte("ad"))
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	for ciphertext, expected := range map[string]string{string(unversioned): "unversioned", string(versioned): "version 1"} {
+		if !keyring.IsLegacy([]byte(ciphertext)) || !keyring.IsStale([]byte(ciphertext)) {
+			t.Fatalf("expected %q to be legacy", expected)
+		}
+		if keyring.KeyId([]byte(ciphertext)) != LegacyKeyId {
+			t.Fatalf("expected %q to be sealed with the legacy key", expected)
+		}
+		plaintext, err := keyring.DecryptLegacy([]byte(ciphertext))
+		if err != nil {
+			t.Fatalf("failed to decrypt %q: %v", expected, err)
+		}
+		if string(plaintext) != expected {
+			t.Fatalf("expected %q, got %q", expected, plaintext)
+		}
+		if _, err := keyring.Decrypt([]byte(ciphertext), nil); err == nil {
+			t.Fatalf("expected Decrypt to reject legacy ciphertext %q", expected)
+		}
+	}
+
+	if keyring.IsLegacy(current) {
+		t.Fatalf("expected a current ciphertext not to be legacy")
+	}
+	if _, err := keyring.DecryptLegacy(current); err == nil {
+		t.Fatalf("expected DecryptLegacy to reject a current ciphertext")
+	}
+
+	// Without the legacy key, unversioned ciphertexts can't be opened at all
+	other, err := GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	if _, err := other.DecryptLegacy(unversioned); err == nil {
+		t.Fatalf("expected DecryptLegacy to fail without the legacy key")
+	}
+}
+
+// Ciphertexts name the key that sealed them & are bound to their header &
+// associated data.
+func TestKeyringEncrypt(t *testing.T) {
+	keyring, err := GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	ciphertext, err := keyring.Encrypt([]byte("secret"), []byte("ad"))
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if ciphertext[0] != ciphertextVersion || binary.BigEndian.Uint32(ciphertext[1:ciphertextHeaderSize]) != keyring.Primary() {
+		t.Fatalf("unexpected header: %x", ciphertext[:ciphertextHeaderSize])
+	}
+	if keyring.KeyId(ciphertext) != keyring.Primary() || !keyring.IsCurrent(ciphertext) || keyring.IsLegacy(ciphertext) {
+		t.Fatalf("expected the ciphertext to be current")
+	}
+
+	plaintext, err := keyring.Decrypt(ciphertext, []byte("ad"))
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if string(plaintext) != "secret" {
+		t.Fatalf("expected %q, got %q", "secret", plaintext)
+	}
+	if _, err := keyring.Decrypt(ciphertext, []byte("other")); err == nil {
+		t.Fatalf("expected other associated data to be rejected")
+	}
+	if _, err := keyring.Decrypt(ciphertext, nil); err == nil {
+		t.Fatalf("expected missing associated data to be rejected")
+	}
+
+	// Pointing the header at another key on the ring must not work either
+	if _, err := keyring.Rotate(); err != nil {
+		t.Fatalf("failed to rotate keyring: %v", err)
+	}
+	tampered := bytes.Clone(ciphertext)
+	binary.BigEndian.PutUint32(tampered[1:ciphertextHeaderSize], keyring.Primary())
+	if _, err := keyring.Decrypt(tampered, []byte("ad")); err == nil {
+		t.Fatalf("expected a tampered header to be rejected")
+	}
+}
+
+// Rotating keeps old ciphertexts readable & marks them stale until they are
+// re-sealed; pruning drops every key but the primary.
+func TestKeyringRotate(t *testing.T) {
+	keyring, err := GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	oldPrimary := keyring.Primary()
+	old, err := keyring.Encrypt([]byte("old"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	newPrimary, err := keyring.Rotate()
+	if err != nil {
+		t.Fatalf("failed to rotate keyring: %v", err)
+	}
+	if newPrimary <= oldPrimary || keyring.Primary() != newPrimary {
+		t.Fatalf("expected a new primary key after %d, got %d", oldPrimary, newPrimary)
+	}
+	if ids := keyring.Ids(); !slices.Equal(ids, []uint32{oldPrimary, newPrimary}) {
+		t.Fatalf("unexpected key ids: %v", ids)
+	}
+
+	if plaintext, err := keyring.Decrypt(old, nil); err != nil || string(plaintext) != "old" {
+		t.Fatalf("expected the old ciphertext to stay readable, got %q, %v", plaintext, err)
+	}
+	if !keyring.IsStale(old) || keyring.KeyId(old) != oldPrimary {
+		t.Fatalf("expected the old ciphertext to be stale")
+	}
+	current, err := keyring.Encrypt([]byte("new"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if keyring.IsStale(current) {
+		t.Fatalf("expected a ciphertext sealed with the primary key not to be stale")
+	}
+
+	if removed := keyring.Prune(); !slices.Equal(removed, []uint32{oldPrimary}) {
+		t.Fatalf("expected the old key to be pruned, removed %v", removed)
+	}
+	if _, err := keyring.Decrypt(old, nil); err == nil {
+		t.Fatalf("expected a ciphertext sealed with a pruned key not to decrypt")
+	}
+}
+
+// Saved keyrings load back with the same keys, & reloading one in place
+// picks up a rotation made elsewhere without losing the passphrase.
+func TestKeyringSaveAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passd.key")
+	keyring, err := GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	if err := keyring.SetPassphrase([]byte("hunter2")); err != nil {
+		t.Fatalf("failed to set passphrase: %v", err)
+	}
+	if err := keyring.Save(path); err != nil {
+		t.Fatalf("failed to save keyring: %v", err)
+	}
+	ciphertext, err := keyring.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	// Another process rotates the key file & writes with the new key
+	rotated := loadKeyringFile(t, path, passphrase("hunter2"))
+	if _, err := rotated.Rotate(); err != nil {
+		t.Fatalf("failed to rotate keyring: %v", err)
+	}
+	if err := rotated.Save(path); err != nil {
+		t.Fatalf("failed to save keyring: %v", err)
+	}
+	newer, err := rotated.Encrypt([]byte("newer"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if _, err := keyring.Decrypt(newer, nil); err == nil {
+		t.Fatalf("expected the new key to be unknown before reloading")
+	}
+	if keyring.IsStale(newer) {
+		t.Fatalf("expected a ciphertext sealed with a newer key not to be stale")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open key file: %v", err)
+	}
+	defer f.Close()
+	if err := keyring.Reload(f, nil); err != nil {
+		t.Fatalf("failed to reload keyring: %v", err)
+	}
+	if keyring.Primary() != rotated.Primary() || !keyring.IsPassphraseProtected() {
+		t.Fatalf("expected the rotated, passphrase-protected keyring after reloading")
+	}
+	for expected, c := range map[string][]byte{"secret": ciphertext, "newer": newer} {
+		if plaintext, err := keyring.Decrypt(c, nil); err != nil || string(plaintext) != expected {
+			t.Fatalf("expected %q after reloading, got %q, %v", expected, plaintext, err)
+		}
+	}
+
+	// A key file that can't be read leaves the keyring as it was
+	if err := keyring.Reload(strings.NewReader("not a key file"), nil); err == nil {
+		t.Fatalf("expected reloading an invalid key file to fail")
+	}
+	if keyring.Primary() != rotated.Primary() {
+		t.Fatalf("expected a failed reload to keep the current keys")
+	}
+}
+
+func loadKeyringFile(t *testing.T, path string, passphrase PassphraseFunc) *Keyring {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open key file: %v", err)
+	}
+	defer f.Close()
+	keyring, err := LoadKeyring(f, passphrase)
+	if err != nil {
+		t.Fatalf("failed to load keyring: %v", err)
+	}
+	return keyring
+}
@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Parameters for password verifiers. These are much lighter than the ones
+// used for the key file since a verifier is checked on every validation.
+const (
+	verifierSaltSize  int    = 16
+	verifierHashSize  uint32 = 32
+	verifierTime      uint32 = 2
+	verifierMemoryKiB uint32 = 19 * 1024
+	verifierThreads   uint8  = 1
+)
+
+// NewVerifier derives a one-way Argon2id verifier for password with a random
+// salt, encoded in the PHC string format
+// ($argon2id$v=19$m=...,t=...,p=...$salt$hash).
+func NewVerifier(password []byte) (string, error) {
+	salt := make([]byte, verifierSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	hash := argon2.IDKey(password, salt, verifierTime, verifierMemoryKiB, verifierThreads, verifierHashSize)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		verifierMemoryKiB,
+		verifierTime,
+		verifierThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// Limits on the parameters a stored verifier may use, so that one bad row
+// can't make checking it crash or exhaust the server's memory.
+const (
+	verifierMinSaltSize  int    = 8
+	verifierMinHashSize  int    = 16
+	verifierMaxHashSize  int    = 64
+	verifierMaxTime      uint32 = 16
+	verifierMaxMemoryKiB uint32 = 256 * 1024
+	verifierMaxThreads   uint8  = 16
+)
+
+type verifier struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+func parseVerifier(encoded string) (verifier, error) {
+	var v verifier
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return v, fmt.Errorf("invalid verifier format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return v, fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &v.memory, &v.time, &v.threads); err != nil {
+		return v, fmt.Errorf("invalid verifier parameters: %w", err)
+	}
+	if v.time < 1 || v.time > verifierMaxTime {
+		return v, fmt.Errorf("verifier time must be between 1 & %d: %d", verifierMaxTime, v.time)
+	}
+	if v.threads < 1 || v.threads > verifierMaxThreads {
+		return v, fmt.Errorf("verifier threads must be between 1 & %d: %d", verifierMaxThreads, v.threads)
+	}
+	// Argon2 needs at least 8 KiB per thread
+	if v.memory < 8*uint32(v.threads) || v.memory > verifierMaxMemoryKiB {
+		return v, fmt.Errorf("verifier memory must be between %d & %d KiB: %d", 8*uint32(v.threads), verifierMaxMemoryKiB, v.memory)
+	}
+
+	var err error
+	if v.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return v, fmt.Errorf("invalid verifier salt: %w", err)
+	}
+	if len(v.salt) < verifierMinSaltSize {
+		return v, fmt.Errorf("verifier salt must be at least %d bytes", verifierMinSaltSize)
+	}
+	if v.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return v, fmt.Errorf("invalid verifier hash: %w", err)
+	}
+	if len(v.hash) < verifierMinHashSize || len(v.hash) > verifierMaxHashSize {
+		return v, fmt.Errorf("verifier hash must be between %d & %d bytes", verifierMinHashSize, verifierMaxHashSize)
+	}
+	return v, nil
+}
+
+// ValidateVerifier returns an error unless verifier is one CheckVerifier can
+// check, i.e. a well-formed Argon2id verifier with parameters in range.
+func ValidateVerifier(verifier string) error {
+	_, err := parseVerifier(verifier)
+	return err
+}
+
+// CheckVerifier reports whether password matches a verifier produced by
+// NewVerifier, using the parameters encoded in the verifier. Verifiers that
+// are malformed or whose parameters are out of range are an error.
+func CheckVerifier(encoded string, password []byte) (bool, error) {
+	v, err := parseVerifier(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey(password, v.salt, v.time, v.memory, v.threads, uint32(len(v.hash)))
+	return subtle.ConstantTimeCompare(v.hash, actual) == 1, nil
+}
@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestVerifier(t *testing.T) {
+	verifier, err := NewVerifier([]byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	if err := ValidateVerifier(verifier); err != nil {
+		t.Fatalf("expected a new verifier to be valid: %v", err)
+	}
+	for password, expected := range map[string]bool{"secret": true, "Secret": false, "": false} {
+		matches, err := CheckVerifier(verifier, []byte(password))
+		if err != nil {
+			t.Fatalf("failed to check verifier: %v", err)
+		}
+		if matches != expected {
+			t.Fatalf("expected %q to match: %t", password, expected)
+		}
+	}
+
+	other, err := NewVerifier([]byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	if other == verifier {
+		t.Fatalf("expected every verifier to have its own salt")
+	}
+}
+
+func TestInvalidVerifiers(t *testing.T) {
+	salt := base64.RawStdEncoding.EncodeToString(make([]byte, 16))
+	hash := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	encode := func(params string, salt string, hash string) string {
+		return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params, salt, hash)
+	}
+
+	for name, verifier := range map[string]string{
+		"empty":          "",
+		"argon2i":        strings.Replace(encode("m=8,t=1,p=1", salt, hash), "argon2id", "argon2i", 1),
+		"old version":    strings.Replace(encode("m=8,t=1,p=1", salt, hash), fmt.Sprintf("v=%d", argon2.Version), "v=16", 1),
+		"no time":        encode("m=8,t=0,p=1", salt, hash),
+		"no threads":     encode("m=8,t=1,p=0", salt, hash),
+		"threads > 255":  encode("m=8,t=1,p=300", salt, hash),
+		"too little mem": encode("m=8,t=1,p=2", salt, hash),
+		"too much mem":   encode("m=4294967295,t=1,p=1", salt, hash),
+		"too much time":  encode("m=8,t=1000000,p=1", salt, hash),
+		"bad params":     encode("t=1,m=8,p=1", salt, hash),
+		"no salt":        encode("m=8,t=1,p=1", "", hash),
+		"bad salt":       encode("m=8,t=1,p=1", "!!!", hash),
+		"no hash":        encode("m=8,t=1,p=1", salt, ""),
+		"long hash":      encode("m=8,t=1,p=1", salt, base64.RawStdEncoding.EncodeToString(make([]byte, 1024))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateVerifier(verifier); err == nil {
+				t.Fatalf("expected %q to be invalid", verifier)
+			}
+			if _, err := CheckVerifier(verifier, []byte("secret")); err == nil {
+				t.Fatalf("expected checking %q to fail", verifier)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	wrappedKeyringFileFormat  string = "passd-keyring-wrapped"
+	wrappedKeyringFileVersion int    = 1
+
+	kdfArgon2id   string = "argon2id"
+	kdfSaltSize   int    = 16
+	kdfTime       uint32 = 3
+	kdfMemoryKiB  uint32 = 64 * 1024
+	kdfThreads    uint8  = 4
+	wrappedKeyAAD string = "passd-keyring-wrapped:1"
+)
+
+var (
+	ErrEmptyPassphrase     error = errors.New("passphrase must not be empty")
+	ErrIncorrectPassphrase error = errors.New("incorrect passphrase")
+)
+
+// PassphraseFunc supplies the passphrase for a wrapped key file. It is only
+// invoked if the key file actually turns out to be wrapped.
+type PassphraseFunc func() ([]byte, error)
+
+type wrappedKeyringFile struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	Kdf        kdfParams `json:"kdf"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+type kdfParams struct {
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	MemoryKiB uint32 `json:"memory_kib"`
+	Threads   uint8  `json:"threads"`
+}
+
+func newKdfParams() (kdfParams, error) {
+	salt := make([]byte, kdfSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return kdfParams{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return kdfParams{
+		Algorithm: kdfArgon2id,
+		Salt:      salt,
+		Time:      kdfTime,
+		MemoryKiB: kdfMemoryKiB,
+		Threads:   kdfThreads,
+	}, nil
+}
+
+func (p kdfParams) deriveKey(passphrase []byte) (PassdKey, error) {
+	if p.Algorithm != kdfArgon2id {
+		return PassdKey{}, fmt.Errorf("unsupported key derivation function: %q", p.Algorithm)
+	}
+	if len(p.Salt) < kdfSaltSize || p.Time == 0 || p.MemoryKiB == 0 || p.Threads == 0 {
+		return PassdKey{}, fmt.Errorf("invalid key derivation parameters")
+	}
+	return NewPassdKey(argon2.IDKey(passphrase, p.Salt, p.Time, p.MemoryKiB, p.Threads, uint32(KeySize)))
+}
+
+func isWrappedKeyring(data []byte) bool {
+	var header struct {
+		Format string `json:"format"`
+	}
+	return json.Unmarshal(data, &header) == nil && header.Format == wrappedKeyringFileFormat
+}
+
+func unwrapKeyring(data []byte, passphrase PassphraseFunc) (*Keyring, error) {
+	var file wrappedKeyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse wrapped key file: %w", err)
+	}
+	if file.Version != wrappedKeyringFileVersion {
+		return nil, fmt.Errorf("unsupported wrapped key file version: %d", file.Version)
+	}
+	if passphrase == nil {
+		return nil, fmt.Errorf("key file is passphrase-protected but no passphrase was provided")
+	}
+
+	pass, err := passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(pass) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	wrappingKey, err := file.Kdf.deriveKey(pass)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := wrappingKey.Decrypt(file.Ciphertext, []byte(wrappedKeyAAD))
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	keyring, err := parseKeyring(inner)
+	if err != nil {
+		return nil, err
+	}
+	keyring.passphrase = pass
+	return keyring, nil
+}
+
+func (k *Keyring) wrap(inner []byte) ([]byte, error) {
+	params, err := newKdfParams()
+	if err != nil {
+		return nil, err
+	}
+	wrappingKey, err := params.deriveKey(k.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := wrappingKey.Encrypt(inner, []byte(wrappedKeyAAD))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap keyring: %w", err)
+	}
+	return json.MarshalIndent(wrappedKeyringFile{
+		Format:     wrappedKeyringFileFormat,
+		Version:    wrappedKeyringFileVersion,
+		Kdf:        params,
+		Ciphertext: ciphertext,
+	}, "", "  ")
+}
+
+// IsPassphraseProtected reports whether Save will wrap the keyring with a
+// passphrase-derived key.
+func (k *Keyring) IsPassphraseProtected() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.passphrase) > 0
+}
+
+// SetPassphrase makes subsequent calls to Save write the keyring encrypted
+// under a key derived from passphrase with Argon2id.
+func (k *Keyring) SetPassphrase(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return ErrEmptyPassphrase
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.passphrase = passphrase
+	return nil
+}
+
+// RemovePassphrase makes subsequent calls to Save write the keyring in
+// plaintext.
+func (k *Keyring) RemovePassphrase() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.passphrase = nil
+}
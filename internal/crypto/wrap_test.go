@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func saveKeyring(t *testing.T, keyring *Keyring) (string, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "passd.key")
+	if err := keyring.Save(path); err != nil {
+		t.Fatalf("failed to save keyring: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read key file: %v", err)
+	}
+	return path, data
+}
+
+// A wrapped key file holds no key material in the clear & only opens with
+// the passphrase it was saved with.
+func TestWrappedKeyring(t *testing.T) {
+	keyring, err := GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	if err := keyring.SetPassphrase(nil); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Fatalf("expected ErrEmptyPassphrase, got %v", err)
+	}
+	if err := keyring.SetPassphrase([]byte("hunter2")); err != nil {
+		t.Fatalf("failed to set passphrase: %v", err)
+	}
+	ciphertext, err := keyring.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	path, data := saveKeyring(t, keyring)
+
+	if !isWrappedKeyring(data) {
+		t.Fatalf("expected a wrapped key file: %s", data)
+	}
+	if bytes.Contains(data, []byte(keyringFileFormat+`"`)) || bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(keyring.keys[keyring.Primary()].key.key))) {
+		t.Fatalf("expected the keyring to be encrypted: %s", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the key file to be private, got %v, %v", info.Mode(), err)
+	}
+
+	loaded := loadKeyringFile(t, path, passphrase("hunter2"))
+	if !loaded.IsPassphraseProtected() || loaded.Primary() != keyring.Primary() {
+		t.Fatalf("expected the same passphrase-protected keyring")
+	}
+	if plaintext, err := loaded.Decrypt(ciphertext, nil); err != nil || string(plaintext) != "secret" {
+		t.Fatalf("expected the loaded keyring to decrypt, got %q, %v", plaintext, err)
+	}
+
+	for name, p := range map[string]PassphraseFunc{
+		"wrong passphrase": passphrase("hunter3"),
+		"empty passphrase": passphrase(""),
+		"no passphrase":    nil,
+		"failed prompt":    func() ([]byte, error) { return nil, errors.New("no terminal") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadKeyring(bytes.NewReader(data), p); err == nil {
+				t.Fatalf("expected loading to fail")
+			}
+		})
+	}
+	if _, err := LoadKeyring(bytes.NewReader(data), passphrase("hunter3")); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Fatalf("expected ErrIncorrectPassphrase, got %v", err)
+	}
+
+	// Removing the passphrase writes a plain keyring again, which never asks
+	// for one
+	loaded.RemovePassphrase()
+	_, data = saveKeyring(t, loaded)
+	if isWrappedKeyring(data) {
+		t.Fatalf("expected a plain key file")
+	}
+	prompted := false
+	if _, err := LoadKeyring(bytes.NewReader(data), func() ([]byte, error) { prompted = true; return nil, nil }); err != nil || prompted {
+		t.Fatalf("expected a plain key file to load without a passphrase, got %v (prompted: %t)", err, prompted)
+	}
+}
+
+// Tampering with any part of a wrapped key file is detected.
+func TestWrappedKeyringTampering(t *testing.T) {
+	keyring, err := GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	if err := keyring.SetPassphrase([]byte("hunter2")); err != nil {
+		t.Fatalf("failed to set passphrase: %v", err)
+	}
+	_, data := saveKeyring(t, keyring)
+
+	for name, tamper := range map[string]func(f *wrappedKeyringFile){
+		"ciphertext": func(f *wrappedKeyringFile) { f.Ciphertext[len(f.Ciphertext)-1] ^= 1 },
+		"salt":       func(f *wrappedKeyringFile) { f.Kdf.Salt[0] ^= 1 },
+		"short salt": func(f *wrappedKeyringFile) { f.Kdf.Salt = f.Kdf.Salt[:4] },
+		"no time":    func(f *wrappedKeyringFile) { f.Kdf.Time = 0 },
+		"algorithm":  func(f *wrappedKeyringFile) { f.Kdf.Algorithm = "scrypt" },
+		"version":    func(f *wrappedKeyringFile) { f.Version = 2 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var file wrappedKeyringFile
+			if err := json.Unmarshal(data, &file); err != nil {
+				t.Fatalf("failed to parse key file: %v", err)
+			}
+			tamper(&file)
+			tampered, err := json.Marshal(file)
+			if err != nil {
+				t.Fatalf("failed to encode key file: %v", err)
+			}
+			if _, err := LoadKeyring(bytes.NewReader(tampered), passphrase("hunter2")); err == nil {
+				t.Fatalf("expected a tampered key file to be rejected")
+			}
+		})
+	}
+}
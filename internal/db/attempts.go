@@ -0,0 +1,141 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ValidationResult is the outcome of a single validation attempt. Anything
+// other than ResultSuccess is reported to the public simply as a failure.
+type ValidationResult string
+
+const (
+	ResultSuccess   ValidationResult = "success"
+	ResultIncorrect ValidationResult = "incorrect"
+	ResultNotFound  ValidationResult = "not_found"
+	ResultThrottled ValidationResult = "throttled"
+	// ResultExpired & ResultNotYetActive mean the password was correct but
+	// the entry is outside its activation window.
+	ResultExpired      ValidationResult = "expired"
+	ResultNotYetActive ValidationResult = "not_yet_active"
+	// ResultExhausted means the password was correct but the entry has
+	// already been used its maximum number of times.
+	ResultExhausted ValidationResult = "exhausted"
+	// ResultOriginNotAllowed means the attempt came from a page whose origin
+	// the entry doesn't allow, so the password wasn't checked.
+	ResultOriginNotAllowed ValidationResult = "origin_not_allowed"
+)
+
+type ValidationAttempt struct {
+	AttemptId   int64
+	EntryId     string
+	AttemptedOn time.Time
+	Result      ValidationResult
+	ClientIP    string
+	UserAgent   string
+	RequestId   string
+	// Credential names the credential that matched, if any.
+	Credential string
+}
+
+// AttemptFilter narrows the results of ListValidationAttempts. Zero values
+// are ignored.
+type AttemptFilter struct {
+	EntryId  string
+	Result   ValidationResult
+	ClientIP string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+func (passddb *PassdDb) RecordValidationAttempt(attempt ValidationAttempt) error {
+	stmt, err := passddb.db.Prepare(`INSERT INTO validation_attempts
+		(entry_id, attempted_on, result, client_ip, user_agent, request_id, credential) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare query: %w", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(
+		attempt.EntryId,
+		formatTimestamp(attempt.AttemptedOn),
+		attempt.Result,
+		attempt.ClientIP,
+		attempt.UserAgent,
+		attempt.RequestId,
+		attempt.Credential); err != nil {
+		return fmt.Errorf("failed to record validation attempt: %w", err)
+	}
+	return nil
+}
+
+// ListValidationAttempts returns the attempts matching filter, newest first,
+// along with the total number of matching attempts ignoring Limit & Offset.
+func (passddb *PassdDb) ListValidationAttempts(filter AttemptFilter) ([]ValidationAttempt, int, error) {
+	conditions := []string{}
+	args := []any{}
+	if filter.EntryId != "" {
+		conditions = append(conditions, "entry_id = ?")
+		args = append(args, filter.EntryId)
+	}
+	if filter.Result != "" {
+		conditions = append(conditions, "result = ?")
+		args = append(args, filter.Result)
+	}
+	if filter.ClientIP != "" {
+		conditions = append(conditions, "client_ip = ?")
+		args = append(args, filter.ClientIP)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "attempted_on >= ?")
+		args = append(args, formatTimestamp(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "attempted_on < ?")
+		args = append(args, formatTimestamp(filter.Until))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := passddb.db.QueryRow("SELECT COUNT(*) FROM validation_attempts "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count validation attempts: %w", err)
+	}
+
+	limit := int64(filter.Limit)
+	if limit <= 0 {
+		// Postgres doesn't accept SQLite's -1 for no limit
+		limit = math.MaxInt64
+	}
+	rows, err := passddb.db.Query(
+		`SELECT attempt_id, entry_id, attempted_on, result, client_ip, user_agent, request_id, credential
+		FROM validation_attempts `+where+` ORDER BY attempt_id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []ValidationAttempt{}
+	for rows.Next() {
+		var a ValidationAttempt
+		var attemptedOn string
+		if err := rows.Scan(&a.AttemptId, &a.EntryId, &attemptedOn, &a.Result, &a.ClientIP, &a.UserAgent, &a.RequestId, &a.Credential); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if a.AttemptedOn, err = parseTimestamp(attemptedOn); err != nil {
+			return nil, 0, err
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return attempts, total, nil
+}
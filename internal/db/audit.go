@@ -0,0 +1,155 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// AdminAction is an operation performed through the admin API.
+type AdminAction string
+
+const (
+	ActionCreate            AdminAction = "create"
+	ActionUpdate            AdminAction = "update"
+	ActionUpdateMetadata    AdminAction = "update_metadata"
+	ActionDelete            AdminAction = "delete"
+	ActionReadPlaintext     AdminAction = "read_plaintext"
+	ActionAddCredential     AdminAction = "add_credential"
+	ActionUpdateCredential  AdminAction = "update_credential"
+	ActionRemoveCredential  AdminAction = "remove_credential"
+	ActionSetPayload        AdminAction = "set_payload"
+	ActionReadPayload       AdminAction = "read_payload"
+	ActionRemovePayload     AdminAction = "remove_payload"
+	ActionSetRedirect       AdminAction = "set_redirect"
+	ActionReadRedirect      AdminAction = "read_redirect"
+	ActionRemoveRedirect    AdminAction = "remove_redirect"
+	ActionSetAllowedOrigins AdminAction = "set_allowed_origins"
+	ActionSetRoute          AdminAction = "set_route"
+	ActionRemoveRoute       AdminAction = "remove_route"
+	// ActionBackup has no entry, since it covers the whole DB.
+	ActionBackup AdminAction = "backup"
+	ActionExport AdminAction = "export"
+	ActionImport AdminAction = "import"
+	// ActionPurgeExpired is performed by passd itself when deleting expired
+	// entries.
+	ActionPurgeExpired AdminAction = "purge_expired"
+)
+
+// AdminAuditRecord identifies who performed an admin action on which entry.
+// Subject & Username come from the admin's validated access token.
+type AdminAuditRecord struct {
+	AuditId    int64
+	OccurredOn time.Time
+	Action     AdminAction
+	EntryId    string
+	Subject    string
+	Username   string
+	ClientIP   string
+	RequestId  string
+	// Credential names the credential acted on, for actions that concern one.
+	Credential string
+}
+
+// AuditFilter narrows the results of ListAdminAuditRecords. Zero values are
+// ignored.
+type AuditFilter struct {
+	EntryId string
+	Action  AdminAction
+	Subject string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Offset  int
+}
+
+func (passddb *PassdDb) RecordAdminAction(record AdminAuditRecord) error {
+	stmt, err := passddb.db.Prepare(`INSERT INTO admin_audit_log
+		(occurred_on, action, entry_id, subject, username, client_ip, request_id, credential) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare query: %w", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(
+		formatTimestamp(record.OccurredOn),
+		record.Action,
+		record.EntryId,
+		record.Subject,
+		record.Username,
+		record.ClientIP,
+		record.RequestId,
+		record.Credential); err != nil {
+		return fmt.Errorf("failed to record admin action: %w", err)
+	}
+	return nil
+}
+
+// ListAdminAuditRecords returns the audit records matching filter, oldest
+// first, along with the total number of matching records ignoring Limit &
+// Offset.
+func (passddb *PassdDb) ListAdminAuditRecords(filter AuditFilter) ([]AdminAuditRecord, int, error) {
+	conditions := []string{}
+	args := []any{}
+	if filter.EntryId != "" {
+		conditions = append(conditions, "entry_id = ?")
+		args = append(args, filter.EntryId)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Subject != "" {
+		conditions = append(conditions, "subject = ?")
+		args = append(args, filter.Subject)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "occurred_on >= ?")
+		args = append(args, formatTimestamp(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "occurred_on < ?")
+		args = append(args, formatTimestamp(filter.Until))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := passddb.db.QueryRow("SELECT COUNT(*) FROM admin_audit_log "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit records: %w", err)
+	}
+
+	limit := int64(filter.Limit)
+	if limit <= 0 {
+		// Postgres doesn't accept SQLite's -1 for no limit
+		limit = math.MaxInt64
+	}
+	rows, err := passddb.db.Query(
+		`SELECT audit_id, occurred_on, action, entry_id, subject, username, client_ip, request_id, credential
+		FROM admin_audit_log `+where+` ORDER BY audit_id LIMIT ? OFFSET ?`,
+		append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	records := []AdminAuditRecord{}
+	for rows.Next() {
+		var r AdminAuditRecord
+		var occurredOn string
+		if err := rows.Scan(&r.AuditId, &occurredOn, &r.Action, &r.EntryId, &r.Subject, &r.Username, &r.ClientIP, &r.RequestId, &r.Credential); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if r.OccurredOn, err = parseTimestamp(occurredOn); err != nil {
+			return nil, 0, err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return records, total, nil
+}
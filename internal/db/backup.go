@@ -0,0 +1,180 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+)
+
+var (
+	ErrBackupUnsupported error = errors.New("backups are only supported for SQLite databases")
+	// ErrPreviousDbMoved is returned by Restore if it fails after moving the
+	// existing database aside & can't move it back.
+	ErrPreviousDbMoved error = errors.New("the existing DB was moved aside & could not be moved back")
+)
+
+// sqliteSidecarSuffixes name the files SQLite keeps next to a database, which
+// belong to that database & must move along with it.
+var sqliteSidecarSuffixes = []string{"-journal", "-wal", "-shm"}
+
+// vacuumInto writes a consistent, compacted copy of the database to dest,
+// which must not exist yet. The database stays usable throughout.
+func vacuumInto(q querier, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("backup destination already exists: %s", dest)
+	}
+	if _, err := q.Exec("VACUUM INTO ?", dest); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// Backup writes a consistent snapshot of the database to dest, which must
+// not exist yet. ErrBackupUnsupported is returned for Postgres, which has its
+// own tools for this.
+func (passddb *PassdDb) Backup(dest string) error {
+	if passddb.db.dialect != sqliteDialect {
+		return ErrBackupUnsupported
+	}
+	return vacuumInto(passddb.db, dest)
+}
+
+// BackupFile writes a consistent snapshot of the SQLite database at dbPath to
+// dest, without migrating or otherwise changing it, so that it can be used
+// while passd is running.
+func BackupFile(dbPath string, dest string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("failed to open DB: %w", err)
+	}
+	db, err := openConn(sqliteDialect, dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return vacuumInto(db, dest)
+}
+
+// verifySealed decrypts every sealed value, returning how many there are, so
+// that a database can be checked against a key before it is relied upon.
+func (passddb *PassdDb) verifySealed() (int, error) {
+	verified := 0
+	for _, sc := range sealedColumns {
+		columns := append(append([]string{}, sc.rowKey...), sc.extra...)
+		rows, err := passddb.db.Query(fmt.Sprintf("SELECT %s, %s FROM %s", strings.Join(columns, ", "), sc.column, sc.table))
+		if err != nil {
+			return 0, fmt.Errorf("failed to execute query: %w", err)
+		}
+		for rows.Next() {
+			values := make([]string, len(columns))
+			var ciphertext []byte
+			dest := []any{}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			if err := rows.Scan(append(dest, &ciphertext)...); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("failed to scan row: %w", err)
+			}
+			if _, err := passddb.key.Decrypt(ciphertext, sc.ad(values)); err != nil {
+				rows.Close()
+				row := strings.Join(values[:len(sc.rowKey)], "/")
+				return 0, fmt.Errorf("failed to decrypt %s.%s for %s: %w", sc.table, sc.column, row, err)
+			}
+			verified++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("failed to read rows: %w", err)
+		}
+	}
+	return verified, nil
+}
+
+// Restore replaces the SQLite database at dbPath with the backup at src. The
+// backup is copied next to dbPath & migrated, & every sealed value in the copy
+// must decrypt with key before it is swapped in; src itself isn't changed.
+// The database that was replaced, if any, is kept at dbPath + ".pre-restore";
+// if the swap fails it is moved back, & ErrPreviousDbMoved is returned if
+// that fails too.
+// Returns the number of sealed values verified. passd must not be using
+// dbPath while it is restored.
+func Restore(src string, dbPath string, key *crypto.Keyring) (int, error) {
+	staged := dbPath + ".restore"
+	if err := removeDatabase(staged); err != nil {
+		return 0, err
+	}
+	if err := BackupFile(src, staged); err != nil {
+		return 0, err
+	}
+
+	verified, err := verifyBackup(staged, key)
+	if err != nil {
+		removeDatabase(staged)
+		return 0, err
+	}
+
+	// Files moved aside, so that they can be moved back if the swap fails
+	previous := dbPath + ".pre-restore"
+	moved := []string{}
+	rollback := func(err error) error {
+		removeDatabase(staged)
+		var rollbackErrs []error
+		for _, suffix := range slices.Backward(moved) {
+			if rollbackErr := os.Rename(previous+suffix, dbPath+suffix); rollbackErr != nil {
+				rollbackErrs = append(rollbackErrs, rollbackErr)
+			}
+		}
+		if len(rollbackErrs) > 0 {
+			return fmt.Errorf("%w (left at %s): %w", ErrPreviousDbMoved, previous, errors.Join(append([]error{err}, rollbackErrs...)...))
+		}
+		return err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := removeDatabase(previous); err != nil {
+			removeDatabase(staged)
+			return 0, err
+		}
+		// Any journal left by the database being replaced has to go with it,
+		// or SQLite would apply it to the restored one.
+		for _, suffix := range append([]string{""}, sqliteSidecarSuffixes...) {
+			err := os.Rename(dbPath+suffix, previous+suffix)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return 0, rollback(fmt.Errorf("failed to move aside existing DB: %w", err))
+			}
+			moved = append(moved, suffix)
+		}
+	}
+	if err := os.Rename(staged, dbPath); err != nil {
+		return 0, rollback(fmt.Errorf("failed to move restored DB into place: %w", err))
+	}
+	return verified, nil
+}
+
+// verifyBackup migrates the database at dbPath & checks that everything
+// sealed in it decrypts with key.
+func verifyBackup(dbPath string, key *crypto.Keyring) (int, error) {
+	passddb, err := Open(dbPath, key)
+	if err != nil {
+		return 0, err
+	}
+	defer passddb.Close()
+	return passddb.verifySealed()
+}
+
+// removeDatabase removes the SQLite database at dbPath, if it exists, along
+// with its sidecar files.
+func removeDatabase(dbPath string) error {
+	for _, suffix := range append([]string{""}, sqliteSidecarSuffixes...) {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", dbPath+suffix, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+)
+
+// DefaultCredentialName names the credential managed through the original
+// single-password API (UpsertPassword, GetPassword).
+const DefaultCredentialName string = "default"
+
+var (
+	ErrEntryNotFound  error = errors.New("no entry with id exists")
+	ErrLastCredential error = errors.New("cannot remove an entry's only credential; delete the entry instead")
+)
+
+// Credential describes one of the passwords an entry accepts, without the
+// password itself.
+type Credential struct {
+	Name        string
+	StorageMode StorageMode
+	CreatedOn   time.Time
+	// NotBefore & ExpiresAt bound when the credential is accepted, in
+	// addition to the entry's own window. Zero times leave the window open
+	// at that end.
+	NotBefore time.Time
+	ExpiresAt time.Time
+	// revision is replaced with every password, so CredentialFingerprint
+	// changes even when CreatedOn doesn't.
+	revision string
+}
+
+// CredentialUpdate holds everything needed to set a credential.
+type CredentialUpdate struct {
+	Password    string
+	StorageMode StorageMode
+	NotBefore   time.Time
+	ExpiresAt   time.Time
+}
+
+type storedCredential struct {
+	Credential
+	ciphertext []byte
+}
+
+// credentialAD returns the associated data for a credential's password_enc.
+// The default credential keeps the binding it had when it was stored in
+// passwords.password_enc, so that entries created before credentials existed
+// remain readable without being re-encrypted.
+func credentialAD(id string, name string, mode StorageMode) []byte {
+	if name == DefaultCredentialName {
+		return passwordAD(id, mode)
+	}
+	column := "credentials.password_enc"
+	if mode == StorageModeHash {
+		column += ".verifier"
+	}
+	// Length-prefixed so that no other id/name pair produces the same value
+	return associatedData(column, fmt.Sprintf("%d:%s:%s", len(id), id, name))
+}
+
+func scanCredential(row rowScanner) (string, storedCredential, error) {
+	var entryId string
+	var c storedCredential
+	var createdOn, notBefore, expiresAt sql.NullString
+	if err := row.Scan(&entryId, &c.Name, &c.ciphertext, &c.StorageMode, &createdOn, &notBefore, &expiresAt, &c.revision); err != nil {
+		return "", c, err
+	}
+	var err error
+	if c.CreatedOn, err = parseNullTimestamp(createdOn); err != nil {
+		return "", c, err
+	}
+	if c.NotBefore, err = parseNullTimestamp(notBefore); err != nil {
+		return "", c, err
+	}
+	if c.ExpiresAt, err = parseNullTimestamp(expiresAt); err != nil {
+		return "", c, err
+	}
+	return entryId, c, nil
+}
+
+const selectCredentialsSql string = `SELECT entry_id, name, password_enc, storage_mode, created_on, not_before, expires_at, revision
+	FROM credentials`
+
+// loadCredentials returns the credentials of the entries matching where,
+// keyed by entry id & ordered by name.
+func loadCredentials(q querier, where string, args ...any) (map[string][]storedCredential, error) {
+	rows, err := q.Query(selectCredentialsSql+" "+where+" ORDER BY entry_id, name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	credentials := map[string][]storedCredential{}
+	for rows.Next() {
+		entryId, c, err := scanCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		credentials[entryId] = append(credentials[entryId], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return credentials, nil
+}
+
+// newRevision returns a random credential revision.
+func newRevision() string {
+	return rand.Text()
+}
+
+func credentialInfo(stored []storedCredential) []Credential {
+	credentials := []Credential{}
+	for _, c := range stored {
+		credentials = append(credentials, c.Credential)
+	}
+	return credentials
+}
+
+func entryExists(q querier, id string) (bool, error) {
+	var exists bool
+	if err := q.QueryRow("SELECT COUNT(*) > 0 FROM passwords WHERE id = ?", id).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check for existing entry: %w", err)
+	}
+	return exists, nil
+}
+
+// ListCredentials returns the credentials of the entry with the given id,
+// ordered by name, or nil if no such entry exists.
+func (passddb *PassdDb) ListCredentials(id string) ([]Credential, error) {
+	exists, err := entryExists(passddb.db, id)
+	if err != nil || !exists {
+		return nil, err
+	}
+	stored, err := loadCredentials(passddb.db, "WHERE entry_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	return credentialInfo(stored[id]), nil
+}
+
+// sealCredential produces the value stored in password_enc for the given
+// credential & mode.
+func sealCredential(key *crypto.Keyring, id string, name string, password string, mode StorageMode) ([]byte, error) {
+	secret := []byte(password)
+	if mode == StorageModeHash {
+		verifier, err := crypto.NewVerifier(secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive password verifier: %w", err)
+		}
+		secret = []byte(verifier)
+	}
+
+	ciphertext, err := key.Encrypt(secret, credentialAD(id, name, mode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt password: %w", err)
+	}
+	return ciphertext, nil
+}
+
+// dummyCredential is checked in place of real work, so that validating a
+// password takes as long whether or not the entry exists & whatever its
+// credentials' storage modes.
+type dummyCredential struct {
+	// key seals passwordEnc. It is separate from the store's keyring, so
+	// that rotating & pruning that never leaves the dummy undecryptable.
+	key *crypto.Keyring
+	// passwordEnc is a sealed hash mode credential with a random password,
+	// checked when validating an unknown id.
+	passwordEnc []byte
+	// verifier is checked alongside each encrypted mode credential, so that
+	// it costs as much to check as a hash mode one.
+	verifier string
+}
+
+func newDummyCredential() (dummyCredential, error) {
+	key, err := crypto.GenerateKeyring()
+	if err != nil {
+		return dummyCredential{}, fmt.Errorf("failed to generate dummy key: %w", err)
+	}
+	dummyPassword := make([]byte, 16)
+	if _, err := rand.Read(dummyPassword); err != nil {
+		return dummyCredential{}, fmt.Errorf("failed to generate dummy password: %w", err)
+	}
+	verifier, err := crypto.NewVerifier(dummyPassword)
+	if err != nil {
+		return dummyCredential{}, fmt.Errorf("failed to derive dummy password verifier: %w", err)
+	}
+	passwordEnc, err := key.Encrypt([]byte(verifier), passwordAD("", StorageModeHash))
+	if err != nil {
+		return dummyCredential{}, fmt.Errorf("failed to encrypt dummy password: %w", err)
+	}
+	return dummyCredential{key: key, passwordEnc: passwordEnc, verifier: verifier}, nil
+}
+
+// checkCredential reports whether password matches the credential. Both
+// storage modes do the same work: one decryption & one Argon2id derivation.
+func checkCredential(key *crypto.Keyring, dummy dummyCredential, id string, c storedCredential, password string) (bool, error) {
+	secret, err := key.Decrypt(c.ciphertext, credentialAD(id, c.Name, c.StorageMode))
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt password: %w", err)
+	}
+	verifier := dummy.verifier
+	if c.StorageMode == StorageModeHash {
+		verifier = string(secret)
+	}
+	matches, err := crypto.CheckVerifier(verifier, []byte(password))
+	if err != nil {
+		return false, fmt.Errorf("failed to check password verifier: %w", err)
+	}
+	if c.StorageMode == StorageModeHash {
+		return matches, nil
+	}
+	return crypto.ComparePasswords(secret, []byte(password)), nil
+}
+
+// matchCredentials checks password against each of an entry's credentials.
+// It returns the name of the matching active credential with an empty
+// result, or else the reason the password was rejected along with the name
+// of the inactive credential it matched, if any. If there are no credentials
+// (i.e. no such entry), it returns ResultNotFound after doing the same work
+// as checking an entry with a single credential.
+func matchCredentials(key *crypto.Keyring, dummy dummyCredential, id string, credentials []storedCredential, password string, now time.Time) (ValidationResult, string, error) {
+	found := len(credentials) > 0
+	if !found {
+		id, key = "", dummy.key
+		credentials = []storedCredential{{
+			Credential: Credential{Name: DefaultCredentialName, StorageMode: StorageModeHash},
+			ciphertext: dummy.passwordEnc,
+		}}
+	}
+
+	// Every credential is checked, even after a match, so that response
+	// times don't reveal which one matched.
+	var matched string
+	var inactiveResult ValidationResult
+	var inactiveName string
+	for _, c := range credentials {
+		matches, err := checkCredential(key, dummy, id, c, password)
+		if err != nil {
+			return "", "", err
+		}
+		if !matches {
+			continue
+		}
+		if result, active := checkWindow(c.NotBefore, c.ExpiresAt, now); !active {
+			if inactiveResult == "" {
+				inactiveResult, inactiveName = result, c.Name
+			}
+		} else if matched == "" {
+			matched = c.Name
+		}
+	}
+
+	switch {
+	case !found:
+		return ResultNotFound, "", nil
+	case matched == "" && inactiveResult != "":
+		return inactiveResult, inactiveName, nil
+	case matched == "":
+		return ResultIncorrect, "", nil
+	}
+	return "", matched, nil
+}
+
+// SetCredential creates or replaces the named credential of the entry with
+// the given id, returning true if the credential was newly created.
+// ErrEntryNotFound is returned if no such entry exists.
+func (passddb *PassdDb) SetCredential(id string, name string, update CredentialUpdate) (bool, error) {
+	if name == "" {
+		return false, fmt.Errorf("credential name must not be empty")
+	}
+	if !update.NotBefore.IsZero() && !update.ExpiresAt.IsZero() && !update.ExpiresAt.After(update.NotBefore) {
+		return false, ErrInvalidWindow
+	}
+	ciphertext, err := sealCredential(passddb.key, id, name, update.Password, update.StorageMode)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	exists, err := entryExists(tx, id)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, ErrEntryNotFound
+	}
+	var credentialExists bool
+	if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM credentials WHERE entry_id = ? AND name = ?", id, name).Scan(&credentialExists); err != nil {
+		return false, fmt.Errorf("failed to check for existing credential: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO credentials (entry_id, name, password_enc, storage_mode, not_before, expires_at, revision) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(entry_id, name) DO UPDATE SET
+			password_enc = excluded.password_enc,
+			storage_mode = excluded.storage_mode,
+			not_before = excluded.not_before,
+			expires_at = excluded.expires_at,
+			revision = excluded.revision,
+			created_on = CURRENT_TIMESTAMP`,
+		id, name, ciphertext, update.StorageMode, nullTimestamp(update.NotBefore), nullTimestamp(update.ExpiresAt), newRevision()); err != nil {
+		return false, fmt.Errorf("failed to set credential: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE passwords SET updated_on = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to update entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return !credentialExists, nil
+}
+
+// RemoveCredential deletes the named credential of the entry with the given
+// id, returning false if there was no such credential. An entry's last
+// credential can't be removed.
+func (passddb *PassdDb) RemoveCredential(id string, name string) (bool, error) {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	var found bool
+	if err := tx.QueryRow("SELECT COUNT(*), COUNT(CASE WHEN name = ? THEN 1 END) > 0 FROM credentials WHERE entry_id = ?", name, id).Scan(&count, &found); err != nil {
+		return false, fmt.Errorf("failed to load credentials: %w", err)
+	}
+	if !found {
+		return false, nil
+	}
+	if count == 1 {
+		return false, ErrLastCredential
+	}
+
+	if _, err := tx.Exec("DELETE FROM credentials WHERE entry_id = ? AND name = ?", id, name); err != nil {
+		return false, fmt.Errorf("failed to delete credential: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE passwords SET updated_on = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to update entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// GetCredentialPassword returns the plaintext password of the named
+// credential, or nil if no such credential exists. ErrNotRetrievable is
+// returned for credentials stored in StorageModeHash.
+func (passddb *PassdDb) GetCredentialPassword(id string, name string) ([]byte, error) {
+	stored, err := loadCredentials(passddb.db, "WHERE entry_id = ? AND name = ?", id, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored[id]) == 0 {
+		return nil, nil
+	}
+	c := stored[id][0]
+	if c.StorageMode == StorageModeHash {
+		return nil, ErrNotRetrievable
+	}
+
+	plaintext, err := passddb.key.Decrypt(c.ciphertext, credentialAD(id, name, c.StorageMode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt password: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+)
+
+// Validating an unknown id goes through the dummy credential, which must
+// keep working after the store's keys have all been rotated out.
+func TestDummyCredentialSurvivesKeyRotation(t *testing.T) {
+	for name, newStore := range map[string]newStoreFunc{"sqlite": newSqliteStore, "memory": newMemoryStore} {
+		t.Run(name, func(t *testing.T) {
+			key := newKeyring(t)
+			store := newStore(t, key)
+			must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+			expectResult(t, store, "missing", "secret", ResultNotFound, "")
+
+			_, err := key.Rotate()
+			must(t, err)
+			_, err = store.Reencrypt(0)
+			must(t, err)
+			key.Prune()
+			expectResult(t, store, "missing", "secret", ResultNotFound, "")
+			expectResult(t, store, "party", "secret", ResultSuccess, DefaultCredentialName)
+		})
+	}
+}
+
+// Checking an encrypted mode credential, a hash mode one & an unknown id
+// each cost one Argon2id derivation, so none of them can be told apart by
+// how long it takes. Anything cheaper would be orders of magnitude faster,
+// so a generous margin keeps this from being flaky.
+func TestValidationTakesAsLongForEveryCredential(t *testing.T) {
+	store := newMemoryStore(t, newKeyring(t))
+	must(t, store.CreatePassword("encrypted", "secret", StorageModeEncrypted))
+	must(t, store.CreatePassword("hashed", "secret", StorageModeHash))
+
+	fastest := func(id string, password string) time.Duration {
+		best := time.Duration(0)
+		for range 3 {
+			start := time.Now()
+			_, _, err := store.CheckPassword(id, password)
+			must(t, err)
+			if elapsed := time.Since(start); best == 0 || elapsed < best {
+				best = elapsed
+			}
+		}
+		return best
+	}
+	hashed := fastest("hashed", "secret")
+	for name, elapsed := range map[string]time.Duration{
+		"encrypted, correct": fastest("encrypted", "secret"),
+		"encrypted, wrong":   fastest("encrypted", "wrong"),
+		"hashed, wrong":      fastest("hashed", "wrong"),
+		"unknown id":         fastest("missing", "secret"),
+	} {
+		if elapsed < hashed/4 {
+			t.Errorf("%s took %v, much less than checking a hash mode credential (%v)", name, elapsed, hashed)
+		}
+	}
+}
+
+// The dummy credential is a real hash mode credential that no password
+// matches, sealed under its own key.
+func TestDummyCredential(t *testing.T) {
+	dummy, err := newDummyCredential()
+	must(t, err)
+	if err := crypto.ValidateVerifier(dummy.verifier); err != nil {
+		t.Fatalf("expected the dummy verifier to be valid: %v", err)
+	}
+	secret, err := dummy.key.Decrypt(dummy.passwordEnc, passwordAD("", StorageModeHash))
+	must(t, err)
+	if err := crypto.ValidateVerifier(string(secret)); err != nil {
+		t.Fatalf("expected the dummy password to be a valid verifier: %v", err)
+	}
+
+	other, err := newDummyCredential()
+	must(t, err)
+	if other.verifier == dummy.verifier {
+		t.Fatalf("expected each dummy credential to use a random password")
+	}
+	for _, password := range []string{"", "secret"} {
+		result, _, err := matchCredentials(newKeyring(t), dummy, "missing", nil, password, time.Now())
+		must(t, err)
+		if result != ResultNotFound {
+			t.Fatalf("expected ResultNotFound, got %s", result)
+		}
+	}
+}
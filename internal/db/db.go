@@ -2,162 +2,371 @@ package db
 
 import (
 	"database/sql"
-	_ "embed"
 	"errors"
 	"fmt"
-
-	_ "github.com/mattn/go-sqlite3"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/mrshanahan/simple-password-service/internal/crypto"
 )
 
+// PassdDb is the Store backed by SQL, either a SQLite file or a Postgres
+// database.
 type PassdDb struct {
-	db  *sql.DB
-	key crypto.PassdKey
+	db  *sqlConn
+	key *crypto.Keyring
+
+	// dummy is checked in place of real credentials, so that validating a
+	// password takes as long whether or not the entry exists.
+	dummy dummyCredential
+}
+
+// StorageMode determines what is kept for an entry's password.
+type StorageMode string
+
+const (
+	// StorageModeEncrypted keeps the password reversibly encrypted, so it
+	// can be retrieved by admins.
+	StorageModeEncrypted StorageMode = "encrypted"
+	// StorageModeHash keeps only a one-way Argon2id verifier, so the
+	// password can be checked but never retrieved.
+	StorageModeHash StorageMode = "hash"
+)
+
+func ParseStorageMode(s string) (StorageMode, error) {
+	switch mode := StorageMode(s); mode {
+	case StorageModeEncrypted, StorageModeHash:
+		return mode, nil
+	case "":
+		return StorageModeEncrypted, nil
+	default:
+		return "", fmt.Errorf("invalid storage mode: %s", s)
+	}
 }
 
 var (
-	//go:embed files/create_passwords_table.sql
-	CreatePasswordTableSql string
-	KeySize                int   = 32
-	ErrConflict            error = fmt.Errorf("password with id already exists")
+	KeySize           int   = 32
+	ErrConflict       error = fmt.Errorf("password with id already exists")
+	ErrNotRetrievable error = fmt.Errorf("password is stored as a one-way hash & cannot be retrieved")
+)
+
+const (
+	// recordFormatVersion is bound into every ciphertext's associated data;
+	// bump it if the meaning of a stored value ever changes.
+	recordFormatVersion int = 1
+
+	// bindCiphertextsUpgrade names the one-time upgrade of values written
+	// before ciphertexts were bound to their rows.
+	bindCiphertextsUpgrade string = "bind_ciphertexts"
 )
 
-func Open(dbPath string, key crypto.PassdKey) (*PassdDb, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// associatedData binds a ciphertext to the column & entry it is stored in, so
+// that a ciphertext copied into another row (or column) fails to decrypt.
+func associatedData(column string, id string) []byte {
+	return fmt.Appendf(nil, "passd:%d:%s:%s", recordFormatVersion, column, id)
+}
+
+// timestampLayout matches SQLite's CURRENT_TIMESTAMP, so that timestamps we
+// write compare correctly against the ones it generates. All times are UTC.
+const timestampLayout string = "2006-01-02 15:04:05"
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(timestampLayout, s, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// passwordAD returns the associated data for what was an entry's
+// password_enc before entries had several credentials, which holds either the
+// encrypted password or its encrypted verifier depending on the storage mode.
+func passwordAD(id string, mode StorageMode) []byte {
+	if mode == StorageModeHash {
+		return associatedData("passwords.password_enc.verifier", id)
+	}
+	return associatedData("passwords.password_enc", id)
+}
+
+// Open opens the SQLite database at dbPath, creating it if necessary &
+// applying any pending migrations.
+func Open(dbPath string, key *crypto.Keyring) (*PassdDb, error) {
+	return open(sqliteDialect, dbPath, key)
+}
+
+func open(d *dialect, dsn string, key *crypto.Keyring) (*PassdDb, error) {
+	db, err := openConn(d, dsn)
 	if err != nil {
 		return nil, err
 	}
-	if db == nil {
-		return nil, fmt.Errorf("DB reference still nil despite no error")
+
+	from, to, err := migrate(db, 0)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate DB schema: %w", err)
+	}
+	if from != to {
+		slog.Info("migrated DB schema", "db", d.name, "from", from, "to", to)
 	}
 
-	tx, err := db.Begin()
+	dummy, err := newDummyCredential()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	_, err = db.Exec(CreatePasswordTableSql)
+	passddb := &PassdDb{db, key, dummy}
+	if err := passddb.bindLegacyCiphertexts(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return passddb, nil
+}
+
+// bindLegacyCiphertexts re-seals the values written before ciphertexts were
+// bound to their rows, which can only be done with the key. It runs until
+// every value has been upgraded, as recorded in pending_upgrades by the
+// migration that added it; values that can't be decrypted (e.g. because the
+// wrong key was given) are left as they are & the upgrade is tried again the
+// next time the DB is opened.
+func (passddb *PassdDb) bindLegacyCiphertexts() error {
+	var pending bool
+	err := passddb.db.QueryRow("SELECT COUNT(*) > 0 FROM pending_upgrades WHERE name = ?", bindCiphertextsUpgrade).Scan(&pending)
 	if err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to setup passwords table: %w", err)
+		return fmt.Errorf("failed to check for pending upgrades: %w", err)
+	}
+	if !pending {
+		return nil
 	}
 
-	err = tx.Commit()
+	tx, err := passddb.db.Begin()
 	if err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("failed to setup passwords table: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return &PassdDb{db, key}, nil
+	upgraded, skipped, err := passddb.reencryptColumns(tx, 0, passddb.key.IsLegacy, true)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade legacy ciphertexts: %w", err)
+	}
+	if skipped == 0 {
+		if _, err := tx.Exec("DELETE FROM pending_upgrades WHERE name = ?", bindCiphertextsUpgrade); err != nil {
+			return fmt.Errorf("failed to record upgrade: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if upgraded > 0 {
+		slog.Info("upgraded legacy ciphertexts", "count", upgraded)
+	}
+	if skipped > 0 {
+		slog.Error("some legacy ciphertexts could not be decrypted & were not upgraded; the upgrade will be retried the next time the DB is opened", "count", skipped)
+	}
+	return nil
 }
 
 func (passddb *PassdDb) Close() error {
 	return passddb.db.Close()
 }
 
-func (passddb *PassdDb) LoadHash(id string) ([]byte, error) {
-	stmt, err := passddb.db.Prepare("SELECT password_enc FROM passwords WHERE id = ?")
+// ValidatePassword checks password against each of the credentials of the
+// entry with the given id, returning the result & the name of the credential
+// it concerns, if any. If no such entry exists, it returns ResultNotFound
+// after doing the same work as checking an entry with a single credential in
+// StorageModeEncrypted, so that response times don't reveal which ids exist.
+func (passddb *PassdDb) ValidatePassword(id string, password string) (ValidationResult, string, error) {
+	return passddb.validatePassword(id, password, true)
+}
+
+// CheckPassword is ValidatePassword without using up one of the entry's uses
+// or counting as a validation, for admins checking a password.
+func (passddb *PassdDb) CheckPassword(id string, password string) (ValidationResult, string, error) {
+	return passddb.validatePassword(id, password, false)
+}
+
+func (passddb *PassdDb) validatePassword(id string, password string, consume bool) (ValidationResult, string, error) {
+	var notBeforeStr, expiresAtStr sql.NullString
+	var maxUses sql.NullInt64
+	var useCount int64
+	found := true
+	err := passddb.db.QueryRow("SELECT not_before, expires_at, max_uses, use_count FROM passwords WHERE id = ?", id).Scan(&notBeforeStr, &expiresAtStr, &maxUses, &useCount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare query: %w", err)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", "", fmt.Errorf("failed to load entry: %w", err)
+		}
+		found = false
 	}
-	defer stmt.Close()
 
-	var passwordEnc []byte
-	row := stmt.QueryRow(id)
-	if err := row.Scan(&passwordEnc); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+	var credentials []storedCredential
+	if found {
+		stored, err := loadCredentials(passddb.db, "WHERE entry_id = ?", id)
+		if err != nil {
+			return "", "", err
 		}
-		return nil, fmt.Errorf("failed to load entry: %w", err)
+		credentials = stored[id]
 	}
 
-	passwordDec, err := passddb.key.Decrypt(passwordEnc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt password: %w", err)
+	now := time.Now()
+	result, matched, err := matchCredentials(passddb.key, passddb.dummy, id, credentials, password, now)
+	if err != nil || result != "" {
+		return result, matched, err
 	}
 
-	passwordHash, err := crypto.Hash(passwordDec)
+	notBefore, err := parseNullTimestamp(notBeforeStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return "", "", err
+	}
+	expiresAt, err := parseNullTimestamp(expiresAtStr)
+	if err != nil {
+		return "", "", err
+	}
+	if result, ok := checkWindow(notBefore, expiresAt, now); !ok {
+		return result, matched, nil
+	}
+	if !consume {
+		if maxUses.Valid && useCount >= maxUses.Int64 {
+			return ResultExhausted, matched, nil
+		}
+		return ResultSuccess, matched, nil
 	}
-	return passwordHash, nil
+	consumed, err := passddb.consumeUse(id, now)
+	if err != nil {
+		return "", "", err
+	}
+	if !consumed {
+		return ResultExhausted, matched, nil
+	}
+	return ResultSuccess, matched, nil
 }
 
-func (passddb *PassdDb) CreatePassword(id string, password string) error {
-	stmt, err := passddb.db.Prepare("INSERT INTO passwords (id, password_enc) VALUES (?, ?)")
+// CreatePassword creates an entry whose default credential is password.
+func (passddb *PassdDb) CreatePassword(id string, password string, mode StorageMode) error {
+	ciphertext, err := sealCredential(passddb.key, id, DefaultCredentialName, password, mode)
 	if err != nil {
-		return fmt.Errorf("failed to prepare query: %w", err)
+		return err
 	}
-	defer stmt.Close()
 
-	ciphertext, err := passddb.key.Encrypt([]byte(password))
+	tx, err := passddb.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to encrypt password: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	if _, err := stmt.Exec(id, ciphertext); err != nil {
+	defer tx.Rollback()
+
+	exists, err := entryExists(tx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrConflict
+	}
+	if _, err := tx.Exec("INSERT INTO passwords (id) VALUES (?)", id); err != nil {
+		// Another replica may have created it since we checked
+		if passddb.db.dialect.isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to create entry: %w", err)
+	}
+	if _, err := tx.Exec("INSERT INTO credentials (entry_id, name, password_enc, storage_mode, revision) VALUES (?, ?, ?, ?, ?)",
+		id, DefaultCredentialName, ciphertext, mode, newRevision()); err != nil {
 		return fmt.Errorf("failed to update password: %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
 
-func (passddb *PassdDb) UpsertPassword(id string, password string) error {
-	stmt, err := passddb.db.Prepare("INSERT INTO passwords (id, password_enc) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET password_enc = excluded.password_enc")
+// UpsertPassword creates the entry with the given id or replaces its default
+// credential, returning true if the entry was newly created. Replacing the
+// password resets the entry's use count.
+func (passddb *PassdDb) UpsertPassword(id string, password string, mode StorageMode) (bool, error) {
+	ciphertext, err := sealCredential(passddb.key, id, DefaultCredentialName, password, mode)
 	if err != nil {
-		return fmt.Errorf("failed to prepare query: %w", err)
+		return false, err
 	}
-	defer stmt.Close()
 
-	ciphertext, err := passddb.key.Encrypt([]byte(password))
+	tx, err := passddb.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to encrypt password: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	if _, err := stmt.Exec(id, ciphertext); err != nil {
-		return fmt.Errorf("failed to update password: %w", err)
+	defer tx.Rollback()
+
+	exists, err := entryExists(tx, id)
+	if err != nil {
+		return false, err
 	}
 
-	return nil
-}
+	if _, err := tx.Exec("INSERT INTO passwords (id) VALUES (?) ON CONFLICT(id) DO UPDATE SET use_count = 0, updated_on = CURRENT_TIMESTAMP", id); err != nil {
+		return false, fmt.Errorf("failed to update entry: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO credentials (entry_id, name, password_enc, storage_mode, revision) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(entry_id, name) DO UPDATE SET
+			password_enc = excluded.password_enc,
+			storage_mode = excluded.storage_mode,
+			revision = excluded.revision,
+			created_on = CURRENT_TIMESTAMP`,
+		id, DefaultCredentialName, ciphertext, mode, newRevision()); err != nil {
+		return false, fmt.Errorf("failed to update password: %w", err)
+	}
 
-func (passddb *PassdDb) DeleteEntry(id string) (bool, error) {
-	stmt, err := passddb.db.Prepare("DELETE FROM passwords WHERE id = ?")
-	if err != nil {
-		return false, fmt.Errorf("failed to prepare query: %w", err)
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
 	}
-	defer stmt.Close()
+	return !exists, nil
+}
 
-	result, err := stmt.Exec(id)
+func deleteEntry(tx *sqlTx, id string) (bool, error) {
+	if _, err := tx.Exec("DELETE FROM credentials WHERE entry_id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to delete credentials: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM payloads WHERE entry_id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to delete payload: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM redirects WHERE entry_id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to delete redirect: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM routes WHERE entry_id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to delete routes: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM passwords WHERE id = ?", id)
 	if err != nil {
 		return false, fmt.Errorf("failed to delete entry: %w", err)
 	}
-
 	// We're ignoring the error here b/c we know our driver supports RowsAffected()
 	rowsAffected, _ := result.RowsAffected()
 	return rowsAffected > 0, nil
 }
 
-func (passddb *PassdDb) GetPassword(id string) ([]byte, error) {
-	stmt, err := passddb.db.Prepare("SELECT password_enc FROM passwords WHERE id = ?")
+func (passddb *PassdDb) DeleteEntry(id string) (bool, error) {
+	tx, err := passddb.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare query: %w", err)
-	}
-	defer stmt.Close()
-
-	var ciphertext []byte
-	if err := stmt.QueryRow(id).Scan(&ciphertext); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to execute query: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	plaintext, err := passddb.key.Decrypt(ciphertext)
+	deleted, err := deleteEntry(tx, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt password: %w", err)
+		return false, err
 	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deleted, nil
+}
 
-	return plaintext, nil
+// GetPassword returns the plaintext password of the default credential of the
+// entry with the given id, or nil if there is no such credential.
+// ErrNotRetrievable is returned for credentials stored in StorageModeHash.
+func (passddb *PassdDb) GetPassword(id string) ([]byte, error) {
+	return passddb.GetCredentialPassword(id, DefaultCredentialName)
 }
 
 func (passddb *PassdDb) ListIds() ([]string, error) {
@@ -183,3 +392,202 @@ func (passddb *PassdDb) ListIds() ([]string, error) {
 	}
 	return ids, nil
 }
+
+// sealedColumn describes a column holding values sealed with the keyring, so
+// that they can all be re-encrypted when the key is rotated. Every column in
+// rowKey & extra must be TEXT.
+type sealedColumn struct {
+	table  string
+	column string
+	// rowKey identifies a row; extra columns are only needed to build the
+	// associated data.
+	rowKey []string
+	extra  []string
+	// ad builds the associated data from the values of rowKey & then extra.
+	ad func(values []string) []byte
+}
+
+var sealedColumns = []sealedColumn{
+	{
+		table:  "credentials",
+		column: "password_enc",
+		rowKey: []string{"entry_id", "name"},
+		extra:  []string{"storage_mode"},
+		ad: func(v []string) []byte {
+			return credentialAD(v[0], v[1], StorageMode(v[2]))
+		},
+	},
+	{
+		table:  "payloads",
+		column: "payload_enc",
+		rowKey: []string{"entry_id"},
+		ad: func(v []string) []byte {
+			return payloadAD(v[0])
+		},
+	},
+	{
+		table:  "redirects",
+		column: "target_enc",
+		rowKey: []string{"entry_id"},
+		ad: func(v []string) []byte {
+			return redirectAD(v[0])
+		},
+	},
+	{
+		table:  "signing_keys",
+		column: "private_key_enc",
+		rowKey: []string{"key_id"},
+		ad: func(v []string) []byte {
+			return signingKeyAD(v[0])
+		},
+	},
+}
+
+// Reencrypt re-seals every value that is sealed with a key older than the
+// keyring's primary key, all within a single transaction. If limit is
+// positive, at most limit values are re-encrypted. Returns the number of
+// values that were updated.
+func (passddb *PassdDb) Reencrypt(limit int) (int, error) {
+	return passddb.reencrypt(limit, passddb.key.IsStale)
+}
+
+// CountSealedByKey returns how many stored values are sealed with each key,
+// so that a key is only pruned once nothing depends on it.
+func (passddb *PassdDb) CountSealedByKey() (map[uint32]int, error) {
+	counts := map[uint32]int{}
+	for _, sc := range sealedColumns {
+		rows, err := passddb.db.Query(fmt.Sprintf("SELECT %s FROM %s", sc.column, sc.table))
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute query: %w", err)
+		}
+		for rows.Next() {
+			var ciphertext []byte
+			if err := rows.Scan(&ciphertext); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan row: %w", err)
+			}
+			counts[passddb.key.KeyId(ciphertext)]++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read rows: %w", err)
+		}
+	}
+	return counts, nil
+}
+
+func (passddb *PassdDb) reencrypt(limit int, isStale func([]byte) bool) (int, error) {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	updated, _, err := passddb.reencryptColumns(tx, limit, isStale, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
+}
+
+// reencryptColumns re-seals the stale values of every sealed column, up to
+// limit if it is positive. Legacy ciphertexts are only opened if legacy is
+// set. Values that can't be decrypted are logged & skipped, so that they
+// don't hold up the rest. Returns the number of values updated & skipped.
+func (passddb *PassdDb) reencryptColumns(tx *sqlTx, limit int, isStale func([]byte) bool, legacy bool) (int, int, error) {
+	updated, skipped := 0, 0
+	for _, sc := range sealedColumns {
+		if limit > 0 && updated >= limit {
+			break
+		}
+		n, s, err := passddb.reencryptColumn(tx, sc, limit-updated, isStale, legacy)
+		if err != nil {
+			return 0, 0, err
+		}
+		updated += n
+		skipped += s
+	}
+	return updated, skipped, nil
+}
+
+func (passddb *PassdDb) reencryptColumn(tx *sqlTx, sc sealedColumn, limit int, isStale func([]byte) bool, legacy bool) (int, int, error) {
+	columns := append(append([]string{}, sc.rowKey...), sc.extra...)
+	rows, err := tx.Query(fmt.Sprintf("SELECT %s, %s FROM %s", strings.Join(columns, ", "), sc.column, sc.table))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	type staleValue struct {
+		values     []string
+		ciphertext []byte
+	}
+	stale := []staleValue{}
+	for rows.Next() {
+		v := staleValue{values: make([]string, len(columns))}
+		dest := []any{}
+		for i := range v.values {
+			dest = append(dest, &v.values[i])
+		}
+		if err := rows.Scan(append(dest, &v.ciphertext)...); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if isStale(v.ciphertext) {
+			stale = append(stale, v)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	conditions := []string{}
+	for _, c := range sc.rowKey {
+		conditions = append(conditions, c+" = ?")
+	}
+	// Only replace the value we read, in case it was changed concurrently
+	stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s AND %s = ?",
+		sc.table, sc.column, strings.Join(conditions, " AND "), sc.column))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to prepare query: %w", err)
+	}
+	defer stmt.Close()
+
+	updated, skipped := 0, 0
+	for _, v := range stale {
+		if limit > 0 && updated >= limit {
+			break
+		}
+		row := strings.Join(v.values[:len(sc.rowKey)], "/")
+		ad := sc.ad(v.values)
+		var plaintext []byte
+		if legacy && passddb.key.IsLegacy(v.ciphertext) {
+			plaintext, err = passddb.key.DecryptLegacy(v.ciphertext)
+		} else {
+			plaintext, err = passddb.key.Decrypt(v.ciphertext, ad)
+		}
+		if err != nil {
+			slog.Warn("skipping value that cannot be decrypted", "table", sc.table, "column", sc.column, "row", row, "err", err)
+			skipped++
+			continue
+		}
+		ciphertext, err := passddb.key.Encrypt(plaintext, ad)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to encrypt %s.%s for %s: %w", sc.table, sc.column, row, err)
+		}
+		args := []any{ciphertext}
+		for _, value := range v.values[:len(sc.rowKey)] {
+			args = append(args, value)
+		}
+		result, err := stmt.Exec(append(args, v.ciphertext)...)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to update %s.%s for %s: %w", sc.table, sc.column, row, err)
+		}
+		rowsAffected, _ := result.RowsAffected()
+		updated += int(rowsAffected)
+	}
+	return updated, skipped, nil
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect captures what differs between the databases PassdDb can run on.
+// Queries are written for SQLite & rewritten by rebind for the others; the
+// few that can't be are given here in full.
+type dialect struct {
+	name   string
+	driver string
+	// dsn adds the driver's connection settings to a database's path or URL.
+	dsn func(string) string
+	// migrations are kept separately for each dialect, since their DDL
+	// differs.
+	migrations []Migration
+	// rebind rewrites a query written for SQLite.
+	rebind func(query string) string
+	// hasTableSql checks whether the table named by its one parameter exists.
+	hasTableSql string
+	// legacyProbes are as for SQLite's legacyProbes; nil if the dialect was
+	// supported only after schema_version was introduced.
+	legacyProbes []string
+	// lockMigrationsSql, if set, is run at the start of the migration
+	// transaction so that concurrently starting replicas migrate one at a
+	// time.
+	lockMigrationsSql string
+	// originAllowedSql checks whether any entry allows the origin given as
+	// its one parameter.
+	originAllowedSql string
+	// signingKeysOrder orders signing keys newest first, breaking ties
+	// between keys created in the same second by insertion order.
+	signingKeysOrder string
+	// isUniqueViolation reports whether err is due to a duplicate key.
+	isUniqueViolation func(err error) bool
+}
+
+var sqliteDialect = &dialect{
+	name:        "sqlite",
+	driver:      sqliteDriver,
+	dsn:         sqliteDsn,
+	rebind:      func(query string) string { return query },
+	hasTableSql: "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?",
+	legacyProbes: []string{
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'passwords'",
+		"SELECT COUNT(*) > 0 FROM pragma_table_info('passwords') WHERE name = 'storage_mode'",
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'validation_throttles'",
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'validation_attempts'",
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'admin_audit_log'",
+	},
+	originAllowedSql: `SELECT EXISTS (
+		SELECT 1 FROM passwords
+		WHERE allowed_origins = '[]'
+			OR EXISTS (SELECT 1 FROM json_each(passwords.allowed_origins) WHERE value = ?))`,
+	signingKeysOrder:  "created_on DESC, rowid DESC",
+	isUniqueViolation: isSqliteUniqueViolation,
+}
+
+// postgresNowSql is CURRENT_TIMESTAMP in the same text format SQLite uses,
+// which is how timestamps are stored in Postgres too.
+const postgresNowSql string = "to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')"
+
+var postgresDialect = &dialect{
+	name:              "postgres",
+	driver:            "pgx",
+	dsn:               func(url string) string { return url },
+	rebind:            rebindPostgres,
+	hasTableSql:       "SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name::text = ?",
+	lockMigrationsSql: "SELECT pg_advisory_xact_lock(hashtext('passd.schema_version'))",
+	originAllowedSql: `SELECT EXISTS (
+		SELECT 1 FROM passwords
+		WHERE allowed_origins = '[]'
+			OR allowed_origins::jsonb @> jsonb_build_array(?::text))`,
+	signingKeysOrder:  "created_on DESC, seq DESC",
+	isUniqueViolation: isPostgresUniqueViolation,
+}
+
+// rebindPostgres numbers the ? placeholders in query ($1, $2, ...) & replaces
+// CURRENT_TIMESTAMP with postgresNowSql. Quoted strings & comments are left
+// alone.
+func rebindPostgres(query string) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+1])
+			i += end
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		case strings.HasPrefix(query[i:], "CURRENT_TIMESTAMP"):
+			b.WriteString(postgresNowSql)
+			i += len("CURRENT_TIMESTAMP") - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// sqlConn & sqlTx run queries written for SQLite against the dialect's
+// database.
+type sqlConn struct {
+	*sql.DB
+	dialect *dialect
+}
+
+type sqlTx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (c *sqlConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *sqlConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *sqlConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *sqlConn) Prepare(query string) (*sql.Stmt, error) {
+	return c.DB.Prepare(c.dialect.rebind(query))
+}
+
+func (c *sqlConn) Begin() (*sqlTx, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx, c.dialect}, nil
+}
+
+func (t *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) Prepare(query string) (*sql.Stmt, error) {
+	return t.Tx.Prepare(t.dialect.rebind(query))
+}
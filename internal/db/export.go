@@ -0,0 +1,485 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+)
+
+// ExportedEntry is an entry along with its secrets in plaintext, as moved
+// between instances by export & import. Validation attempts & the audit trail
+// stay behind, since they are the history of the instance rather than of the
+// entry.
+type ExportedEntry struct {
+	// Metadata's Credentials, Payload & HasRedirect are ignored on import in
+	// favour of the fields below.
+	Metadata    EntryMetadata
+	Credentials []ExportedCredential
+	// Payload is nil if the entry has no payload.
+	Payload *Payload
+	// Redirect is the target of the entry's /p/:id link, or empty if it has
+	// none.
+	Redirect string
+	Routes   []Route
+}
+
+// ExportedCredential is a credential along with its secret: the password for
+// StorageModeEncrypted, or its verifier for StorageModeHash, since the
+// password itself was never kept.
+type ExportedCredential struct {
+	Credential
+	Secret string
+}
+
+// ConflictPolicy decides what ImportEntries does with an entry whose id is
+// taken, or a route whose host & path prefix already lead to another entry.
+type ConflictPolicy string
+
+const (
+	// ConflictFail imports nothing if anything conflicts.
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictSkip keeps what is already there.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces existing entries in full, along with their
+	// credentials, payload, redirect & routes, & takes over existing routes.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(s); policy {
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+		return policy, nil
+	case "":
+		return ConflictFail, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy: %s", s)
+	}
+}
+
+// ImportResult lists what ImportEntries did with each entry, by id.
+type ImportResult struct {
+	Created     []string
+	Overwritten []string
+	Skipped     []string
+	// SkippedRoutes still lead to the entry they did before the import.
+	SkippedRoutes []Route
+}
+
+var (
+	ErrImportConflict error = errors.New("entries or routes already exist")
+	ErrInvalidImport  error = errors.New("invalid entry in import")
+)
+
+// normalizeImport checks an entry read from an archive the way the admin API
+// checks the same values, normalizing them along the way & filling in any
+// missing timestamps with now.
+func normalizeImport(e ExportedEntry, now time.Time) (ExportedEntry, error) {
+	m := &e.Metadata
+	if m.Id == "" {
+		return e, fmt.Errorf("%w: entry with no id", ErrInvalidImport)
+	}
+	invalid := func(err error) error {
+		return fmt.Errorf("%w %s: %w", ErrInvalidImport, m.Id, err)
+	}
+	if len(e.Credentials) == 0 {
+		return e, invalid(fmt.Errorf("entry has no credentials"))
+	}
+	if !m.NotBefore.IsZero() && !m.ExpiresAt.IsZero() && !m.ExpiresAt.After(m.NotBefore) {
+		return e, invalid(ErrInvalidWindow)
+	}
+	if m.MaxUses < 0 {
+		return e, invalid(ErrInvalidMaxUses)
+	}
+	if m.UseCount < 0 || m.ValidationCount < 0 {
+		return e, invalid(fmt.Errorf("use & validation counts must not be negative"))
+	}
+	m.Tags = NormalizeTags(m.Tags)
+	origins, err := NormalizeOrigins(m.AllowedOrigins)
+	if err != nil {
+		return e, invalid(err)
+	}
+	m.AllowedOrigins = origins
+	if m.CreatedOn.IsZero() {
+		m.CreatedOn = now
+	}
+	if m.UpdatedOn.IsZero() {
+		m.UpdatedOn = now
+	}
+
+	credentials := []ExportedCredential{}
+	seen := map[string]bool{}
+	for _, c := range e.Credentials {
+		if c.Name == "" || seen[c.Name] {
+			return e, invalid(fmt.Errorf("credential names must be unique & not empty"))
+		}
+		seen[c.Name] = true
+		if c.StorageMode != StorageModeEncrypted && c.StorageMode != StorageModeHash {
+			return e, invalid(fmt.Errorf("invalid storage mode: %s", c.StorageMode))
+		}
+		if c.StorageMode == StorageModeHash {
+			if err := crypto.ValidateVerifier(c.Secret); err != nil {
+				return e, invalid(fmt.Errorf("hashed credential %s must be a valid Argon2id verifier: %w", c.Name, err))
+			}
+		}
+		if !c.NotBefore.IsZero() && !c.ExpiresAt.IsZero() && !c.ExpiresAt.After(c.NotBefore) {
+			return e, invalid(ErrInvalidWindow)
+		}
+		if c.CreatedOn.IsZero() {
+			c.CreatedOn = now
+		}
+		credentials = append(credentials, c)
+	}
+	e.Credentials = credentials
+
+	if e.Payload != nil {
+		p := *e.Payload
+		if p.ContentType == "" {
+			return e, invalid(fmt.Errorf("payload has no content type"))
+		}
+		if p.UpdatedOn.IsZero() {
+			p.UpdatedOn = now
+		}
+		e.Payload = &p
+	}
+	if e.Redirect != "" {
+		if err := ValidateRedirectTarget(e.Redirect); err != nil {
+			return e, invalid(err)
+		}
+	}
+
+	routes := []Route{}
+	for _, r := range e.Routes {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return e, invalid(ErrInvalidRoute)
+		}
+		r.Host = NormalizeHost(r.Host)
+		r.EntryId = m.Id
+		if r.CreatedOn.IsZero() {
+			r.CreatedOn = now
+		}
+		routes = append(routes, r)
+	}
+	e.Routes = routes
+	return e, nil
+}
+
+// importPlan is what ImportEntries will write, once conflicts have been
+// resolved.
+type importPlan struct {
+	result  ImportResult
+	entries []plannedImport
+}
+
+type plannedImport struct {
+	entry ExportedEntry
+	// replace is set if an existing entry with the same id must be deleted
+	// first.
+	replace bool
+}
+
+// planImport resolves conflicts between entries & what the store already
+// holds according to policy. entryExists reports whether an id is taken &
+// routeOwner returns the entry an existing route leads to, if any. Under
+// ConflictFail, every conflict is reported at once.
+func planImport(entries []ExportedEntry, policy ConflictPolicy, entryExists func(id string) (bool, error), routeOwner func(host string, pathPrefix string) (string, error)) (*importPlan, error) {
+	plan := &importPlan{
+		result: ImportResult{Created: []string{}, Overwritten: []string{}, Skipped: []string{}, SkippedRoutes: []Route{}},
+	}
+	now := storedTime(time.Now())
+	ids := map[string]bool{}
+	for _, e := range entries {
+		e, err := normalizeImport(e, now)
+		if err != nil {
+			return nil, err
+		}
+		id := e.Metadata.Id
+		if ids[id] {
+			return nil, fmt.Errorf("%w: duplicate entry id %s", ErrInvalidImport, id)
+		}
+		ids[id] = true
+
+		exists, err := entryExists(id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !exists:
+			plan.result.Created = append(plan.result.Created, id)
+		case policy == ConflictOverwrite:
+			plan.result.Overwritten = append(plan.result.Overwritten, id)
+		default:
+			plan.result.Skipped = append(plan.result.Skipped, id)
+			if policy == ConflictSkip {
+				continue
+			}
+		}
+		plan.entries = append(plan.entries, plannedImport{e, exists})
+	}
+
+	conflicts := []string{}
+	if policy == ConflictFail {
+		conflicts = append(conflicts, plan.result.Skipped...)
+	}
+	routes := map[routeKey]string{}
+	for i, p := range plan.entries {
+		kept := []Route{}
+		for _, r := range p.entry.Routes {
+			k := routeKey{r.Host, r.PathPrefix}
+			if other, ok := routes[k]; ok {
+				return nil, fmt.Errorf("%w: route %s%s is given for both %s & %s", ErrInvalidImport, r.Host, r.PathPrefix, other, r.EntryId)
+			}
+			routes[k] = r.EntryId
+
+			owner, err := routeOwner(r.Host, r.PathPrefix)
+			if err != nil {
+				return nil, err
+			}
+			if owner == "" || owner == r.EntryId || policy == ConflictOverwrite {
+				kept = append(kept, r)
+			} else if policy == ConflictSkip {
+				plan.result.SkippedRoutes = append(plan.result.SkippedRoutes, r)
+			} else {
+				conflicts = append(conflicts, fmt.Sprintf("route %s%s (leads to %s)", r.Host, r.PathPrefix, owner))
+			}
+		}
+		plan.entries[i].entry.Routes = kept
+	}
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrImportConflict, strings.Join(conflicts, ", "))
+	}
+	return plan, nil
+}
+
+// exportCredentials opens the secrets of an entry's credentials.
+func (passddb *PassdDb) exportCredentials(id string, stored []storedCredential) ([]ExportedCredential, error) {
+	credentials := []ExportedCredential{}
+	for _, c := range stored {
+		secret, err := passddb.key.Decrypt(c.ciphertext, credentialAD(id, c.Name, c.StorageMode))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt credential %s/%s: %w", id, c.Name, err)
+		}
+		credentials = append(credentials, ExportedCredential{c.Credential, string(secret)})
+	}
+	return credentials, nil
+}
+
+// ExportEntries returns every entry along with its secrets in plaintext,
+// ordered by id & read within a single transaction.
+func (passddb *PassdDb) ExportEntries() ([]ExportedEntry, error) {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	metadata, err := listEntryMetadata(tx)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := loadCredentials(tx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := map[string]*Payload{}
+	rows, err := tx.Query("SELECT entry_id, content_type, filename, payload_enc, updated_on FROM payloads")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		var p Payload
+		var ciphertext []byte
+		var updatedOn sql.NullString
+		if err := rows.Scan(&id, &p.ContentType, &p.Filename, &ciphertext, &updatedOn); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if p.UpdatedOn, err = parseNullTimestamp(updatedOn); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if p.Content, err = passddb.key.Decrypt(ciphertext, payloadAD(id)); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to decrypt payload for %s: %w", id, err)
+		}
+		payloads[id] = &p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	redirects := map[string]string{}
+	rows, err = tx.Query("SELECT entry_id, target_enc FROM redirects")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		var ciphertext []byte
+		if err := rows.Scan(&id, &ciphertext); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		target, err := passddb.key.Decrypt(ciphertext, redirectAD(id))
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to decrypt redirect target for %s: %w", id, err)
+		}
+		redirects[id] = string(target)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	allRoutes, err := queryRoutes(tx, "")
+	if err != nil {
+		return nil, err
+	}
+	routes := map[string][]Route{}
+	for _, r := range allRoutes {
+		routes[r.EntryId] = append(routes[r.EntryId], r)
+	}
+
+	entries := []ExportedEntry{}
+	for _, m := range metadata {
+		exported, err := passddb.exportCredentials(m.Id, credentials[m.Id])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, ExportedEntry{
+			Metadata:    m,
+			Credentials: exported,
+			Payload:     payloads[m.Id],
+			Redirect:    redirects[m.Id],
+			Routes:      slices.Clone(routes[m.Id]),
+		})
+	}
+	return entries, nil
+}
+
+// ImportEntries adds entries exported from another instance, sealing their
+// secrets with this instance's key & resolving conflicts with existing
+// entries & routes according to policy. Everything is imported in a single
+// transaction, so nothing is if anything fails.
+func (passddb *PassdDb) ImportEntries(entries []ExportedEntry, policy ConflictPolicy) (*ImportResult, error) {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	plan, err := planImport(entries, policy,
+		func(id string) (bool, error) {
+			return entryExists(tx, id)
+		},
+		func(host string, pathPrefix string) (string, error) {
+			var owner string
+			err := tx.QueryRow("SELECT entry_id FROM routes WHERE host = ? AND path_prefix = ?", host, pathPrefix).Scan(&owner)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return "", fmt.Errorf("failed to check for existing route: %w", err)
+			}
+			return owner, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range plan.entries {
+		if p.replace {
+			if _, err := deleteEntry(tx, p.entry.Metadata.Id); err != nil {
+				return nil, err
+			}
+		}
+		if err := passddb.insertEntry(tx, p.entry); err != nil {
+			return nil, fmt.Errorf("failed to import %s: %w", p.entry.Metadata.Id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &plan.result, nil
+}
+
+func (passddb *PassdDb) insertEntry(tx *sqlTx, e ExportedEntry) error {
+	m := e.Metadata
+	tagsJson, err := json.Marshal(m.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to encode tags: %w", err)
+	}
+	originsJson, err := json.Marshal(m.AllowedOrigins)
+	if err != nil {
+		return fmt.Errorf("failed to encode allowed origins: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO passwords (id, created_on, updated_on, last_validated_on, validation_count, description, tags, not_before, expires_at, max_uses, use_count, allowed_origins)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.Id,
+		formatTimestamp(m.CreatedOn),
+		formatTimestamp(m.UpdatedOn),
+		nullTimestamp(m.LastValidatedOn),
+		m.ValidationCount,
+		m.Description,
+		string(tagsJson),
+		nullTimestamp(m.NotBefore),
+		nullTimestamp(m.ExpiresAt),
+		sql.NullInt64{Int64: int64(m.MaxUses), Valid: m.MaxUses > 0},
+		m.UseCount,
+		string(originsJson)); err != nil {
+		return fmt.Errorf("failed to create entry: %w", err)
+	}
+
+	for _, c := range e.Credentials {
+		ciphertext, err := passddb.key.Encrypt([]byte(c.Secret), credentialAD(m.Id, c.Name, c.StorageMode))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt password: %w", err)
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO credentials (entry_id, name, password_enc, storage_mode, created_on, not_before, expires_at, revision)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			m.Id, c.Name, ciphertext, c.StorageMode, formatTimestamp(c.CreatedOn), nullTimestamp(c.NotBefore), nullTimestamp(c.ExpiresAt), newRevision()); err != nil {
+			return fmt.Errorf("failed to create credential: %w", err)
+		}
+	}
+
+	if e.Payload != nil {
+		ciphertext, err := passddb.key.Encrypt(e.Payload.Content, payloadAD(m.Id))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt payload: %w", err)
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO payloads (entry_id, content_type, filename, size, payload_enc, updated_on) VALUES (?, ?, ?, ?, ?, ?)",
+			m.Id, e.Payload.ContentType, e.Payload.Filename, len(e.Payload.Content), ciphertext, formatTimestamp(e.Payload.UpdatedOn)); err != nil {
+			return fmt.Errorf("failed to set payload: %w", err)
+		}
+	}
+
+	if e.Redirect != "" {
+		ciphertext, err := passddb.key.Encrypt([]byte(e.Redirect), redirectAD(m.Id))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt redirect target: %w", err)
+		}
+		if _, err := tx.Exec("INSERT INTO redirects (entry_id, target_enc) VALUES (?, ?)", m.Id, ciphertext); err != nil {
+			return fmt.Errorf("failed to set redirect: %w", err)
+		}
+	}
+
+	for _, r := range e.Routes {
+		if _, err := tx.Exec(
+			`INSERT INTO routes (host, path_prefix, entry_id, created_on) VALUES (?, ?, ?, ?)
+			ON CONFLICT(host, path_prefix) DO UPDATE SET entry_id = excluded.entry_id, created_on = excluded.created_on`,
+			r.Host, r.PathPrefix, r.EntryId, formatTimestamp(r.CreatedOn)); err != nil {
+			return fmt.Errorf("failed to set route: %w", err)
+		}
+	}
+	return nil
+}
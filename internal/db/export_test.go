@@ -0,0 +1,36 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Hash mode secrets are checked with the parameters they carry, so importing
+// one that is malformed or out of range could crash or exhaust every later
+// validation of the entry.
+func TestImportRejectsInvalidVerifiers(t *testing.T) {
+	store := newSqliteStore(t, newKeyring(t))
+	for name, verifier := range map[string]string{
+		"not a verifier": "$argon2id$",
+		"no time":        "$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g",
+		"no threads":     "$argon2id$v=19$m=19456,t=2,p=0$c2FsdHNhbHRzYWx0c2FsdA$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g",
+		"huge memory":    "$argon2id$v=19$m=4294967295,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g",
+		"bad salt":       "$argon2id$v=19$m=19456,t=2,p=1$!!!$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g",
+		"no hash":        "$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$",
+	} {
+		t.Run(name, func(t *testing.T) {
+			entry := ExportedEntry{
+				Metadata: EntryMetadata{Id: "party"},
+				Credentials: []ExportedCredential{
+					{Credential: Credential{Name: DefaultCredentialName, StorageMode: StorageModeHash}, Secret: verifier},
+				},
+			}
+			_, err := store.ImportEntries([]ExportedEntry{entry}, ConflictFail)
+			if !errors.Is(err, ErrInvalidImport) || !strings.Contains(err.Error(), "verifier") {
+				t.Fatalf("expected ErrInvalidImport for the verifier, got %v", err)
+			}
+			expectResult(t, store, "party", "secret", ResultNotFound, "")
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+)
+
+func openSqlite(t *testing.T, path string, key *crypto.Keyring) *PassdDb {
+	t.Helper()
+	store, err := Open(path, key)
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	return store
+}
+
+func upgradePending(t *testing.T, store *PassdDb) bool {
+	t.Helper()
+	var pending bool
+	must(t, store.db.QueryRow("SELECT COUNT(*) > 0 FROM pending_upgrades WHERE name = ?", bindCiphertextsUpgrade).Scan(&pending))
+	return pending
+}
+
+func setSealed(t *testing.T, store *PassdDb, query string, ciphertext []byte, args ...any) {
+	t.Helper()
+	result, err := store.db.Exec(query, append([]any{ciphertext}, args...)...)
+	must(t, err)
+	if n, _ := result.RowsAffected(); n != 1 {
+		t.Fatalf("expected to update 1 row, updated %d", n)
+	}
+}
+
+// A DB written partly before & partly after ciphertexts were bound to their
+// rows is upgraded in place, but only once every legacy value has been
+// decrypted; opening it with the wrong key leaves the upgrade pending.
+func TestBindLegacyCiphertexts(t *testing.T) {
+	legacyKey, err := crypto.GeneratePassdKey()
+	must(t, err)
+	key := crypto.NewKeyring(crypto.LegacyKeyId, legacyKey)
+	wrongKey, err := crypto.GeneratePassdKey()
+	must(t, err)
+	path := filepath.Join(t.TempDir(), "passd.sqlite")
+
+	store := openSqlite(t, path, key)
+	if upgradePending(t, store) {
+		t.Fatalf("expected a new DB to have nothing to upgrade")
+	}
+	for _, id := range []string{"current", "unversioned", "versioned"} {
+		must(t, store.CreatePassword(id, "pw-"+id, StorageModeEncrypted))
+	}
+	must(t, store.CreatePassword("hashed", "pw-hashed", StorageModeHash))
+	_, err = store.SetPayload("unversioned", Payload{ContentType: "text/plain", Content: []byte("payload")})
+	must(t, err)
+	_, err = store.SetRedirect("versioned", "https://example.com/versioned")
+	must(t, err)
+
+	// Seal some of the values as older versions did: unversioned, or with a
+	// version 1 header, & without associated data
+	sealLegacy := func(plaintext string, versioned bool) []byte {
+		ciphertext, err := legacyKey.Encrypt([]byte(plaintext), nil)
+		must(t, err)
+		if versioned {
+			return append([]byte{1, 0, 0, 0, byte(crypto.LegacyKeyId)}, ciphertext...)
+		}
+		return ciphertext
+	}
+	verifier, err := crypto.NewVerifier([]byte("pw-hashed"))
+	must(t, err)
+	const setCredential = "UPDATE credentials SET password_enc = ? WHERE entry_id = ? AND name = ?"
+	setSealed(t, store, setCredential, sealLegacy("pw-unversioned", false), "unversioned", DefaultCredentialName)
+	setSealed(t, store, setCredential, sealLegacy("pw-versioned", true), "versioned", DefaultCredentialName)
+	setSealed(t, store, setCredential, sealLegacy(verifier, false), "hashed", DefaultCredentialName)
+	setSealed(t, store, "UPDATE payloads SET payload_enc = ? WHERE entry_id = ?", sealLegacy("payload", false), "unversioned")
+	setSealed(t, store, "UPDATE redirects SET target_enc = ? WHERE entry_id = ?", sealLegacy("https://example.com/versioned", true), "versioned")
+	_, err = store.db.Exec("INSERT INTO pending_upgrades (name) VALUES (?)", bindCiphertextsUpgrade)
+	must(t, err)
+	store.Close()
+
+	// With the wrong key nothing can be upgraded, so it's tried again later
+	store = openSqlite(t, path, crypto.NewKeyring(crypto.LegacyKeyId, wrongKey))
+	if !upgradePending(t, store) {
+		t.Fatalf("expected the upgrade to stay pending when values can't be decrypted")
+	}
+	store.Close()
+
+	store = openSqlite(t, path, key)
+	defer store.Close()
+	if upgradePending(t, store) {
+		t.Fatalf("expected the upgrade to be done once every value is decrypted")
+	}
+	for _, id := range []string{"current", "unversioned", "versioned", "hashed"} {
+		expectResult(t, store, id, "pw-"+id, ResultSuccess, DefaultCredentialName)
+	}
+	payload, err := store.GetPayload("unversioned")
+	must(t, err)
+	if payload == nil || string(payload.Content) != "payload" {
+		t.Fatalf("unexpected payload after upgrade: %+v", payload)
+	}
+	target, err := store.GetRedirect("versioned")
+	must(t, err)
+	if target != "https://example.com/versioned" {
+		t.Fatalf("unexpected redirect after upgrade: %q", target)
+	}
+
+	// Upgraded values are bound to their rows like any other
+	var ciphertext []byte
+	must(t, store.db.QueryRow("SELECT password_enc FROM credentials WHERE entry_id = ?", "unversioned").Scan(&ciphertext))
+	if key.IsLegacy(ciphertext) {
+		t.Fatalf("expected the value to be upgraded")
+	}
+	setSealed(t, store, setCredential, ciphertext, "current", DefaultCredentialName)
+	if _, _, err := store.ValidatePassword("current", "pw-unversioned"); err == nil {
+		t.Fatalf("expected a ciphertext moved to another row not to decrypt")
+	}
+}
@@ -0,0 +1,1035 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+)
+
+// MemoryStore is a Store that keeps everything in memory & loses it when the
+// process exits, e.g. for tests & throwaway instances. Secrets are still
+// sealed with the keyring, so it behaves just like PassdDb, down to key
+// rotation. Strings are copied before they are kept, since callers may pass
+// ones that alias a reused buffer (e.g. Fiber's request values).
+type MemoryStore struct {
+	mu  sync.Mutex
+	key *crypto.Keyring
+
+	dummy dummyCredential
+
+	entries     map[string]*memoryEntry
+	routes      map[routeKey]Route
+	attempts    []ValidationAttempt
+	audit       []AdminAuditRecord
+	throttles   map[string]ThrottleState
+	signingKeys []memorySigningKey
+}
+
+// memoryEntry holds an entry's metadata apart from Credentials, Payload &
+// HasRedirect, which are derived from the rest of the entry.
+type memoryEntry struct {
+	meta        EntryMetadata
+	credentials map[string]storedCredential
+	payload     *memoryPayload
+	targetEnc   []byte
+}
+
+type memoryPayload struct {
+	info       PayloadInfo
+	ciphertext []byte
+}
+
+type memorySigningKey struct {
+	SigningKey
+	ciphertext []byte
+}
+
+type routeKey struct {
+	host       string
+	pathPrefix string
+}
+
+func NewMemoryStore(key *crypto.Keyring) (*MemoryStore, error) {
+	dummy, err := newDummyCredential()
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryStore{
+		key:       key,
+		dummy:     dummy,
+		entries:   map[string]*memoryEntry{},
+		routes:    map[routeKey]Route{},
+		throttles: map[string]ThrottleState{},
+	}, nil
+}
+
+// storedTime truncates t to what PassdDb keeps of a timestamp, so that both
+// stores compare times the same way.
+func storedTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC().Truncate(time.Second)
+}
+
+func memoryNow() time.Time {
+	return storedTime(time.Now())
+}
+
+func cloneStrings(values []string) []string {
+	cloned := make([]string, len(values))
+	for i, v := range values {
+		cloned[i] = strings.Clone(v)
+	}
+	return cloned
+}
+
+// sortedCredentials returns the entry's credentials ordered by name.
+func (e *memoryEntry) sortedCredentials() []storedCredential {
+	credentials := []storedCredential{}
+	for _, c := range e.credentials {
+		credentials = append(credentials, c)
+	}
+	slices.SortFunc(credentials, func(a, b storedCredential) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return credentials
+}
+
+func (e *memoryEntry) metadata() EntryMetadata {
+	m := e.meta
+	m.Tags = slices.Clone(m.Tags)
+	m.AllowedOrigins = slices.Clone(m.AllowedOrigins)
+	m.Credentials = credentialInfo(e.sortedCredentials())
+	if e.payload != nil {
+		info := e.payload.info
+		m.Payload = &info
+	}
+	m.HasRedirect = e.targetEnc != nil
+	return m
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+// ValidatePassword behaves like PassdDb.ValidatePassword.
+func (store *MemoryStore) ValidatePassword(id string, password string) (ValidationResult, string, error) {
+	return store.validatePassword(id, password, true)
+}
+
+// CheckPassword behaves like PassdDb.CheckPassword.
+func (store *MemoryStore) CheckPassword(id string, password string) (ValidationResult, string, error) {
+	return store.validatePassword(id, password, false)
+}
+
+func (store *MemoryStore) validatePassword(id string, password string, consume bool) (ValidationResult, string, error) {
+	// Passwords are checked without holding the lock, since hashed ones are
+	// deliberately slow to check.
+	store.mu.Lock()
+	var credentials []storedCredential
+	if e, ok := store.entries[id]; ok {
+		credentials = e.sortedCredentials()
+	}
+	store.mu.Unlock()
+
+	now := time.Now()
+	result, matched, err := matchCredentials(store.key, store.dummy, id, credentials, password, now)
+	if err != nil || result != "" {
+		return result, matched, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return ResultNotFound, "", nil
+	}
+	if result, ok := checkWindow(e.meta.NotBefore, e.meta.ExpiresAt, now); !ok {
+		return result, matched, nil
+	}
+	if e.meta.MaxUses > 0 && e.meta.UseCount >= e.meta.MaxUses {
+		return ResultExhausted, matched, nil
+	}
+	if !consume {
+		return ResultSuccess, matched, nil
+	}
+	e.meta.LastValidatedOn = storedTime(now)
+	e.meta.ValidationCount++
+	e.meta.UseCount++
+	return ResultSuccess, matched, nil
+}
+
+func (store *MemoryStore) newEntry(id string, now time.Time) *memoryEntry {
+	id = strings.Clone(id)
+	e := &memoryEntry{
+		meta: EntryMetadata{
+			Id:             id,
+			CreatedOn:      now,
+			UpdatedOn:      now,
+			Tags:           []string{},
+			AllowedOrigins: []string{},
+		},
+		credentials: map[string]storedCredential{},
+	}
+	store.entries[id] = e
+	return e
+}
+
+func (store *MemoryStore) CreatePassword(id string, password string, mode StorageMode) error {
+	ciphertext, err := sealCredential(store.key, id, DefaultCredentialName, password, mode)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.entries[id]; ok {
+		return ErrConflict
+	}
+	now := memoryNow()
+	e := store.newEntry(id, now)
+	e.credentials[DefaultCredentialName] = storedCredential{
+		Credential: Credential{Name: DefaultCredentialName, StorageMode: StorageMode(strings.Clone(string(mode))), CreatedOn: now, revision: newRevision()},
+		ciphertext: ciphertext,
+	}
+	return nil
+}
+
+func (store *MemoryStore) UpsertPassword(id string, password string, mode StorageMode) (bool, error) {
+	ciphertext, err := sealCredential(store.key, id, DefaultCredentialName, password, mode)
+	if err != nil {
+		return false, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := memoryNow()
+	e, exists := store.entries[id]
+	if exists {
+		e.meta.UseCount = 0
+		e.meta.UpdatedOn = now
+	} else {
+		e = store.newEntry(id, now)
+	}
+	// Like PassdDb, this keeps an existing default credential's window
+	c := e.credentials[DefaultCredentialName]
+	c.Name, c.StorageMode, c.CreatedOn, c.ciphertext = DefaultCredentialName, StorageMode(strings.Clone(string(mode))), now, ciphertext
+	c.revision = newRevision()
+	e.credentials[DefaultCredentialName] = c
+	return !exists, nil
+}
+
+func (store *MemoryStore) deleteEntry(id string) bool {
+	if _, ok := store.entries[id]; !ok {
+		return false
+	}
+	delete(store.entries, id)
+	for k, r := range store.routes {
+		if r.EntryId == id {
+			delete(store.routes, k)
+		}
+	}
+	return true
+}
+
+func (store *MemoryStore) DeleteEntry(id string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.deleteEntry(id), nil
+}
+
+func (store *MemoryStore) GetPassword(id string) ([]byte, error) {
+	return store.GetCredentialPassword(id, DefaultCredentialName)
+}
+
+func (store *MemoryStore) ListIds() ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	ids := []string{}
+	for id := range store.entries {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Reencrypt behaves like PassdDb.Reencrypt.
+func (store *MemoryStore) Reencrypt(limit int) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	updated := 0
+	reseal := func(ciphertext []byte, ad []byte) ([]byte, error) {
+		if (limit > 0 && updated >= limit) || !store.key.IsStale(ciphertext) {
+			return ciphertext, nil
+		}
+		plaintext, err := store.key.Decrypt(ciphertext, ad)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt: %w", err)
+		}
+		if ciphertext, err = store.key.Encrypt(plaintext, ad); err != nil {
+			return nil, fmt.Errorf("failed to encrypt: %w", err)
+		}
+		updated++
+		return ciphertext, nil
+	}
+
+	// Everything is re-sealed before any of it is replaced, so that a failure
+	// leaves the store unchanged.
+	credentials := map[string]map[string][]byte{}
+	payloads := map[string][]byte{}
+	targets := map[string][]byte{}
+	for id, e := range store.entries {
+		credentials[id] = map[string][]byte{}
+		for name, c := range e.credentials {
+			ciphertext, err := reseal(c.ciphertext, credentialAD(id, name, c.StorageMode))
+			if err != nil {
+				return 0, fmt.Errorf("failed to re-encrypt credential %s/%s: %w", id, name, err)
+			}
+			credentials[id][name] = ciphertext
+		}
+		if e.payload != nil {
+			ciphertext, err := reseal(e.payload.ciphertext, payloadAD(id))
+			if err != nil {
+				return 0, fmt.Errorf("failed to re-encrypt payload for %s: %w", id, err)
+			}
+			payloads[id] = ciphertext
+		}
+		if e.targetEnc != nil {
+			ciphertext, err := reseal(e.targetEnc, redirectAD(id))
+			if err != nil {
+				return 0, fmt.Errorf("failed to re-encrypt redirect for %s: %w", id, err)
+			}
+			targets[id] = ciphertext
+		}
+	}
+	signingKeys := [][]byte{}
+	for _, k := range store.signingKeys {
+		ciphertext, err := reseal(k.ciphertext, signingKeyAD(k.Id))
+		if err != nil {
+			return 0, fmt.Errorf("failed to re-encrypt signing key %s: %w", k.Id, err)
+		}
+		signingKeys = append(signingKeys, ciphertext)
+	}
+
+	for id, e := range store.entries {
+		for name, c := range e.credentials {
+			c.ciphertext = credentials[id][name]
+			e.credentials[name] = c
+		}
+		if e.payload != nil {
+			e.payload.ciphertext = payloads[id]
+		}
+		if e.targetEnc != nil {
+			e.targetEnc = targets[id]
+		}
+	}
+	for i := range store.signingKeys {
+		store.signingKeys[i].ciphertext = signingKeys[i]
+	}
+	return updated, nil
+}
+
+// CountSealedByKey behaves like PassdDb.CountSealedByKey.
+func (store *MemoryStore) CountSealedByKey() (map[uint32]int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	counts := map[uint32]int{}
+	for _, e := range store.entries {
+		for _, c := range e.credentials {
+			counts[store.key.KeyId(c.ciphertext)]++
+		}
+		if e.payload != nil {
+			counts[store.key.KeyId(e.payload.ciphertext)]++
+		}
+		if e.targetEnc != nil {
+			counts[store.key.KeyId(e.targetEnc)]++
+		}
+	}
+	for _, k := range store.signingKeys {
+		counts[store.key.KeyId(k.ciphertext)]++
+	}
+	return counts, nil
+}
+
+// Backup isn't supported, since nothing in memory outlives the process.
+func (store *MemoryStore) Backup(dest string) error {
+	return ErrBackupUnsupported
+}
+
+func (store *MemoryStore) ExportEntries() ([]ExportedEntry, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	ids := []string{}
+	for id := range store.entries {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	entries := []ExportedEntry{}
+	for _, id := range ids {
+		e := store.entries[id]
+		exported := ExportedEntry{
+			Metadata:    e.metadata(),
+			Credentials: []ExportedCredential{},
+			Routes:      store.sortedRoutes(func(r Route) bool { return r.EntryId == id }),
+		}
+		for _, c := range e.sortedCredentials() {
+			secret, err := store.key.Decrypt(c.ciphertext, credentialAD(id, c.Name, c.StorageMode))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt credential %s/%s: %w", id, c.Name, err)
+			}
+			exported.Credentials = append(exported.Credentials, ExportedCredential{c.Credential, string(secret)})
+		}
+		if e.payload != nil {
+			content, err := store.key.Decrypt(e.payload.ciphertext, payloadAD(id))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt payload for %s: %w", id, err)
+			}
+			exported.Payload = &Payload{
+				ContentType: e.payload.info.ContentType,
+				Filename:    e.payload.info.Filename,
+				Content:     content,
+				UpdatedOn:   e.payload.info.UpdatedOn,
+			}
+		}
+		if e.targetEnc != nil {
+			target, err := store.key.Decrypt(e.targetEnc, redirectAD(id))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt redirect target for %s: %w", id, err)
+			}
+			exported.Redirect = string(target)
+		}
+		entries = append(entries, exported)
+	}
+	return entries, nil
+}
+
+// ImportEntries behaves like PassdDb.ImportEntries.
+func (store *MemoryStore) ImportEntries(entries []ExportedEntry, policy ConflictPolicy) (*ImportResult, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	plan, err := planImport(entries, policy,
+		func(id string) (bool, error) {
+			_, ok := store.entries[id]
+			return ok, nil
+		},
+		func(host string, pathPrefix string) (string, error) {
+			return store.routes[routeKey{host, pathPrefix}].EntryId, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything is sealed before anything is stored, so that a failure
+	// leaves the store unchanged.
+	imported := []*memoryEntry{}
+	for _, p := range plan.entries {
+		e, err := store.sealImport(p.entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import %s: %w", p.entry.Metadata.Id, err)
+		}
+		imported = append(imported, e)
+	}
+	for i, p := range plan.entries {
+		store.deleteEntry(p.entry.Metadata.Id)
+		store.entries[imported[i].meta.Id] = imported[i]
+		for _, r := range p.entry.Routes {
+			r.Host, r.PathPrefix, r.EntryId = strings.Clone(r.Host), strings.Clone(r.PathPrefix), imported[i].meta.Id
+			store.routes[routeKey{r.Host, r.PathPrefix}] = r
+		}
+	}
+	return &plan.result, nil
+}
+
+func (store *MemoryStore) sealImport(exported ExportedEntry) (*memoryEntry, error) {
+	m := exported.Metadata
+	m.Id = strings.Clone(m.Id)
+	m.Description = strings.Clone(m.Description)
+	m.Tags = cloneStrings(m.Tags)
+	m.AllowedOrigins = cloneStrings(m.AllowedOrigins)
+	m.CreatedOn, m.UpdatedOn, m.LastValidatedOn = storedTime(m.CreatedOn), storedTime(m.UpdatedOn), storedTime(m.LastValidatedOn)
+	m.NotBefore, m.ExpiresAt = storedTime(m.NotBefore), storedTime(m.ExpiresAt)
+	m.Credentials, m.Payload, m.HasRedirect = nil, nil, false
+	e := &memoryEntry{meta: m, credentials: map[string]storedCredential{}}
+
+	for _, c := range exported.Credentials {
+		ciphertext, err := store.key.Encrypt([]byte(c.Secret), credentialAD(m.Id, c.Name, c.StorageMode))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt password: %w", err)
+		}
+		name := strings.Clone(c.Name)
+		e.credentials[name] = storedCredential{
+			Credential: Credential{
+				Name:        name,
+				StorageMode: StorageMode(strings.Clone(string(c.StorageMode))),
+				CreatedOn:   storedTime(c.CreatedOn),
+				NotBefore:   storedTime(c.NotBefore),
+				ExpiresAt:   storedTime(c.ExpiresAt),
+				revision:    newRevision(),
+			},
+			ciphertext: ciphertext,
+		}
+	}
+	if p := exported.Payload; p != nil {
+		ciphertext, err := store.key.Encrypt(p.Content, payloadAD(m.Id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt payload: %w", err)
+		}
+		e.payload = &memoryPayload{
+			info: PayloadInfo{
+				ContentType: strings.Clone(p.ContentType),
+				Filename:    strings.Clone(p.Filename),
+				Size:        len(p.Content),
+				UpdatedOn:   storedTime(p.UpdatedOn),
+			},
+			ciphertext: ciphertext,
+		}
+	}
+	if exported.Redirect != "" {
+		ciphertext, err := store.key.Encrypt([]byte(exported.Redirect), redirectAD(m.Id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt redirect target: %w", err)
+		}
+		e.targetEnc = ciphertext
+	}
+	return e, nil
+}
+
+func (store *MemoryStore) ListCredentials(id string) ([]Credential, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return nil, nil
+	}
+	return credentialInfo(e.sortedCredentials()), nil
+}
+
+func (store *MemoryStore) SetCredential(id string, name string, update CredentialUpdate) (bool, error) {
+	if name == "" {
+		return false, fmt.Errorf("credential name must not be empty")
+	}
+	if !update.NotBefore.IsZero() && !update.ExpiresAt.IsZero() && !update.ExpiresAt.After(update.NotBefore) {
+		return false, ErrInvalidWindow
+	}
+	ciphertext, err := sealCredential(store.key, id, name, update.Password, update.StorageMode)
+	if err != nil {
+		return false, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return false, ErrEntryNotFound
+	}
+	_, exists := e.credentials[name]
+	name = strings.Clone(name)
+	now := memoryNow()
+	e.credentials[name] = storedCredential{
+		Credential: Credential{
+			Name:        name,
+			StorageMode: StorageMode(strings.Clone(string(update.StorageMode))),
+			CreatedOn:   now,
+			NotBefore:   storedTime(update.NotBefore),
+			ExpiresAt:   storedTime(update.ExpiresAt),
+			revision:    newRevision(),
+		},
+		ciphertext: ciphertext,
+	}
+	e.meta.UpdatedOn = now
+	return !exists, nil
+}
+
+func (store *MemoryStore) RemoveCredential(id string, name string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return false, nil
+	}
+	if _, ok := e.credentials[name]; !ok {
+		return false, nil
+	}
+	if len(e.credentials) == 1 {
+		return false, ErrLastCredential
+	}
+	delete(e.credentials, name)
+	e.meta.UpdatedOn = memoryNow()
+	return true, nil
+}
+
+func (store *MemoryStore) GetCredentialPassword(id string, name string) ([]byte, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return nil, nil
+	}
+	c, ok := e.credentials[name]
+	if !ok {
+		return nil, nil
+	}
+	if c.StorageMode == StorageModeHash {
+		return nil, ErrNotRetrievable
+	}
+	plaintext, err := store.key.Decrypt(c.ciphertext, credentialAD(id, name, c.StorageMode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt password: %w", err)
+	}
+	return plaintext, nil
+}
+
+func (store *MemoryStore) ListEntryMetadata() ([]EntryMetadata, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entries := []EntryMetadata{}
+	for _, e := range store.entries {
+		entries = append(entries, e.metadata())
+	}
+	slices.SortFunc(entries, func(a, b EntryMetadata) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	return entries, nil
+}
+
+func (store *MemoryStore) GetEntryMetadata(id string) (*EntryMetadata, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return nil, nil
+	}
+	m := e.metadata()
+	return &m, nil
+}
+
+func (store *MemoryStore) UpdateEntryMetadata(id string, update EntryMetadataUpdate) (bool, error) {
+	if update.MaxUses != nil && *update.MaxUses < 0 {
+		return false, ErrInvalidMaxUses
+	}
+	var origins []string
+	if update.AllowedOrigins != nil {
+		var err error
+		if origins, err = NormalizeOrigins(*update.AllowedOrigins); err != nil {
+			return false, err
+		}
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return false, nil
+	}
+	notBefore, expiresAt, err := update.window(e.meta.NotBefore, e.meta.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	e.meta.NotBefore = storedTime(notBefore)
+	e.meta.ExpiresAt = storedTime(expiresAt)
+	if update.Description != nil {
+		e.meta.Description = strings.Clone(*update.Description)
+	}
+	if update.Tags != nil {
+		e.meta.Tags = cloneStrings(NormalizeTags(*update.Tags))
+	}
+	if update.MaxUses != nil {
+		e.meta.MaxUses = *update.MaxUses
+	}
+	if update.AllowedOrigins != nil {
+		e.meta.AllowedOrigins = cloneStrings(origins)
+	}
+	e.meta.UpdatedOn = memoryNow()
+	return true, nil
+}
+
+func (store *MemoryStore) PurgeExpiredEntries(before time.Time) ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	before = storedTime(before)
+	ids := []string{}
+	for id, e := range store.entries {
+		if !e.meta.ExpiresAt.IsZero() && e.meta.ExpiresAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		store.deleteEntry(id)
+	}
+	return ids, nil
+}
+
+func (store *MemoryStore) IsOriginAllowed(origin string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	origin = strings.ToLower(origin)
+	for _, e := range store.entries {
+		if len(e.meta.AllowedOrigins) == 0 || slices.Contains(e.meta.AllowedOrigins, origin) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (store *MemoryStore) SetAllowedOrigins(id string, origins []string) (bool, error) {
+	origins, err := NormalizeOrigins(origins)
+	if err != nil {
+		return false, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return false, nil
+	}
+	e.meta.AllowedOrigins = cloneStrings(origins)
+	e.meta.UpdatedOn = memoryNow()
+	return true, nil
+}
+
+func (store *MemoryStore) SetPayload(id string, payload Payload) (bool, error) {
+	ciphertext, err := store.key.Encrypt(payload.Content, payloadAD(id))
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return false, ErrEntryNotFound
+	}
+	existed := e.payload != nil
+	now := memoryNow()
+	e.payload = &memoryPayload{
+		info: PayloadInfo{
+			ContentType: strings.Clone(payload.ContentType),
+			Filename:    strings.Clone(payload.Filename),
+			Size:        len(payload.Content),
+			UpdatedOn:   now,
+		},
+		ciphertext: ciphertext,
+	}
+	e.meta.UpdatedOn = now
+	return !existed, nil
+}
+
+func (store *MemoryStore) GetPayload(id string) (*Payload, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok || e.payload == nil {
+		return nil, nil
+	}
+	content, err := store.key.Decrypt(e.payload.ciphertext, payloadAD(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	return &Payload{
+		ContentType: e.payload.info.ContentType,
+		Filename:    e.payload.info.Filename,
+		Content:     content,
+		UpdatedOn:   e.payload.info.UpdatedOn,
+	}, nil
+}
+
+func (store *MemoryStore) RemovePayload(id string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok || e.payload == nil {
+		return false, nil
+	}
+	e.payload = nil
+	e.meta.UpdatedOn = memoryNow()
+	return true, nil
+}
+
+func (store *MemoryStore) SetRedirect(id string, target string) (bool, error) {
+	if err := ValidateRedirectTarget(target); err != nil {
+		return false, err
+	}
+	ciphertext, err := store.key.Encrypt([]byte(target), redirectAD(id))
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt redirect target: %w", err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok {
+		return false, ErrEntryNotFound
+	}
+	existed := e.targetEnc != nil
+	e.targetEnc = ciphertext
+	e.meta.UpdatedOn = memoryNow()
+	return !existed, nil
+}
+
+func (store *MemoryStore) GetRedirect(id string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok || e.targetEnc == nil {
+		return "", nil
+	}
+	target, err := store.key.Decrypt(e.targetEnc, redirectAD(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt redirect target: %w", err)
+	}
+	return string(target), nil
+}
+
+func (store *MemoryStore) RemoveRedirect(id string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	e, ok := store.entries[id]
+	if !ok || e.targetEnc == nil {
+		return false, nil
+	}
+	e.targetEnc = nil
+	e.meta.UpdatedOn = memoryNow()
+	return true, nil
+}
+
+// sortedRoutes returns the routes that satisfy include, ordered by host &
+// path prefix.
+func (store *MemoryStore) sortedRoutes(include func(Route) bool) []Route {
+	routes := []Route{}
+	for _, r := range store.routes {
+		if include(r) {
+			routes = append(routes, r)
+		}
+	}
+	slices.SortFunc(routes, func(a, b Route) int {
+		if c := strings.Compare(a.Host, b.Host); c != 0 {
+			return c
+		}
+		return strings.Compare(a.PathPrefix, b.PathPrefix)
+	})
+	return routes
+}
+
+func (store *MemoryStore) ListRoutes() ([]Route, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.sortedRoutes(func(Route) bool { return true }), nil
+}
+
+func (store *MemoryStore) ResolveRoute(host string, path string) (*Route, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	host = NormalizeHost(host)
+	routes := store.sortedRoutes(func(r Route) bool { return r.Host == host || r.Host == "" })
+	return bestRoute(routes, path)
+}
+
+func (store *MemoryStore) SetRoute(r Route) (bool, error) {
+	if !strings.HasPrefix(r.PathPrefix, "/") {
+		return false, ErrInvalidRoute
+	}
+	r.Host = NormalizeHost(r.Host)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.entries[r.EntryId]; !ok {
+		return false, ErrEntryNotFound
+	}
+	r.Host, r.PathPrefix, r.EntryId = strings.Clone(r.Host), strings.Clone(r.PathPrefix), strings.Clone(r.EntryId)
+	k := routeKey{r.Host, r.PathPrefix}
+	_, exists := store.routes[k]
+	r.CreatedOn = memoryNow()
+	store.routes[k] = r
+	return !exists, nil
+}
+
+func (store *MemoryStore) RemoveRoute(host string, pathPrefix string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	k := routeKey{NormalizeHost(host), pathPrefix}
+	r, ok := store.routes[k]
+	if !ok {
+		return "", nil
+	}
+	delete(store.routes, k)
+	return r.EntryId, nil
+}
+
+// page applies limit & offset like PassdDb's queries do.
+func page[T any](items []T, limit int, offset int) []T {
+	items = items[min(max(offset, 0), len(items)):]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// inRange reports whether t falls within [since, until), ignoring zero
+// bounds.
+func inRange(t time.Time, since time.Time, until time.Time) bool {
+	return (since.IsZero() || !t.Before(storedTime(since))) && (until.IsZero() || t.Before(storedTime(until)))
+}
+
+func (store *MemoryStore) RecordValidationAttempt(attempt ValidationAttempt) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	attempt.EntryId = strings.Clone(attempt.EntryId)
+	attempt.Result = ValidationResult(strings.Clone(string(attempt.Result)))
+	attempt.ClientIP = strings.Clone(attempt.ClientIP)
+	attempt.UserAgent = strings.Clone(attempt.UserAgent)
+	attempt.RequestId = strings.Clone(attempt.RequestId)
+	attempt.Credential = strings.Clone(attempt.Credential)
+	attempt.AttemptId = int64(len(store.attempts) + 1)
+	attempt.AttemptedOn = storedTime(attempt.AttemptedOn)
+	store.attempts = append(store.attempts, attempt)
+	return nil
+}
+
+func (store *MemoryStore) ListValidationAttempts(filter AttemptFilter) ([]ValidationAttempt, int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	attempts := []ValidationAttempt{}
+	for _, a := range slices.Backward(store.attempts) {
+		if (filter.EntryId == "" || a.EntryId == filter.EntryId) &&
+			(filter.Result == "" || a.Result == filter.Result) &&
+			(filter.ClientIP == "" || a.ClientIP == filter.ClientIP) &&
+			inRange(a.AttemptedOn, filter.Since, filter.Until) {
+			attempts = append(attempts, a)
+		}
+	}
+	return page(attempts, filter.Limit, filter.Offset), len(attempts), nil
+}
+
+func (store *MemoryStore) RecordAdminAction(record AdminAuditRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	record.Action = AdminAction(strings.Clone(string(record.Action)))
+	record.EntryId = strings.Clone(record.EntryId)
+	record.Subject = strings.Clone(record.Subject)
+	record.Username = strings.Clone(record.Username)
+	record.ClientIP = strings.Clone(record.ClientIP)
+	record.RequestId = strings.Clone(record.RequestId)
+	record.Credential = strings.Clone(record.Credential)
+	record.AuditId = int64(len(store.audit) + 1)
+	record.OccurredOn = storedTime(record.OccurredOn)
+	store.audit = append(store.audit, record)
+	return nil
+}
+
+func (store *MemoryStore) ListAdminAuditRecords(filter AuditFilter) ([]AdminAuditRecord, int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	records := []AdminAuditRecord{}
+	for _, r := range store.audit {
+		if (filter.EntryId == "" || r.EntryId == filter.EntryId) &&
+			(filter.Action == "" || r.Action == filter.Action) &&
+			(filter.Subject == "" || r.Subject == filter.Subject) &&
+			inRange(r.OccurredOn, filter.Since, filter.Until) {
+			records = append(records, r)
+		}
+	}
+	return page(records, filter.Limit, filter.Offset), len(records), nil
+}
+
+func (store *MemoryStore) UpdateThrottleState(key string, update func(state ThrottleState) ThrottleState) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	state, ok := store.throttles[key]
+	if !ok {
+		state = ThrottleState{Key: strings.Clone(key), LastFailureOn: memoryNow()}
+	}
+	state = update(state)
+	state.Key = strings.Clone(key)
+	state.LastFailureOn = storedTime(state.LastFailureOn)
+	state.LockedUntil = storedTime(state.LockedUntil)
+	store.throttles[state.Key] = state
+	return nil
+}
+
+func (store *MemoryStore) DeleteThrottleState(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.throttles, key)
+	return nil
+}
+
+func (store *MemoryStore) PruneThrottleStates(before time.Time) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	cutoff := storedTime(before)
+	pruned := 0
+	for key, state := range store.throttles {
+		if state.LastFailureOn.Before(cutoff) && (state.LockedUntil.IsZero() || state.LockedUntil.Before(cutoff)) {
+			delete(store.throttles, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func (store *MemoryStore) ListSigningKeys() ([]SigningKey, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	keys := []SigningKey{}
+	for _, k := range slices.Backward(store.signingKeys) {
+		privateKey, err := store.key.Decrypt(k.ciphertext, signingKeyAD(k.Id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt signing key %s: %w", k.Id, err)
+		}
+		key := k.SigningKey
+		key.PrivateKey = privateKey
+		keys = append(keys, key)
+	}
+	slices.SortStableFunc(keys, func(a, b SigningKey) int {
+		return b.CreatedOn.Compare(a.CreatedOn)
+	})
+	return keys, nil
+}
+
+func (store *MemoryStore) RotateSigningKey(key SigningKey) error {
+	ciphertext, err := store.key.Encrypt(key.PrivateKey, signingKeyAD(key.Id))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	createdOn := storedTime(key.CreatedOn)
+	for i := range store.signingKeys {
+		if store.signingKeys[i].RetiredOn.IsZero() {
+			store.signingKeys[i].RetiredOn = createdOn
+		}
+	}
+	store.signingKeys = append(store.signingKeys, memorySigningKey{
+		SigningKey: SigningKey{Id: strings.Clone(key.Id), Algorithm: strings.Clone(key.Algorithm), CreatedOn: createdOn},
+		ciphertext: ciphertext,
+	})
+	return nil
+}
+
+func (store *MemoryStore) PruneSigningKeys(retiredBefore time.Time) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	cutoff := storedTime(retiredBefore)
+	kept := store.signingKeys[:0]
+	for _, k := range store.signingKeys {
+		if k.RetiredOn.IsZero() || !k.RetiredOn.Before(cutoff) {
+			kept = append(kept, k)
+		}
+	}
+	pruned := len(store.signingKeys) - len(kept)
+	store.signingKeys = kept
+	return pruned, nil
+}
@@ -0,0 +1,473 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EntryMetadata is everything known about an entry apart from its secret, so
+// loading it never requires decrypting anything.
+type EntryMetadata struct {
+	Id          string
+	Credentials []Credential
+	// Payload is nil if the entry has no payload.
+	Payload *PayloadInfo
+	// HasRedirect reports whether the entry's /p/:id link leads anywhere.
+	HasRedirect bool
+	CreatedOn   time.Time
+	UpdatedOn   time.Time
+	// LastValidatedOn is the time of the last successful validation, or the
+	// zero time if there hasn't been one.
+	LastValidatedOn time.Time
+	// ValidationCount is the number of successful validations.
+	ValidationCount int
+	Description     string
+	Tags            []string
+	// NotBefore & ExpiresAt bound when the entry can be validated. Zero
+	// times leave the window open at that end.
+	NotBefore time.Time
+	ExpiresAt time.Time
+	// MaxUses is the number of successful validations the entry allows, or
+	// zero if unlimited. UseCount is the number consumed so far.
+	MaxUses  int
+	UseCount int
+	// AllowedOrigins are the origins of pages that may validate the entry. If
+	// empty, any page may.
+	AllowedOrigins []string
+}
+
+// RemainingUses returns how many more times the entry can be validated, or
+// -1 if it is unlimited.
+func (m EntryMetadata) RemainingUses() int {
+	if m.MaxUses == 0 {
+		return -1
+	}
+	return max(m.MaxUses-m.UseCount, 0)
+}
+
+// CredentialFingerprint identifies the credential with the given name as it
+// is now, changing if its password is replaced or the entry is deleted &
+// created again. Returns "" if the entry has no such credential.
+func (m EntryMetadata) CredentialFingerprint(name string) string {
+	for _, c := range m.Credentials {
+		if c.Name != name {
+			continue
+		}
+		hash := sha256.New()
+		for _, part := range []string{m.Id, formatTimestamp(m.CreatedOn), c.Name, string(c.StorageMode), formatTimestamp(c.CreatedOn), c.revision} {
+			hash.Write([]byte(part))
+			hash.Write([]byte{0})
+		}
+		return base64.RawURLEncoding.EncodeToString(hash.Sum(nil)[:16])
+	}
+	return ""
+}
+
+// EntryMetadataUpdate holds the metadata that admins can change directly.
+// Only the fields that are set are changed.
+type EntryMetadataUpdate struct {
+	Description *string
+	Tags        *[]string
+	// NotBefore & ExpiresAt of the zero time remove the bound.
+	NotBefore *time.Time
+	ExpiresAt *time.Time
+	// MaxUses of zero means unlimited.
+	MaxUses        *int
+	AllowedOrigins *[]string
+}
+
+// window returns the activation window an entry with the given window would
+// have after the update, failing if it would expire before it becomes
+// active.
+func (update EntryMetadataUpdate) window(notBefore time.Time, expiresAt time.Time) (time.Time, time.Time, error) {
+	if update.NotBefore != nil {
+		notBefore = *update.NotBefore
+	}
+	if update.ExpiresAt != nil {
+		expiresAt = *update.ExpiresAt
+	}
+	if !notBefore.IsZero() && !expiresAt.IsZero() && !expiresAt.After(notBefore) {
+		return time.Time{}, time.Time{}, ErrInvalidWindow
+	}
+	return notBefore, expiresAt, nil
+}
+
+var (
+	// ErrInvalidWindow is returned when an entry would expire before it
+	// becomes active.
+	ErrInvalidWindow  error = fmt.Errorf("expires_at must be after not_before")
+	ErrInvalidMaxUses error = fmt.Errorf("max_uses must not be negative")
+	ErrInvalidOrigin  error = fmt.Errorf("allowed_origins must be origins like https://example.com")
+)
+
+// AllowsOrigin reports whether a page from origin may validate the entry.
+// Requests that don't come from a page (no origin) are always allowed.
+func (m EntryMetadata) AllowsOrigin(origin string) bool {
+	if origin == "" || len(m.AllowedOrigins) == 0 {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range m.AllowedOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOriginAllowed reports whether at least one entry may be validated by a
+// page from origin, for answering CORS preflight requests that don't say
+// which entry they are for.
+func (passddb *PassdDb) IsOriginAllowed(origin string) (bool, error) {
+	var allowed bool
+	err := passddb.db.QueryRow(passddb.db.dialect.originAllowedSql, strings.ToLower(origin)).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check allowed origins: %w", err)
+	}
+	return allowed, nil
+}
+
+// SetAllowedOrigins replaces the origins allowed to validate the entry with
+// the given id, returning false if no such entry exists.
+func (passddb *PassdDb) SetAllowedOrigins(id string, origins []string) (bool, error) {
+	origins, err := NormalizeOrigins(origins)
+	if err != nil {
+		return false, err
+	}
+	originsJson, err := json.Marshal(origins)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode allowed origins: %w", err)
+	}
+	result, err := passddb.db.Exec("UPDATE passwords SET allowed_origins = ?, updated_on = CURRENT_TIMESTAMP WHERE id = ?", string(originsJson), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to update allowed origins: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// checkWindow reports whether an entry with the given window can be
+// validated at t, returning the reason if not.
+func checkWindow(notBefore time.Time, expiresAt time.Time, t time.Time) (ValidationResult, bool) {
+	if !notBefore.IsZero() && t.Before(notBefore) {
+		return ResultNotYetActive, false
+	}
+	if !expiresAt.IsZero() && !t.Before(expiresAt) {
+		return ResultExpired, false
+	}
+	return "", true
+}
+
+func nullTimestamp(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatTimestamp(t), Valid: true}
+}
+
+func parseNullTimestamp(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	return parseTimestamp(s.String)
+}
+
+const selectEntryMetadataSql string = `SELECT id, created_on, updated_on, last_validated_on, validation_count, description, tags, not_before, expires_at, max_uses, use_count, allowed_origins
+	FROM passwords`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntryMetadata(row rowScanner) (EntryMetadata, error) {
+	var m EntryMetadata
+	var createdOn, updatedOn, lastValidatedOn, notBefore, expiresAt sql.NullString
+	var tags, allowedOrigins string
+	var maxUses sql.NullInt64
+	if err := row.Scan(&m.Id, &createdOn, &updatedOn, &lastValidatedOn, &m.ValidationCount, &m.Description, &tags, &notBefore, &expiresAt, &maxUses, &m.UseCount, &allowedOrigins); err != nil {
+		return m, err
+	}
+	m.MaxUses = int(maxUses.Int64)
+
+	var err error
+	if m.CreatedOn, err = parseNullTimestamp(createdOn); err != nil {
+		return m, err
+	}
+	if m.UpdatedOn, err = parseNullTimestamp(updatedOn); err != nil {
+		return m, err
+	}
+	if m.LastValidatedOn, err = parseNullTimestamp(lastValidatedOn); err != nil {
+		return m, err
+	}
+	if m.NotBefore, err = parseNullTimestamp(notBefore); err != nil {
+		return m, err
+	}
+	if m.ExpiresAt, err = parseNullTimestamp(expiresAt); err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal([]byte(tags), &m.Tags); err != nil {
+		return m, fmt.Errorf("invalid tags for %s: %w", m.Id, err)
+	}
+	if m.Tags == nil {
+		m.Tags = []string{}
+	}
+	if err := json.Unmarshal([]byte(allowedOrigins), &m.AllowedOrigins); err != nil {
+		return m, fmt.Errorf("invalid allowed origins for %s: %w", m.Id, err)
+	}
+	if m.AllowedOrigins == nil {
+		m.AllowedOrigins = []string{}
+	}
+	return m, nil
+}
+
+// NormalizeTags trims whitespace from tags & drops empty & duplicate ones,
+// preserving order.
+func NormalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		normalized = append(normalized, t)
+	}
+	return normalized
+}
+
+// NormalizeOrigins checks that each of origins is a scheme & host (with an
+// optional port), lowercasing them & dropping duplicates.
+func NormalizeOrigins(origins []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, o := range origins {
+		o = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(o), "/"))
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidOrigin, o)
+		}
+		if seen[o] {
+			continue
+		}
+		seen[o] = true
+		normalized = append(normalized, o)
+	}
+	return normalized, nil
+}
+
+func (passddb *PassdDb) ListEntryMetadata() ([]EntryMetadata, error) {
+	return listEntryMetadata(passddb.db)
+}
+
+// listEntryMetadata returns the metadata of every entry, ordered by id.
+func listEntryMetadata(q querier) ([]EntryMetadata, error) {
+	rows, err := q.Query(selectEntryMetadataSql + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []EntryMetadata{}
+	for rows.Next() {
+		m, err := scanEntryMetadata(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		entries = append(entries, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	credentials, err := loadCredentials(q, "")
+	if err != nil {
+		return nil, err
+	}
+	payloads, err := loadPayloadInfo(q, "")
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := redirectIds(q, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Credentials = credentialInfo(credentials[entries[i].Id])
+		entries[i].Payload = payloads[entries[i].Id]
+		entries[i].HasRedirect = redirects[entries[i].Id]
+	}
+	return entries, nil
+}
+
+// GetEntryMetadata returns the metadata for the entry with the given id, or
+// nil if no such entry exists.
+func (passddb *PassdDb) GetEntryMetadata(id string) (*EntryMetadata, error) {
+	m, err := scanEntryMetadata(passddb.db.QueryRow(selectEntryMetadataSql+" WHERE id = ?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load entry metadata: %w", err)
+	}
+	credentials, err := loadCredentials(passddb.db, "WHERE entry_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	m.Credentials = credentialInfo(credentials[id])
+	payloads, err := loadPayloadInfo(passddb.db, "WHERE entry_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	m.Payload = payloads[id]
+	redirects, err := redirectIds(passddb.db, "WHERE entry_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	m.HasRedirect = redirects[id]
+	return &m, nil
+}
+
+// UpdateEntryMetadata changes the admin-controlled metadata of the entry
+// with the given id, returning false if no such entry exists.
+func (passddb *PassdDb) UpdateEntryMetadata(id string, update EntryMetadataUpdate) (bool, error) {
+	sets := []string{}
+	args := []any{}
+	if update.Description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *update.Description)
+	}
+	if update.Tags != nil {
+		tagsJson, err := json.Marshal(NormalizeTags(*update.Tags))
+		if err != nil {
+			return false, fmt.Errorf("failed to encode tags: %w", err)
+		}
+		sets = append(sets, "tags = ?")
+		args = append(args, string(tagsJson))
+	}
+	if update.NotBefore != nil {
+		sets = append(sets, "not_before = ?")
+		args = append(args, nullTimestamp(*update.NotBefore))
+	}
+	if update.ExpiresAt != nil {
+		sets = append(sets, "expires_at = ?")
+		args = append(args, nullTimestamp(*update.ExpiresAt))
+	}
+	if update.MaxUses != nil {
+		if *update.MaxUses < 0 {
+			return false, ErrInvalidMaxUses
+		}
+		sets = append(sets, "max_uses = ?")
+		args = append(args, sql.NullInt64{Int64: int64(*update.MaxUses), Valid: *update.MaxUses > 0})
+	}
+	if update.AllowedOrigins != nil {
+		origins, err := NormalizeOrigins(*update.AllowedOrigins)
+		if err != nil {
+			return false, err
+		}
+		originsJson, err := json.Marshal(origins)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode allowed origins: %w", err)
+		}
+		sets = append(sets, "allowed_origins = ?")
+		args = append(args, string(originsJson))
+	}
+
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The window is checked against the bound that isn't being changed, so
+	// both have to be read in the same transaction as the update.
+	var notBefore, expiresAt sql.NullString
+	err = tx.QueryRow("SELECT not_before, expires_at FROM passwords WHERE id = ?", id).Scan(&notBefore, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to load entry metadata: %w", err)
+	}
+	currentNotBefore, err := parseNullTimestamp(notBefore)
+	if err != nil {
+		return false, err
+	}
+	currentExpiresAt, err := parseNullTimestamp(expiresAt)
+	if err != nil {
+		return false, err
+	}
+	if _, _, err := update.window(currentNotBefore, currentExpiresAt); err != nil {
+		return false, err
+	}
+
+	sets = append(sets, "updated_on = CURRENT_TIMESTAMP")
+	args = append(args, id)
+	if _, err := tx.Exec("UPDATE passwords SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+		return false, fmt.Errorf("failed to update entry metadata: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// consumeUse counts a successful validation against an entry, returning
+// false without changing anything if the entry has no uses left. The check &
+// the increment happen in a single statement, so concurrent validations can't
+// consume more uses than the entry allows.
+func (passddb *PassdDb) consumeUse(id string, validatedOn time.Time) (bool, error) {
+	result, err := passddb.db.Exec(
+		`UPDATE passwords SET last_validated_on = ?, validation_count = validation_count + 1, use_count = use_count + 1
+		WHERE id = ? AND (max_uses IS NULL OR use_count < max_uses)`,
+		formatTimestamp(validatedOn), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to update validation stats: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// PurgeExpiredEntries deletes entries that expired before the given time,
+// returning their ids.
+func (passddb *PassdDb) PurgeExpiredEntries(before time.Time) ([]string, error) {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM passwords WHERE expires_at IS NOT NULL AND expires_at < ?", formatTimestamp(before))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	for _, id := range ids {
+		if _, err := deleteEntry(tx, id); err != nil {
+			return nil, fmt.Errorf("failed to delete entry %s: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,336 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/ (migrations/postgres/ for Postgres) & are
+// named <version>_<name>.sql, where version is a positive integer. Once
+// released, a migration must never be edited; add a new one instead.
+//
+//go:embed migrations/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+var (
+	ErrDowngrade      error = errors.New("schema migrations cannot be reverted")
+	ErrUnknownVersion error = errors.New("no such schema version")
+	ErrSchemaTooNew   error = errors.New("database schema is newer than this version of passd supports")
+
+	migrationsLoadErr error
+)
+
+const createSchemaVersionTableSql string = `CREATE TABLE IF NOT EXISTS
+    schema_version
+    ( version INTEGER PRIMARY KEY
+    , name TEXT NOT NULL
+    , applied_on TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`
+
+type Migration struct {
+	Version int
+	Name    string
+	Sql     string
+}
+
+// MigrationStatus describes a known migration & whether it has been applied
+// to a particular database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedOn time.Time
+}
+
+func init() {
+	var sqliteErr, postgresErr error
+	sqliteDialect.migrations, sqliteErr = loadMigrations("migrations")
+	postgresDialect.migrations, postgresErr = loadMigrations("migrations/postgres")
+	migrationsLoadErr = errors.Join(sqliteErr, postgresErr)
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	result := []Migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name: %s", e.Name())
+		}
+		contents, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", e.Name(), err)
+		}
+		result = append(result, Migration{version, name, string(contents)})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	for i, m := range result {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrations must be numbered consecutively from 1; expected %d, found %d (%s)", i+1, m.Version, m.Name)
+		}
+	}
+	return result, nil
+}
+
+// LatestSchemaVersion is the version a SQLite database is at once every
+// known migration has been applied.
+func LatestSchemaVersion() int {
+	return sqliteDialect.latestVersion()
+}
+
+func (d *dialect) latestVersion() int {
+	return len(d.migrations)
+}
+
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func hasSchemaVersionTable(q querier, d *dialect) (bool, error) {
+	var exists bool
+	if err := q.QueryRow(d.hasTableSql, "schema_version").Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check for schema_version table: %w", err)
+	}
+	return exists, nil
+}
+
+// legacyVersion works out which migrations a database without a
+// schema_version table has effectively already had applied. The dialect's
+// legacyProbes detect the schema changes that predate schema_version, in
+// order, so that databases created by older versions of passd can be adopted
+// at the right version rather than having their migrations run again.
+func legacyVersion(q querier, d *dialect) (int, error) {
+	version := 0
+	for _, probe := range d.legacyProbes {
+		var applied bool
+		if err := q.QueryRow(probe).Scan(&applied); err != nil {
+			return 0, fmt.Errorf("failed to inspect legacy schema: %w", err)
+		}
+		if !applied {
+			break
+		}
+		version++
+	}
+	return version, nil
+}
+
+// appliedMigrations returns when each applied migration was applied, keyed by
+// version. For a database that predates schema_version, the migrations it
+// would be adopted at are reported with a zero time.
+func appliedMigrations(q querier, d *dialect) (map[int]time.Time, error) {
+	exists, err := hasSchemaVersionTable(q, d)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	if !exists {
+		version, err := legacyVersion(q, d)
+		if err != nil {
+			return nil, err
+		}
+		for v := 1; v <= version; v++ {
+			applied[v] = time.Time{}
+		}
+		return applied, nil
+	}
+
+	rows, err := q.Query("SELECT version, applied_on FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedOnStr string
+		if err := rows.Scan(&version, &appliedOnStr); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		appliedOn, err := parseTimestamp(appliedOnStr)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedOn
+	}
+	return applied, rows.Err()
+}
+
+func currentVersion(applied map[int]time.Time) int {
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version
+}
+
+// migrate brings db forward to target (or the latest version if target is
+// zero) in a single transaction, returning the versions it migrated from &
+// to. Databases that predate schema_version are adopted first.
+func migrate(db *sqlConn, target int) (int, int, error) {
+	if migrationsLoadErr != nil {
+		return 0, 0, migrationsLoadErr
+	}
+	d := db.dialect
+	if target == 0 {
+		target = d.latestVersion()
+	}
+	if target < 0 || target > d.latestVersion() {
+		return 0, 0, fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if d.lockMigrationsSql != "" {
+		if _, err := tx.Exec(d.lockMigrationsSql); err != nil {
+			return 0, 0, fmt.Errorf("failed to lock schema: %w", err)
+		}
+	}
+	exists, err := hasSchemaVersionTable(tx, d)
+	if err != nil {
+		return 0, 0, err
+	}
+	applied, err := appliedMigrations(tx, d)
+	if err != nil {
+		return 0, 0, err
+	}
+	from := currentVersion(applied)
+	if from > d.latestVersion() {
+		return 0, 0, fmt.Errorf("%w (found %d, latest %d)", ErrSchemaTooNew, from, d.latestVersion())
+	}
+	if target < from {
+		return 0, 0, fmt.Errorf("%w (at %d, requested %d)", ErrDowngrade, from, target)
+	}
+
+	if _, err := tx.Exec(createSchemaVersionTableSql); err != nil {
+		return 0, 0, fmt.Errorf("failed to setup schema_version table: %w", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO schema_version (version, name) VALUES (?, ?)")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to prepare query: %w", err)
+	}
+	defer stmt.Close()
+
+	if !exists {
+		for _, m := range d.migrations[:from] {
+			if _, err := stmt.Exec(m.Version, m.Name); err != nil {
+				return 0, 0, fmt.Errorf("failed to record adopted migration %d: %w", m.Version, err)
+			}
+		}
+	}
+
+	for _, m := range d.migrations[from:target] {
+		if _, err := tx.Exec(m.Sql); err != nil {
+			return 0, 0, fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := stmt.Exec(m.Version, m.Name); err != nil {
+			return 0, 0, fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return from, target, nil
+}
+
+// openConn opens the database at dsn, a file path for SQLite or a URL for
+// Postgres.
+func openConn(d *dialect, dsn string) (*sqlConn, error) {
+	db, err := sql.Open(d.driver, d.dsn(dsn))
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return nil, fmt.Errorf("DB reference still nil despite no error")
+	}
+	return &sqlConn{db, d}, nil
+}
+
+// Migrate applies pending migrations to the database at dbPath up to & including
+// target, or all of them if target is zero. Returns the versions it migrated
+// from & to. Migrations only go forward; ErrDowngrade is returned if target
+// is older than the current version.
+func Migrate(dbPath string, target int) (int, int, error) {
+	return migrateDsn(sqliteDialect, dbPath, target)
+}
+
+// MigratePostgres is Migrate for the Postgres database at url.
+func MigratePostgres(url string, target int) (int, int, error) {
+	return migrateDsn(postgresDialect, url, target)
+}
+
+func migrateDsn(d *dialect, dsn string, target int) (int, int, error) {
+	db, err := openConn(d, dsn)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	return migrate(db, target)
+}
+
+// SchemaStatus reports every known migration & whether it has been applied
+// to the database at dbPath, without modifying it.
+func SchemaStatus(dbPath string) ([]MigrationStatus, error) {
+	if migrationsLoadErr != nil {
+		return nil, migrationsLoadErr
+	}
+	// Don't let checking the status of a mistyped path create an empty DB
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open DB: %w", err)
+	}
+	return schemaStatus(sqliteDialect, dbPath)
+}
+
+// PostgresSchemaStatus is SchemaStatus for the Postgres database at url.
+func PostgresSchemaStatus(url string) ([]MigrationStatus, error) {
+	if migrationsLoadErr != nil {
+		return nil, migrationsLoadErr
+	}
+	return schemaStatus(postgresDialect, url)
+}
+
+func schemaStatus(d *dialect, dsn string) ([]MigrationStatus, error) {
+	db, err := openConn(d, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	applied, err := appliedMigrations(db, d)
+	if err != nil {
+		return nil, err
+	}
+	if version := currentVersion(applied); version > d.latestVersion() {
+		return nil, fmt.Errorf("%w (found %d, latest %d)", ErrSchemaTooNew, version, d.latestVersion())
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range d.migrations {
+		appliedOn, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{m, ok, appliedOn})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,3 @@
+ALTER TABLE
+    passwords
+    ADD COLUMN storage_mode TEXT NOT NULL DEFAULT 'encrypted';
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS
+    validation_throttles
+    ( key TEXT PRIMARY KEY
+    , failures INTEGER NOT NULL DEFAULT 0
+    , last_failure_on TEXT NOT NULL
+    , locked_until TEXT
+    );
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS
+    validation_attempts
+    ( attempt_id INTEGER PRIMARY KEY AUTOINCREMENT
+    , entry_id TEXT NOT NULL
+    , attempted_on TEXT NOT NULL
+    , result TEXT NOT NULL
+    , client_ip TEXT NOT NULL DEFAULT ''
+    , user_agent TEXT NOT NULL DEFAULT ''
+    , request_id TEXT NOT NULL DEFAULT ''
+    );
+
+CREATE INDEX IF NOT EXISTS
+    validation_attempts_entry_id_attempted_on
+    ON validation_attempts (entry_id, attempted_on);
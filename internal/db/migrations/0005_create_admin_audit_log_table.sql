@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS
+    admin_audit_log
+    ( audit_id INTEGER PRIMARY KEY AUTOINCREMENT
+    , occurred_on TEXT NOT NULL
+    , action TEXT NOT NULL
+    , entry_id TEXT NOT NULL
+    , subject TEXT NOT NULL DEFAULT ''
+    , username TEXT NOT NULL DEFAULT ''
+    , client_ip TEXT NOT NULL DEFAULT ''
+    , request_id TEXT NOT NULL DEFAULT ''
+    );
+
+CREATE INDEX IF NOT EXISTS
+    admin_audit_log_entry_id
+    ON admin_audit_log (entry_id);
+
+-- The audit log is append-only
+CREATE TRIGGER IF NOT EXISTS
+    admin_audit_log_no_update
+    BEFORE UPDATE ON admin_audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'admin_audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS
+    admin_audit_log_no_delete
+    BEFORE DELETE ON admin_audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'admin_audit_log is append-only');
+END;
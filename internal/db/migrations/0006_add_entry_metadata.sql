@@ -0,0 +1,22 @@
+ALTER TABLE
+    passwords
+    ADD COLUMN description TEXT NOT NULL DEFAULT '';
+
+-- JSON array of strings
+ALTER TABLE
+    passwords
+    ADD COLUMN tags TEXT NOT NULL DEFAULT '[]';
+
+ALTER TABLE
+    passwords
+    ADD COLUMN last_validated_on TEXT;
+
+ALTER TABLE
+    passwords
+    ADD COLUMN validation_count INTEGER NOT NULL DEFAULT 0;
+
+-- Rows created before updated_on was maintained may have lost track of it
+UPDATE
+    passwords
+    SET updated_on = COALESCE(updated_on, created_on, CURRENT_TIMESTAMP)
+    , created_on = COALESCE(created_on, CURRENT_TIMESTAMP);
@@ -0,0 +1,12 @@
+-- Entries only validate between not_before & expires_at; NULL means unbounded
+ALTER TABLE
+    passwords
+    ADD COLUMN not_before TEXT;
+
+ALTER TABLE
+    passwords
+    ADD COLUMN expires_at TEXT;
+
+CREATE INDEX IF NOT EXISTS
+    passwords_expires_at
+    ON passwords (expires_at);
@@ -0,0 +1,8 @@
+-- An entry stops validating once use_count reaches max_uses; NULL means unlimited
+ALTER TABLE
+    passwords
+    ADD COLUMN max_uses INTEGER;
+
+ALTER TABLE
+    passwords
+    ADD COLUMN use_count INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,35 @@
+-- An entry can accept several named credentials. Rows are deleted along with
+-- their entry by PassdDb, since foreign keys aren't enforced.
+CREATE TABLE
+    credentials
+    ( entry_id TEXT NOT NULL
+    , name TEXT NOT NULL
+    , password_enc BLOB NOT NULL
+    , storage_mode TEXT NOT NULL DEFAULT 'encrypted'
+    , created_on TEXT DEFAULT CURRENT_TIMESTAMP
+    , not_before TEXT
+    , expires_at TEXT
+    , PRIMARY KEY (entry_id, name)
+    );
+
+-- Each existing password becomes its entry's default credential
+INSERT INTO
+    credentials (entry_id, name, password_enc, storage_mode, created_on)
+    SELECT id, 'default', password_enc, storage_mode, COALESCE(updated_on, created_on, CURRENT_TIMESTAMP)
+    FROM passwords;
+
+ALTER TABLE
+    passwords
+    DROP COLUMN password_enc;
+
+ALTER TABLE
+    passwords
+    DROP COLUMN storage_mode;
+
+ALTER TABLE
+    validation_attempts
+    ADD COLUMN credential TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE
+    admin_audit_log
+    ADD COLUMN credential TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- Keys used to sign session tokens. Private keys are sealed with the keyring.
+CREATE TABLE
+    signing_keys
+    ( key_id TEXT PRIMARY KEY
+    , algorithm TEXT NOT NULL
+    , private_key_enc BLOB NOT NULL
+    , created_on TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+    , retired_on TEXT
+    );
@@ -0,0 +1,15 @@
+-- Maps requests checked by forward auth to the entry protecting them. An
+-- empty host matches any host. Rows are deleted along with their entry by
+-- PassdDb, since foreign keys aren't enforced.
+CREATE TABLE
+    routes
+    ( host TEXT NOT NULL
+    , path_prefix TEXT NOT NULL
+    , entry_id TEXT NOT NULL
+    , created_on TEXT DEFAULT CURRENT_TIMESTAMP
+    , PRIMARY KEY (host, path_prefix)
+    );
+
+CREATE INDEX
+    idx_routes_entry_id
+    ON routes (entry_id);
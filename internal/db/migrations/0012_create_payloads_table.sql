@@ -0,0 +1,12 @@
+-- Content released to visitors who know an entry's password, sealed with the
+-- keyring. Rows are deleted along with their entry by PassdDb, since foreign
+-- keys aren't enforced.
+CREATE TABLE
+    payloads
+    ( entry_id TEXT PRIMARY KEY
+    , content_type TEXT NOT NULL
+    , filename TEXT NOT NULL DEFAULT ''
+    , size INTEGER NOT NULL
+    , payload_enc BLOB NOT NULL
+    , updated_on TEXT DEFAULT CURRENT_TIMESTAMP
+    );
@@ -0,0 +1,10 @@
+-- Where an entry's /p/:id link sends visitors who know its password, sealed
+-- with the keyring so that the destination isn't discoverable. Rows are
+-- deleted along with their entry by PassdDb, since foreign keys aren't
+-- enforced.
+CREATE TABLE
+    redirects
+    ( entry_id TEXT PRIMARY KEY
+    , target_enc BLOB NOT NULL
+    , updated_on TEXT DEFAULT CURRENT_TIMESTAMP
+    );
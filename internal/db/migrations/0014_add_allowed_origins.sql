@@ -0,0 +1,5 @@
+-- JSON array of origins (e.g. "https://example.com") whose pages may validate
+-- the entry. Empty means any origin may.
+ALTER TABLE
+    passwords
+    ADD COLUMN allowed_origins TEXT NOT NULL DEFAULT '[]';
@@ -0,0 +1,13 @@
+-- Upgrades of stored data that need the key, which migrations don't have.
+-- passd performs each one the next time it opens the database & then deletes
+-- its row.
+CREATE TABLE
+    pending_upgrades
+    ( name TEXT PRIMARY KEY
+    );
+
+-- Ciphertexts written before they were bound to their rows have to be
+-- re-sealed.
+INSERT INTO
+    pending_upgrades (name)
+    VALUES ('bind_ciphertexts');
@@ -0,0 +1,5 @@
+-- Random value replaced whenever a credential's password is, so that sessions
+-- issued for the old password can be told apart even within the same second.
+ALTER TABLE
+    credentials
+    ADD COLUMN revision TEXT NOT NULL DEFAULT '';
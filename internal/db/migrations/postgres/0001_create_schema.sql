@@ -0,0 +1,138 @@
+-- The schema as of SQLite migration 0014. Timestamps are stored as TEXT in the
+-- same format as SQLite's CURRENT_TIMESTAMP (UTC), so that both databases are
+-- read & compared the same way. Rows belonging to an entry are deleted along
+-- with it by PassdDb, as they are in SQLite.
+CREATE TABLE
+    passwords
+    ( id TEXT PRIMARY KEY
+    , created_on TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+    , updated_on TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+    , description TEXT NOT NULL DEFAULT ''
+    -- JSON array of strings
+    , tags TEXT NOT NULL DEFAULT '[]'
+    , last_validated_on TEXT
+    , validation_count INTEGER NOT NULL DEFAULT 0
+    -- Entries only validate between not_before & expires_at; NULL means unbounded
+    , not_before TEXT
+    , expires_at TEXT
+    -- An entry stops validating once use_count reaches max_uses; NULL means unlimited
+    , max_uses INTEGER
+    , use_count INTEGER NOT NULL DEFAULT 0
+    -- JSON array of origins whose pages may validate the entry; empty means any
+    , allowed_origins TEXT NOT NULL DEFAULT '[]'
+    );
+
+CREATE INDEX
+    passwords_expires_at
+    ON passwords (expires_at);
+
+CREATE TABLE
+    credentials
+    ( entry_id TEXT NOT NULL
+    , name TEXT NOT NULL
+    , password_enc BYTEA NOT NULL
+    , storage_mode TEXT NOT NULL DEFAULT 'encrypted'
+    , created_on TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+    , not_before TEXT
+    , expires_at TEXT
+    , PRIMARY KEY (entry_id, name)
+    );
+
+CREATE TABLE
+    validation_throttles
+    ( key TEXT PRIMARY KEY
+    , failures INTEGER NOT NULL DEFAULT 0
+    , last_failure_on TEXT NOT NULL
+    , locked_until TEXT
+    );
+
+CREATE TABLE
+    validation_attempts
+    ( attempt_id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY
+    , entry_id TEXT NOT NULL
+    , attempted_on TEXT NOT NULL
+    , result TEXT NOT NULL
+    , client_ip TEXT NOT NULL DEFAULT ''
+    , user_agent TEXT NOT NULL DEFAULT ''
+    , request_id TEXT NOT NULL DEFAULT ''
+    , credential TEXT NOT NULL DEFAULT ''
+    );
+
+CREATE INDEX
+    validation_attempts_entry_id_attempted_on
+    ON validation_attempts (entry_id, attempted_on);
+
+CREATE TABLE
+    admin_audit_log
+    ( audit_id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY
+    , occurred_on TEXT NOT NULL
+    , action TEXT NOT NULL
+    , entry_id TEXT NOT NULL
+    , subject TEXT NOT NULL DEFAULT ''
+    , username TEXT NOT NULL DEFAULT ''
+    , client_ip TEXT NOT NULL DEFAULT ''
+    , request_id TEXT NOT NULL DEFAULT ''
+    , credential TEXT NOT NULL DEFAULT ''
+    );
+
+CREATE INDEX
+    admin_audit_log_entry_id
+    ON admin_audit_log (entry_id);
+
+-- The audit log is append-only
+CREATE FUNCTION
+    admin_audit_log_append_only()
+    RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RAISE EXCEPTION 'admin_audit_log is append-only';
+END;
+$$;
+
+CREATE TRIGGER
+    admin_audit_log_append_only
+    BEFORE UPDATE OR DELETE ON admin_audit_log
+    FOR EACH ROW EXECUTE FUNCTION admin_audit_log_append_only();
+
+-- seq orders keys created in the same second, like SQLite's rowid
+CREATE TABLE
+    signing_keys
+    ( key_id TEXT PRIMARY KEY
+    , seq BIGINT GENERATED ALWAYS AS IDENTITY
+    , algorithm TEXT NOT NULL
+    , private_key_enc BYTEA NOT NULL
+    , created_on TEXT NOT NULL DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+    , retired_on TEXT
+    );
+
+-- An empty host matches any host
+CREATE TABLE
+    routes
+    ( host TEXT NOT NULL
+    , path_prefix TEXT NOT NULL
+    , entry_id TEXT NOT NULL
+    , created_on TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+    , PRIMARY KEY (host, path_prefix)
+    );
+
+CREATE INDEX
+    idx_routes_entry_id
+    ON routes (entry_id);
+
+CREATE TABLE
+    payloads
+    ( entry_id TEXT PRIMARY KEY
+    , content_type TEXT NOT NULL
+    , filename TEXT NOT NULL DEFAULT ''
+    , size INTEGER NOT NULL
+    , payload_enc BYTEA NOT NULL
+    , updated_on TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+    );
+
+CREATE TABLE
+    redirects
+    ( entry_id TEXT PRIMARY KEY
+    , target_enc BYTEA NOT NULL
+    , updated_on TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+    );
@@ -0,0 +1,8 @@
+-- Upgrades of stored data that need the key, which migrations don't have.
+-- passd performs each one the next time it opens the database & then deletes
+-- its row. Postgres databases have only ever held bound ciphertexts, so none
+-- are pending yet.
+CREATE TABLE
+    pending_upgrades
+    ( name TEXT PRIMARY KEY
+    );
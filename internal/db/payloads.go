@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Payload is content attached to an entry that is only released to visitors
+// who know its password, e.g. the contact details on an invite page.
+type Payload struct {
+	ContentType string
+	// Filename is suggested to clients that save the payload; it may be
+	// empty.
+	Filename  string
+	Content   []byte
+	UpdatedOn time.Time
+}
+
+// PayloadInfo describes an entry's payload without decrypting it.
+type PayloadInfo struct {
+	ContentType string
+	Filename    string
+	Size        int
+	UpdatedOn   time.Time
+}
+
+func payloadAD(id string) []byte {
+	return associatedData("payloads.payload_enc", id)
+}
+
+// loadPayloadInfo describes the payloads of the entries matching where, keyed
+// by entry id.
+func loadPayloadInfo(q querier, where string, args ...any) (map[string]*PayloadInfo, error) {
+	rows, err := q.Query("SELECT entry_id, content_type, filename, size, updated_on FROM payloads "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	payloads := map[string]*PayloadInfo{}
+	for rows.Next() {
+		var entryId string
+		var p PayloadInfo
+		var updatedOn sql.NullString
+		if err := rows.Scan(&entryId, &p.ContentType, &p.Filename, &p.Size, &updatedOn); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if p.UpdatedOn, err = parseNullTimestamp(updatedOn); err != nil {
+			return nil, err
+		}
+		payloads[entryId] = &p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return payloads, nil
+}
+
+// SetPayload attaches payload to the entry with the given id, replacing any
+// existing one & returning true if there wasn't. ErrEntryNotFound is returned
+// if no such entry exists.
+func (passddb *PassdDb) SetPayload(id string, payload Payload) (bool, error) {
+	ciphertext, err := passddb.key.Encrypt(payload.Content, payloadAD(id))
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	exists, err := entryExists(tx, id)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, ErrEntryNotFound
+	}
+	var payloadExists bool
+	if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM payloads WHERE entry_id = ?", id).Scan(&payloadExists); err != nil {
+		return false, fmt.Errorf("failed to check for existing payload: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO payloads (entry_id, content_type, filename, size, payload_enc) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(entry_id) DO UPDATE SET
+			content_type = excluded.content_type,
+			filename = excluded.filename,
+			size = excluded.size,
+			payload_enc = excluded.payload_enc,
+			updated_on = CURRENT_TIMESTAMP`,
+		id, payload.ContentType, payload.Filename, len(payload.Content), ciphertext); err != nil {
+		return false, fmt.Errorf("failed to set payload: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE passwords SET updated_on = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to update entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return !payloadExists, nil
+}
+
+// GetPayload returns the decrypted payload of the entry with the given id, or
+// nil if it has none.
+func (passddb *PassdDb) GetPayload(id string) (*Payload, error) {
+	var p Payload
+	var ciphertext []byte
+	var updatedOn sql.NullString
+	err := passddb.db.QueryRow("SELECT content_type, filename, payload_enc, updated_on FROM payloads WHERE entry_id = ?", id).
+		Scan(&p.ContentType, &p.Filename, &ciphertext, &updatedOn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load payload: %w", err)
+	}
+	if p.UpdatedOn, err = parseNullTimestamp(updatedOn); err != nil {
+		return nil, err
+	}
+	if p.Content, err = passddb.key.Decrypt(ciphertext, payloadAD(id)); err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	return &p, nil
+}
+
+// RemovePayload detaches the payload from the entry with the given id,
+// returning false if it had none.
+func (passddb *PassdDb) RemovePayload(id string) (bool, error) {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM payloads WHERE entry_id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete payload: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec("UPDATE passwords SET updated_on = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to update entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
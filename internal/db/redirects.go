@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var ErrInvalidRedirect error = errors.New("redirect target must be an absolute http or https URL")
+
+func redirectAD(id string) []byte {
+	return associatedData("redirects.target_enc", id)
+}
+
+// ValidateRedirectTarget checks that target is somewhere a browser can be sent.
+func ValidateRedirectTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidRedirect
+	}
+	return nil
+}
+
+// SetRedirect sets the URL that the entry with the given id redirects to once
+// its password is entered, returning true if it didn't have one.
+// ErrEntryNotFound is returned if no such entry exists.
+func (passddb *PassdDb) SetRedirect(id string, target string) (bool, error) {
+	if err := ValidateRedirectTarget(target); err != nil {
+		return false, err
+	}
+	ciphertext, err := passddb.key.Encrypt([]byte(target), redirectAD(id))
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt redirect target: %w", err)
+	}
+
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	exists, err := entryExists(tx, id)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, ErrEntryNotFound
+	}
+	var redirectExists bool
+	if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM redirects WHERE entry_id = ?", id).Scan(&redirectExists); err != nil {
+		return false, fmt.Errorf("failed to check for existing redirect: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO redirects (entry_id, target_enc) VALUES (?, ?)
+		ON CONFLICT(entry_id) DO UPDATE SET target_enc = excluded.target_enc, updated_on = CURRENT_TIMESTAMP`,
+		id, ciphertext); err != nil {
+		return false, fmt.Errorf("failed to set redirect: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE passwords SET updated_on = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to update entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return !redirectExists, nil
+}
+
+// GetRedirect returns the URL that the entry with the given id redirects to,
+// or "" if it has none.
+func (passddb *PassdDb) GetRedirect(id string) (string, error) {
+	var ciphertext []byte
+	err := passddb.db.QueryRow("SELECT target_enc FROM redirects WHERE entry_id = ?", id).Scan(&ciphertext)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load redirect: %w", err)
+	}
+	target, err := passddb.key.Decrypt(ciphertext, redirectAD(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt redirect target: %w", err)
+	}
+	return string(target), nil
+}
+
+// RemoveRedirect removes the redirect of the entry with the given id,
+// returning false if it had none.
+func (passddb *PassdDb) RemoveRedirect(id string) (bool, error) {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM redirects WHERE entry_id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete redirect: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec("UPDATE passwords SET updated_on = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to update entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// redirectIds returns the ids of the entries matching where that have a
+// redirect.
+func redirectIds(q querier, where string, args ...any) (map[string]bool, error) {
+	rows, err := q.Query("SELECT entry_id FROM redirects "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		ids[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Route maps requests for a host & path to the entry that protects them, for
+// forward auth. An empty Host matches any host.
+type Route struct {
+	Host       string
+	PathPrefix string
+	EntryId    string
+	CreatedOn  time.Time
+}
+
+var (
+	ErrInvalidRoute error = errors.New("path_prefix must start with /")
+	ErrInvalidPath  error = errors.New("request path is not a valid absolute path")
+)
+
+// NormalizeHost lowercases host & strips any port, so that it can be compared
+// against the hosts of routes.
+func NormalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// NormalizePath returns the path that a proxy serves for a request to the
+// raw (possibly percent-encoded) URI, which is what has to be matched against
+// routes: anything after ? or # is dropped, it is decoded & dot segments are
+// resolved, so that /public/../secret & /%73ecret both become /secret. A
+// trailing slash is kept. ErrInvalidPath is returned for paths that can't be
+// matched safely, such as ones with encoded separators.
+func NormalizePath(uri string) (string, error) {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+	lower := strings.ToLower(uri)
+	if strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c") {
+		return "", ErrInvalidPath
+	}
+	decoded, err := url.PathUnescape(uri)
+	if err != nil || !strings.HasPrefix(decoded, "/") || strings.ContainsAny(decoded, "\\\x00") {
+		return "", ErrInvalidPath
+	}
+	cleaned := path.Clean(decoded)
+	if slices.Contains(strings.Split(cleaned, "/"), "..") {
+		return "", ErrInvalidPath
+	}
+	if strings.HasSuffix(decoded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// matchesPrefix reports whether path falls under prefix, only matching whole
+// path segments, so that /party protects /party/photos but not /party-photos.
+func matchesPrefix(path string, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func scanRoute(row rowScanner) (Route, error) {
+	var r Route
+	var createdOn string
+	if err := row.Scan(&r.Host, &r.PathPrefix, &r.EntryId, &createdOn); err != nil {
+		return r, err
+	}
+	var err error
+	if r.CreatedOn, err = parseTimestamp(createdOn); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func queryRoutes(q querier, where string, args ...any) ([]Route, error) {
+	rows, err := q.Query("SELECT host, path_prefix, entry_id, created_on FROM routes "+where+" ORDER BY host, path_prefix", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	routes := []Route{}
+	for rows.Next() {
+		r, err := scanRoute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		routes = append(routes, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return routes, nil
+}
+
+// ListRoutes returns every route, ordered by host & path prefix.
+func (passddb *PassdDb) ListRoutes() ([]Route, error) {
+	return queryRoutes(passddb.db, "")
+}
+
+// ResolveRoute returns the route for a request to host & path, or nil if
+// there is none. Routes for the request's host take precedence over ones for
+// any host, & then the longest matching prefix wins. ErrInvalidPath is
+// returned if path can't be normalized.
+func (passddb *PassdDb) ResolveRoute(host string, path string) (*Route, error) {
+	routes, err := queryRoutes(passddb.db, "WHERE host IN (?, '')", NormalizeHost(host))
+	if err != nil {
+		return nil, err
+	}
+	return bestRoute(routes, path)
+}
+
+// bestRoute picks the route for a request to uri out of the routes for its
+// host & for any host, or returns nil if none match. uri is normalized with
+// NormalizePath first.
+func bestRoute(routes []Route, uri string) (*Route, error) {
+	path, err := NormalizePath(uri)
+	if err != nil {
+		return nil, err
+	}
+	var best *Route
+	for i, r := range routes {
+		if !matchesPrefix(path, r.PathPrefix) {
+			continue
+		}
+		if best == nil ||
+			(r.Host != "" && best.Host == "") ||
+			(r.Host == best.Host && len(r.PathPrefix) > len(best.PathPrefix)) {
+			best = &routes[i]
+		}
+	}
+	return best, nil
+}
+
+// SetRoute creates or replaces the route for r's host & path prefix,
+// returning true if it was newly created. ErrEntryNotFound is returned if r's
+// entry doesn't exist.
+func (passddb *PassdDb) SetRoute(r Route) (bool, error) {
+	if !strings.HasPrefix(r.PathPrefix, "/") {
+		return false, ErrInvalidRoute
+	}
+	r.Host = NormalizeHost(r.Host)
+
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	exists, err := entryExists(tx, r.EntryId)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, ErrEntryNotFound
+	}
+	var routeExists bool
+	if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM routes WHERE host = ? AND path_prefix = ?", r.Host, r.PathPrefix).Scan(&routeExists); err != nil {
+		return false, fmt.Errorf("failed to check for existing route: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO routes (host, path_prefix, entry_id) VALUES (?, ?, ?)
+		ON CONFLICT(host, path_prefix) DO UPDATE SET entry_id = excluded.entry_id, created_on = CURRENT_TIMESTAMP`,
+		r.Host, r.PathPrefix, r.EntryId); err != nil {
+		return false, fmt.Errorf("failed to set route: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return !routeExists, nil
+}
+
+// RemoveRoute deletes the route for host & pathPrefix, returning the entry it
+// pointed to, or "" if there was no such route.
+func (passddb *PassdDb) RemoveRoute(host string, pathPrefix string) (string, error) {
+	var entryId string
+	err := passddb.db.QueryRow("DELETE FROM routes WHERE host = ? AND path_prefix = ? RETURNING entry_id", NormalizeHost(host), pathPrefix).Scan(&entryId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to delete route: %w", err)
+	}
+	return entryId, nil
+}
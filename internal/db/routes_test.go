@@ -0,0 +1,119 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+// Routes are matched against the path the proxy will actually serve, so
+// every way of spelling a path has to normalize to the same thing.
+func TestNormalizePath(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"/":                     "/",
+		"/secret":               "/secret",
+		"/secret/":              "/secret/",
+		"/secret?x=/public":     "/secret",
+		"/secret#/public":       "/secret",
+		"/public/../secret":     "/secret",
+		"/public/%2e%2e/secret": "/secret",
+		"/%73ecret":             "/secret",
+		"//secret":              "/secret",
+		"/./secret/./x/":        "/secret/x/",
+		"/..":                   "/",
+		"/../secret":            "/secret",
+		"/a%20b":                "/a b",
+	} {
+		actual, err := NormalizePath(uri)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", uri, err)
+		} else if actual != expected {
+			t.Errorf("%s: expected %s, got %s", uri, expected, actual)
+		}
+	}
+
+	for _, uri := range []string{
+		"",
+		"secret",
+		"/secret%2fx",
+		"/secret%2Fx",
+		"/secret%5cx",
+		"/secret\\x",
+		"/secret%00",
+		"/%zz",
+		"%2fsecret",
+	} {
+		if _, err := NormalizePath(uri); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("%q: expected ErrInvalidPath, got %v", uri, err)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for host, expected := range map[string]string{
+		"Party.Example.com":      "party.example.com",
+		"party.example.com:8443": "party.example.com",
+		"party.example.com.":     "party.example.com",
+		" party.example.com ":    "party.example.com",
+		"[::1]:8080":             "::1",
+		"":                       "",
+	} {
+		if actual := NormalizeHost(host); actual != expected {
+			t.Errorf("%q: expected %q, got %q", host, expected, actual)
+		}
+	}
+}
+
+// Prefixes only match whole path segments.
+func TestMatchesPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		path     string
+		prefix   string
+		expected bool
+	}{
+		{"/party", "/party", true},
+		{"/party/photos", "/party", true},
+		{"/party-photos", "/party", false},
+		{"/party/", "/party/", true},
+		{"/party", "/party/", false},
+		{"/anything", "/", true},
+		{"/part", "/party", false},
+	} {
+		if actual := matchesPrefix(tt.path, tt.prefix); actual != tt.expected {
+			t.Errorf("%s under %s: expected %t, got %t", tt.path, tt.prefix, tt.expected, actual)
+		}
+	}
+}
+
+// Routes for the request's host beat ones for any host, & then the longest
+// prefix wins.
+func TestBestRoute(t *testing.T) {
+	routes := []Route{
+		{Host: "", PathPrefix: "/", EntryId: "any-root"},
+		{Host: "", PathPrefix: "/a/b", EntryId: "any-deep"},
+		{Host: "party.example.com", PathPrefix: "/a", EntryId: "host-shallow"},
+	}
+	for uri, expected := range map[string]string{
+		"/":       "any-root",
+		"/x":      "any-root",
+		"/a":      "host-shallow",
+		"/a/b/c":  "host-shallow",
+		"/a/../x": "any-root",
+		"/ab":     "any-root",
+	} {
+		route, err := bestRoute(routes, uri)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", uri, err)
+		}
+		if route == nil || route.EntryId != expected {
+			t.Errorf("%s: expected %s, got %+v", uri, expected, route)
+		}
+	}
+
+	route, err := bestRoute(routes[1:2], "/x")
+	if err != nil || route != nil {
+		t.Fatalf("expected no route, got %+v, %v", route, err)
+	}
+	if _, err := bestRoute(routes, "/a%2fb"); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("expected ErrInvalidPath, got %v", err)
+	}
+}
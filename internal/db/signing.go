@@ -0,0 +1,96 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SigningKey is a key used to sign session tokens. Only the newest key
+// (the one that hasn't been retired) signs new tokens; retired keys are kept
+// so that tokens they signed can still be verified until they expire.
+type SigningKey struct {
+	Id         string
+	Algorithm  string
+	PrivateKey []byte
+	CreatedOn  time.Time
+	// RetiredOn is the zero time for the active key.
+	RetiredOn time.Time
+}
+
+func signingKeyAD(id string) []byte {
+	return associatedData("signing_keys.private_key_enc", id)
+}
+
+// ListSigningKeys returns every signing key, newest first.
+func (passddb *PassdDb) ListSigningKeys() ([]SigningKey, error) {
+	rows, err := passddb.db.Query("SELECT key_id, algorithm, private_key_enc, created_on, retired_on FROM signing_keys ORDER BY " + passddb.db.dialect.signingKeysOrder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var k SigningKey
+		var ciphertext []byte
+		var createdOn string
+		var retiredOn sql.NullString
+		if err := rows.Scan(&k.Id, &k.Algorithm, &ciphertext, &createdOn, &retiredOn); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if k.CreatedOn, err = parseTimestamp(createdOn); err != nil {
+			return nil, err
+		}
+		if k.RetiredOn, err = parseNullTimestamp(retiredOn); err != nil {
+			return nil, err
+		}
+		if k.PrivateKey, err = passddb.key.Decrypt(ciphertext, signingKeyAD(k.Id)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt signing key %s: %w", k.Id, err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return keys, nil
+}
+
+// RotateSigningKey stores key as the active signing key, retiring the
+// previous one.
+func (passddb *PassdDb) RotateSigningKey(key SigningKey) error {
+	ciphertext, err := passddb.key.Encrypt(key.PrivateKey, signingKeyAD(key.Id))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE signing_keys SET retired_on = ? WHERE retired_on IS NULL", formatTimestamp(key.CreatedOn)); err != nil {
+		return fmt.Errorf("failed to retire signing keys: %w", err)
+	}
+	if _, err := tx.Exec("INSERT INTO signing_keys (key_id, algorithm, private_key_enc, created_on) VALUES (?, ?, ?, ?)",
+		key.Id, key.Algorithm, ciphertext, formatTimestamp(key.CreatedOn)); err != nil {
+		return fmt.Errorf("failed to store signing key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// PruneSigningKeys deletes keys that were retired before the given time,
+// returning the number deleted.
+func (passddb *PassdDb) PruneSigningKeys(retiredBefore time.Time) (int, error) {
+	result, err := passddb.db.Exec("DELETE FROM signing_keys WHERE retired_on IS NOT NULL AND retired_on < ?", formatTimestamp(retiredBefore))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune signing keys: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return int(rowsAffected), nil
+}
@@ -0,0 +1,24 @@
+//go:build cgo
+
+package db
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is mattn/go-sqlite3 when building with cgo.
+const sqliteDriver string = "sqlite3"
+
+// sqliteDsn is just the path, since the cgo driver already waits for locks
+// held by other connections.
+func sqliteDsn(path string) string {
+	return path
+}
+
+func isSqliteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique)
+}
@@ -0,0 +1,27 @@
+//go:build !cgo
+
+package db
+
+import (
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteDriver is the pure Go modernc.org/sqlite when building without cgo
+// (CGO_ENABLED=0), e.g. for static binaries. It reads & writes the same
+// database files as the cgo driver.
+const sqliteDriver string = "sqlite"
+
+// sqliteDsn waits for locks held by other connections, as the cgo driver
+// does by default, rather than failing straight away with SQLITE_BUSY.
+func sqliteDsn(path string) string {
+	return path + "?_pragma=busy_timeout(5000)"
+}
+
+func isSqliteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE)
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"time"
+)
+
+// Store is everything passd keeps: entries along with their credentials,
+// metadata, payloads, redirects & routes, plus the validation attempts, admin
+// audit trail, rate limiting state & session signing keys. PassdDb implements
+// it over SQLite or Postgres & MemoryStore keeps it all in memory.
+type Store interface {
+	Close() error
+
+	ValidatePassword(id string, password string) (ValidationResult, string, error)
+	CheckPassword(id string, password string) (ValidationResult, string, error)
+	CreatePassword(id string, password string, mode StorageMode) error
+	UpsertPassword(id string, password string, mode StorageMode) (bool, error)
+	DeleteEntry(id string) (bool, error)
+	GetPassword(id string) ([]byte, error)
+	ListIds() ([]string, error)
+	// Reencrypt re-seals up to limit values that are sealed with a key older
+	// than the keyring's primary key, or all of them if limit isn't positive.
+	Reencrypt(limit int) (int, error)
+	// CountSealedByKey returns how many stored values are sealed with each
+	// key ID.
+	CountSealedByKey() (map[uint32]int, error)
+	// Backup writes a consistent snapshot to dest, or returns
+	// ErrBackupUnsupported.
+	Backup(dest string) error
+	ExportEntries() ([]ExportedEntry, error)
+	ImportEntries(entries []ExportedEntry, policy ConflictPolicy) (*ImportResult, error)
+
+	ListCredentials(id string) ([]Credential, error)
+	SetCredential(id string, name string, update CredentialUpdate) (bool, error)
+	RemoveCredential(id string, name string) (bool, error)
+	GetCredentialPassword(id string, name string) ([]byte, error)
+
+	ListEntryMetadata() ([]EntryMetadata, error)
+	GetEntryMetadata(id string) (*EntryMetadata, error)
+	UpdateEntryMetadata(id string, update EntryMetadataUpdate) (bool, error)
+	PurgeExpiredEntries(before time.Time) ([]string, error)
+	IsOriginAllowed(origin string) (bool, error)
+	SetAllowedOrigins(id string, origins []string) (bool, error)
+
+	SetPayload(id string, payload Payload) (bool, error)
+	GetPayload(id string) (*Payload, error)
+	RemovePayload(id string) (bool, error)
+
+	SetRedirect(id string, target string) (bool, error)
+	GetRedirect(id string) (string, error)
+	RemoveRedirect(id string) (bool, error)
+
+	ListRoutes() ([]Route, error)
+	ResolveRoute(host string, path string) (*Route, error)
+	SetRoute(r Route) (bool, error)
+	RemoveRoute(host string, pathPrefix string) (string, error)
+
+	RecordValidationAttempt(attempt ValidationAttempt) error
+	ListValidationAttempts(filter AttemptFilter) ([]ValidationAttempt, int, error)
+	RecordAdminAction(record AdminAuditRecord) error
+	ListAdminAuditRecords(filter AuditFilter) ([]AdminAuditRecord, int, error)
+
+	UpdateThrottleState(key string, update func(state ThrottleState) ThrottleState) error
+	DeleteThrottleState(key string) error
+	PruneThrottleStates(before time.Time) (int, error)
+
+	ListSigningKeys() ([]SigningKey, error)
+	RotateSigningKey(key SigningKey) error
+	PruneSigningKeys(retiredBefore time.Time) (int, error)
+}
+
+var (
+	_ Store = (*PassdDb)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,678 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+)
+
+// newStoreFunc opens an empty store sealed with key, closing it once the test
+// is done.
+type newStoreFunc func(t *testing.T, key *crypto.Keyring) Store
+
+func newSqliteStore(t *testing.T, key *crypto.Keyring) Store {
+	store, err := Open(filepath.Join(t.TempDir(), "passd.sqlite"), key)
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newMemoryStore(t *testing.T, key *crypto.Keyring) Store {
+	store, err := NewMemoryStore(key)
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	return store
+}
+
+func TestSqliteStore(t *testing.T) {
+	testStore(t, newSqliteStore)
+}
+
+// The memory store must behave just like the SQL one, so it runs the same
+// suite.
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newMemoryStore)
+}
+
+func newKeyring(t *testing.T) *crypto.Keyring {
+	key, err := crypto.GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	return key
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func expectResult(t *testing.T, store Store, id string, password string, expected ValidationResult, expectedCredential string) {
+	t.Helper()
+	result, credential, err := store.ValidatePassword(id, password)
+	must(t, err)
+	if result != expected || credential != expectedCredential {
+		t.Fatalf("validating %s: expected %s (credential %q), got %s (credential %q)", id, expected, expectedCredential, result, credential)
+	}
+}
+
+func getMetadata(t *testing.T, store Store, id string) EntryMetadata {
+	t.Helper()
+	m, err := store.GetEntryMetadata(id)
+	must(t, err)
+	if m == nil {
+		t.Fatalf("no metadata for %s", id)
+	}
+	return *m
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// testStore runs the behavior every Store has to share against stores from
+// newStore.
+func testStore(t *testing.T, newStore newStoreFunc) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store Store, key *crypto.Keyring)
+	}{
+		{"CreateAndValidate", testCreateAndValidate},
+		{"Upsert", testUpsert},
+		{"HashMode", testHashMode},
+		{"Credentials", testCredentials},
+		{"CredentialFingerprint", testCredentialFingerprint},
+		{"UpdateMetadata", testUpdateMetadata},
+		{"ActivationWindow", testActivationWindow},
+		{"MaxUses", testMaxUses},
+		{"AllowedOrigins", testAllowedOrigins},
+		{"PayloadsAndRedirects", testPayloadsAndRedirects},
+		{"Routes", testRoutes},
+		{"DeleteEntry", testDeleteEntry},
+		{"Attempts", testAttempts},
+		{"Throttles", testThrottles},
+		{"SigningKeys", testSigningKeys},
+		{"Reencrypt", testReencrypt},
+		{"ExportImport", testExportImport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := newKeyring(t)
+			tt.test(t, newStore(t, key), key)
+		})
+	}
+}
+
+func testCreateAndValidate(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	if err := store.CreatePassword("party", "other", StorageModeEncrypted); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict creating an existing entry, got %v", err)
+	}
+
+	expectResult(t, store, "party", "secret", ResultSuccess, DefaultCredentialName)
+	expectResult(t, store, "party", "wrong", ResultIncorrect, "")
+	expectResult(t, store, "missing", "secret", ResultNotFound, "")
+
+	password, err := store.GetPassword("party")
+	must(t, err)
+	if string(password) != "secret" {
+		t.Fatalf("expected the original password, got %q", password)
+	}
+	ids, err := store.ListIds()
+	must(t, err)
+	if !slices.Equal(ids, []string{"party"}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+}
+
+func testUpsert(t *testing.T, store Store, _ *crypto.Keyring) {
+	created, err := store.UpsertPassword("party", "first", StorageModeEncrypted)
+	must(t, err)
+	if !created {
+		t.Fatalf("expected upsert of a new id to create it")
+	}
+	_, err = store.UpdateEntryMetadata("party", EntryMetadataUpdate{MaxUses: ptr(2)})
+	must(t, err)
+	expectResult(t, store, "party", "first", ResultSuccess, DefaultCredentialName)
+
+	created, err = store.UpsertPassword("party", "second", StorageModeEncrypted)
+	must(t, err)
+	if created {
+		t.Fatalf("expected upsert of an existing id to replace it")
+	}
+	expectResult(t, store, "party", "first", ResultIncorrect, "")
+	expectResult(t, store, "party", "second", ResultSuccess, DefaultCredentialName)
+
+	m := getMetadata(t, store, "party")
+	if m.UseCount != 1 || m.MaxUses != 2 {
+		t.Fatalf("expected replacing the password to reset the use count only, got %d/%d", m.UseCount, m.MaxUses)
+	}
+}
+
+func testHashMode(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeHash))
+	expectResult(t, store, "party", "secret", ResultSuccess, DefaultCredentialName)
+	expectResult(t, store, "party", "wrong", ResultIncorrect, "")
+	if _, err := store.GetPassword("party"); !errors.Is(err, ErrNotRetrievable) {
+		t.Fatalf("expected ErrNotRetrievable, got %v", err)
+	}
+}
+
+func testCredentials(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "default", StorageModeEncrypted))
+	created, err := store.SetCredential("party", "guests", CredentialUpdate{Password: "guests", StorageMode: StorageModeHash})
+	must(t, err)
+	if !created {
+		t.Fatalf("expected a new credential to be created")
+	}
+	_, err = store.SetCredential("party", "old", CredentialUpdate{Password: "old", ExpiresAt: time.Now().Add(-time.Hour)})
+	must(t, err)
+	if _, err := store.SetCredential("missing", "guests", CredentialUpdate{Password: "x"}); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("expected ErrEntryNotFound, got %v", err)
+	}
+
+	expectResult(t, store, "party", "default", ResultSuccess, DefaultCredentialName)
+	expectResult(t, store, "party", "guests", ResultSuccess, "guests")
+	expectResult(t, store, "party", "old", ResultExpired, "old")
+
+	credentials, err := store.ListCredentials("party")
+	must(t, err)
+	names := []string{}
+	for _, c := range credentials {
+		names = append(names, c.Name)
+	}
+	if !slices.Equal(names, []string{"default", "guests", "old"}) {
+		t.Fatalf("unexpected credentials: %v", names)
+	}
+
+	password, err := store.GetCredentialPassword("party", "old")
+	must(t, err)
+	if string(password) != "old" {
+		t.Fatalf("expected the credential's password, got %q", password)
+	}
+
+	removed, err := store.RemoveCredential("party", "guests")
+	must(t, err)
+	if !removed {
+		t.Fatalf("expected the credential to be removed")
+	}
+	expectResult(t, store, "party", "guests", ResultIncorrect, "")
+	_, err = store.RemoveCredential("party", "old")
+	must(t, err)
+	if _, err := store.RemoveCredential("party", DefaultCredentialName); !errors.Is(err, ErrLastCredential) {
+		t.Fatalf("expected ErrLastCredential, got %v", err)
+	}
+}
+
+// Sessions are bound to a credential's fingerprint, so it must change
+// whenever the password does, even within the same second, & only then.
+func testCredentialFingerprint(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	_, err := store.SetCredential("party", "guests", CredentialUpdate{Password: "guests", StorageMode: StorageModeHash})
+	must(t, err)
+	fingerprint := getMetadata(t, store, "party").CredentialFingerprint(DefaultCredentialName)
+	guests := getMetadata(t, store, "party").CredentialFingerprint("guests")
+	if fingerprint == "" || guests == "" || fingerprint == guests {
+		t.Fatalf("expected distinct fingerprints, got %q & %q", fingerprint, guests)
+	}
+	if getMetadata(t, store, "party").CredentialFingerprint("missing") != "" {
+		t.Fatalf("expected no fingerprint for a missing credential")
+	}
+
+	_, err = store.UpdateEntryMetadata("party", EntryMetadataUpdate{Description: ptr("Party")})
+	must(t, err)
+	expectResult(t, store, "party", "secret", ResultSuccess, DefaultCredentialName)
+	if getMetadata(t, store, "party").CredentialFingerprint(DefaultCredentialName) != fingerprint {
+		t.Fatalf("expected the fingerprint to survive changes that keep the password")
+	}
+
+	_, err = store.UpsertPassword("party", "secret", StorageModeEncrypted)
+	must(t, err)
+	if getMetadata(t, store, "party").CredentialFingerprint(DefaultCredentialName) == fingerprint {
+		t.Fatalf("expected replacing the password to change the fingerprint")
+	}
+	_, err = store.SetCredential("party", "guests", CredentialUpdate{Password: "guests", StorageMode: StorageModeHash})
+	must(t, err)
+	if getMetadata(t, store, "party").CredentialFingerprint("guests") == guests {
+		t.Fatalf("expected replacing the credential to change the fingerprint")
+	}
+}
+
+func testUpdateMetadata(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	notBefore := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	updated, err := store.UpdateEntryMetadata("party", EntryMetadataUpdate{
+		Description:    ptr("Party"),
+		Tags:           ptr([]string{"b", "a", "a"}),
+		NotBefore:      &notBefore,
+		ExpiresAt:      &expiresAt,
+		MaxUses:        ptr(3),
+		AllowedOrigins: ptr([]string{"https://party.example.com"}),
+	})
+	must(t, err)
+	if !updated {
+		t.Fatalf("expected the entry to be updated")
+	}
+
+	// Fields that aren't given are left alone
+	_, err = store.UpdateEntryMetadata("party", EntryMetadataUpdate{Description: ptr("Changed")})
+	must(t, err)
+	m := getMetadata(t, store, "party")
+	if m.Description != "Changed" ||
+		!slices.Equal(m.Tags, []string{"b", "a"}) ||
+		!m.NotBefore.Equal(notBefore) ||
+		!m.ExpiresAt.Equal(expiresAt) ||
+		m.MaxUses != 3 ||
+		!slices.Equal(m.AllowedOrigins, []string{"https://party.example.com"}) {
+		t.Fatalf("unexpected metadata after partial update: %+v", m)
+	}
+
+	// The window is checked against the bound that isn't given
+	before := notBefore.Add(-time.Minute)
+	if _, err := store.UpdateEntryMetadata("party", EntryMetadataUpdate{ExpiresAt: &before}); !errors.Is(err, ErrInvalidWindow) {
+		t.Fatalf("expected ErrInvalidWindow, got %v", err)
+	}
+	if _, err := store.UpdateEntryMetadata("party", EntryMetadataUpdate{MaxUses: ptr(-1)}); !errors.Is(err, ErrInvalidMaxUses) {
+		t.Fatalf("expected ErrInvalidMaxUses, got %v", err)
+	}
+	if _, err := store.UpdateEntryMetadata("party", EntryMetadataUpdate{AllowedOrigins: ptr([]string{"not an origin"})}); !errors.Is(err, ErrInvalidOrigin) {
+		t.Fatalf("expected ErrInvalidOrigin, got %v", err)
+	}
+
+	// Zero values clear their field
+	_, err = store.UpdateEntryMetadata("party", EntryMetadataUpdate{ExpiresAt: &time.Time{}, MaxUses: ptr(0), AllowedOrigins: &[]string{}})
+	must(t, err)
+	m = getMetadata(t, store, "party")
+	if !m.ExpiresAt.IsZero() || m.MaxUses != 0 || len(m.AllowedOrigins) != 0 || !m.NotBefore.Equal(notBefore) {
+		t.Fatalf("unexpected metadata after clearing: %+v", m)
+	}
+
+	updated, err = store.UpdateEntryMetadata("missing", EntryMetadataUpdate{Description: ptr("x")})
+	must(t, err)
+	if updated {
+		t.Fatalf("expected updating a missing entry to report false")
+	}
+}
+
+func testActivationWindow(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("past", "secret", StorageModeEncrypted))
+	must(t, store.CreatePassword("future", "secret", StorageModeEncrypted))
+	_, err := store.UpdateEntryMetadata("past", EntryMetadataUpdate{ExpiresAt: ptr(time.Now().Add(-2 * time.Hour))})
+	must(t, err)
+	_, err = store.UpdateEntryMetadata("future", EntryMetadataUpdate{NotBefore: ptr(time.Now().Add(time.Hour))})
+	must(t, err)
+
+	expectResult(t, store, "past", "secret", ResultExpired, DefaultCredentialName)
+	expectResult(t, store, "future", "secret", ResultNotYetActive, DefaultCredentialName)
+
+	purged, err := store.PurgeExpiredEntries(time.Now().Add(-time.Hour))
+	must(t, err)
+	if !slices.Equal(purged, []string{"past"}) {
+		t.Fatalf("unexpected purged entries: %v", purged)
+	}
+	ids, err := store.ListIds()
+	must(t, err)
+	if !slices.Equal(ids, []string{"future"}) {
+		t.Fatalf("unexpected ids after purge: %v", ids)
+	}
+}
+
+func testMaxUses(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("invite", "secret", StorageModeEncrypted))
+	_, err := store.UpdateEntryMetadata("invite", EntryMetadataUpdate{MaxUses: ptr(1)})
+	must(t, err)
+
+	// Checking doesn't use the entry up
+	result, _, err := store.CheckPassword("invite", "secret")
+	must(t, err)
+	if result != ResultSuccess {
+		t.Fatalf("expected check to succeed, got %s", result)
+	}
+	expectResult(t, store, "invite", "secret", ResultSuccess, DefaultCredentialName)
+	expectResult(t, store, "invite", "secret", ResultExhausted, DefaultCredentialName)
+
+	m := getMetadata(t, store, "invite")
+	if m.UseCount != 1 || m.ValidationCount != 1 || m.RemainingUses() != 0 || m.LastValidatedOn.IsZero() {
+		t.Fatalf("unexpected usage metadata: %+v", m)
+	}
+}
+
+func testAllowedOrigins(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	allowed, err := store.IsOriginAllowed("https://anywhere.example.com")
+	must(t, err)
+	if !allowed {
+		t.Fatalf("expected an unrestricted entry to allow any origin")
+	}
+
+	updated, err := store.SetAllowedOrigins("party", []string{"HTTPS://Party.Example.com"})
+	must(t, err)
+	if !updated {
+		t.Fatalf("expected the entry's origins to be set")
+	}
+	m := getMetadata(t, store, "party")
+	if !m.AllowsOrigin("https://party.example.com") || m.AllowsOrigin("https://other.example.com") || !m.AllowsOrigin("") {
+		t.Fatalf("unexpected allowed origins: %v", m.AllowedOrigins)
+	}
+	for origin, expected := range map[string]bool{"https://party.example.com": true, "https://other.example.com": false} {
+		allowed, err := store.IsOriginAllowed(origin)
+		must(t, err)
+		if allowed != expected {
+			t.Fatalf("expected IsOriginAllowed(%s) to be %t", origin, expected)
+		}
+	}
+}
+
+func testPayloadsAndRedirects(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	updated, err := store.SetPayload("party", Payload{ContentType: "text/plain", Filename: "address.txt", Content: []byte("1 Main St")})
+	must(t, err)
+	if !updated {
+		t.Fatalf("expected the payload to be set")
+	}
+	if _, err := store.SetPayload("missing", Payload{ContentType: "text/plain"}); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("expected ErrEntryNotFound, got %v", err)
+	}
+	payload, err := store.GetPayload("party")
+	must(t, err)
+	if payload == nil || string(payload.Content) != "1 Main St" || payload.Filename != "address.txt" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+	m := getMetadata(t, store, "party")
+	if m.Payload == nil || m.Payload.Size != len("1 Main St") {
+		t.Fatalf("unexpected payload info: %+v", m.Payload)
+	}
+
+	_, err = store.SetRedirect("party", "https://party.example.com/details")
+	must(t, err)
+	target, err := store.GetRedirect("party")
+	must(t, err)
+	if target != "https://party.example.com/details" || !getMetadata(t, store, "party").HasRedirect {
+		t.Fatalf("unexpected redirect: %q", target)
+	}
+
+	removed, err := store.RemovePayload("party")
+	must(t, err)
+	if !removed {
+		t.Fatalf("expected the payload to be removed")
+	}
+	removed, err = store.RemoveRedirect("party")
+	must(t, err)
+	if !removed {
+		t.Fatalf("expected the redirect to be removed")
+	}
+	if payload, err := store.GetPayload("party"); err != nil || payload != nil {
+		t.Fatalf("expected no payload, got %+v, %v", payload, err)
+	}
+	if target, err := store.GetRedirect("party"); err != nil || target != "" {
+		t.Fatalf("expected no redirect, got %q, %v", target, err)
+	}
+}
+
+func testRoutes(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("public", "secret", StorageModeEncrypted))
+	must(t, store.CreatePassword("private", "secret", StorageModeEncrypted))
+	for _, r := range []Route{
+		{Host: "", PathPrefix: "/", EntryId: "public"},
+		{Host: "", PathPrefix: "/secret", EntryId: "private"},
+		{Host: "other.example.com", PathPrefix: "/", EntryId: "private"},
+	} {
+		_, err := store.SetRoute(r)
+		must(t, err)
+	}
+	if _, err := store.SetRoute(Route{PathPrefix: "/x", EntryId: "missing"}); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("expected ErrEntryNotFound, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		host     string
+		path     string
+		expected string
+	}{
+		{"party.example.com", "/", "public"},
+		{"party.example.com", "/secret/x", "private"},
+		{"party.example.com", "/secretive", "public"},
+		{"party.example.com", "/public/../secret/x", "private"},
+		{"party.example.com", "/%73ecret/x", "private"},
+		{"other.example.com", "/anything", "private"},
+	} {
+		route, err := store.ResolveRoute(tt.host, tt.path)
+		must(t, err)
+		if route == nil || route.EntryId != tt.expected {
+			t.Fatalf("expected %s%s to resolve to %s, got %+v", tt.host, tt.path, tt.expected, route)
+		}
+	}
+	for _, path := range []string{"/secret%2fx", "relative"} {
+		if _, err := store.ResolveRoute("party.example.com", path); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("expected ErrInvalidPath for %s, got %v", path, err)
+		}
+	}
+
+	entryId, err := store.RemoveRoute("", "/secret")
+	must(t, err)
+	if entryId != "private" {
+		t.Fatalf("expected the removed route's entry, got %q", entryId)
+	}
+	routes, err := store.ListRoutes()
+	must(t, err)
+	if len(routes) != 2 {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+}
+
+func testDeleteEntry(t *testing.T, store Store, _ *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	_, err := store.SetPayload("party", Payload{ContentType: "text/plain", Content: []byte("x")})
+	must(t, err)
+	_, err = store.SetRoute(Route{PathPrefix: "/party", EntryId: "party"})
+	must(t, err)
+
+	deleted, err := store.DeleteEntry("party")
+	must(t, err)
+	if !deleted {
+		t.Fatalf("expected the entry to be deleted")
+	}
+	deleted, err = store.DeleteEntry("party")
+	must(t, err)
+	if deleted {
+		t.Fatalf("expected deleting a missing entry to report false")
+	}
+	expectResult(t, store, "party", "secret", ResultNotFound, "")
+	if payload, err := store.GetPayload("party"); err != nil || payload != nil {
+		t.Fatalf("expected the payload to be deleted along with the entry, got %+v, %v", payload, err)
+	}
+	if routes, err := store.ListRoutes(); err != nil || len(routes) != 0 {
+		t.Fatalf("expected the routes to be deleted along with the entry, got %+v, %v", routes, err)
+	}
+}
+
+func testAttempts(t *testing.T, store Store, _ *crypto.Keyring) {
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, result := range []ValidationResult{ResultIncorrect, ResultSuccess, ResultIncorrect} {
+		must(t, store.RecordValidationAttempt(ValidationAttempt{
+			EntryId:     "party",
+			AttemptedOn: now.Add(time.Duration(i) * time.Second),
+			Result:      result,
+			ClientIP:    "10.0.0.1",
+		}))
+	}
+	must(t, store.RecordValidationAttempt(ValidationAttempt{EntryId: "other", AttemptedOn: now, Result: ResultNotFound}))
+
+	attempts, total, err := store.ListValidationAttempts(AttemptFilter{EntryId: "party", Result: ResultIncorrect})
+	must(t, err)
+	if total != 2 || len(attempts) != 2 {
+		t.Fatalf("expected 2 incorrect attempts, got %d (%d listed)", total, len(attempts))
+	}
+	if !attempts[0].AttemptedOn.After(attempts[1].AttemptedOn) {
+		t.Fatalf("expected the newest attempt first, got %v then %v", attempts[0].AttemptedOn, attempts[1].AttemptedOn)
+	}
+	attempts, total, err = store.ListValidationAttempts(AttemptFilter{Limit: 1})
+	must(t, err)
+	if total != 4 || len(attempts) != 1 {
+		t.Fatalf("expected 1 of 4 attempts, got %d of %d", len(attempts), total)
+	}
+
+	must(t, store.RecordAdminAction(AdminAuditRecord{OccurredOn: now, Action: ActionCreate, EntryId: "party", Subject: "admin"}))
+	records, total, err := store.ListAdminAuditRecords(AuditFilter{EntryId: "party"})
+	must(t, err)
+	if total != 1 || records[0].Action != ActionCreate || records[0].Subject != "admin" {
+		t.Fatalf("unexpected audit records: %+v", records)
+	}
+}
+
+func testThrottles(t *testing.T, store Store, _ *crypto.Keyring) {
+	now := time.Now().UTC().Truncate(time.Second)
+	increment := func(state ThrottleState) ThrottleState {
+		state.Failures++
+		state.LastFailureOn = now
+		state.LockedUntil = now.Add(time.Minute)
+		return state
+	}
+	must(t, store.UpdateThrottleState("ip:10.0.0.1", func(state ThrottleState) ThrottleState {
+		if state.Failures != 0 || !state.LockedUntil.IsZero() {
+			t.Fatalf("expected a new key to have no failures, got %+v", state)
+		}
+		return increment(state)
+	}))
+	must(t, store.UpdateThrottleState("ip:10.0.0.1", increment))
+	must(t, store.UpdateThrottleState("ip:10.0.0.1", func(state ThrottleState) ThrottleState {
+		if state.Failures != 2 || !state.LockedUntil.Equal(now.Add(time.Minute)) {
+			t.Fatalf("expected the stored state, got %+v", state)
+		}
+		return state
+	}))
+
+	// Still locked, so not pruned
+	pruned, err := store.PruneThrottleStates(now.Add(time.Second))
+	must(t, err)
+	if pruned != 0 {
+		t.Fatalf("expected a locked key to be kept, pruned %d", pruned)
+	}
+	pruned, err = store.PruneThrottleStates(now.Add(2 * time.Minute))
+	must(t, err)
+	if pruned != 1 {
+		t.Fatalf("expected the key to be pruned, pruned %d", pruned)
+	}
+
+	must(t, store.UpdateThrottleState("id:party", increment))
+	must(t, store.DeleteThrottleState("id:party"))
+	must(t, store.UpdateThrottleState("id:party", func(state ThrottleState) ThrottleState {
+		if state.Failures != 0 {
+			t.Fatalf("expected a deleted key to have no failures, got %+v", state)
+		}
+		return state
+	}))
+}
+
+func testSigningKeys(t *testing.T, store Store, _ *crypto.Keyring) {
+	now := time.Now().UTC().Truncate(time.Second)
+	must(t, store.RotateSigningKey(SigningKey{Id: "first", Algorithm: "EdDSA", PrivateKey: []byte("first key"), CreatedOn: now.Add(-time.Hour)}))
+	must(t, store.RotateSigningKey(SigningKey{Id: "second", Algorithm: "EdDSA", PrivateKey: []byte("second key"), CreatedOn: now}))
+
+	keys, err := store.ListSigningKeys()
+	must(t, err)
+	if len(keys) != 2 || keys[0].Id != "second" || !keys[0].RetiredOn.IsZero() || keys[1].RetiredOn.IsZero() {
+		t.Fatalf("expected the newest key first & the other retired, got %+v", keys)
+	}
+	if string(keys[1].PrivateKey) != "first key" {
+		t.Fatalf("expected the private key to be unsealed, got %q", keys[1].PrivateKey)
+	}
+
+	pruned, err := store.PruneSigningKeys(now.Add(time.Hour))
+	must(t, err)
+	if pruned != 1 {
+		t.Fatalf("expected the retired key to be pruned, pruned %d", pruned)
+	}
+}
+
+func testReencrypt(t *testing.T, store Store, key *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	must(t, store.CreatePassword("hashed", "secret", StorageModeHash))
+	_, err := store.SetPayload("party", Payload{ContentType: "text/plain", Content: []byte("x")})
+	must(t, err)
+
+	oldPrimary := key.Primary()
+	_, err = key.Rotate()
+	must(t, err)
+	updated, err := store.Reencrypt(1)
+	must(t, err)
+	if updated != 1 {
+		t.Fatalf("expected the limit to be respected, updated %d", updated)
+	}
+	counts, err := store.CountSealedByKey()
+	must(t, err)
+	if counts[oldPrimary] != 2 || counts[key.Primary()] != 1 {
+		t.Fatalf("unexpected values sealed by each key: %v", counts)
+	}
+	updated, err = store.Reencrypt(0)
+	must(t, err)
+	if updated != 2 {
+		t.Fatalf("expected the remaining values to be re-encrypted, updated %d", updated)
+	}
+
+	// Once nothing depends on the old key it can go
+	counts, err = store.CountSealedByKey()
+	must(t, err)
+	if counts[oldPrimary] != 0 {
+		t.Fatalf("expected nothing to be sealed with the old key, got %v", counts)
+	}
+	key.Prune()
+	expectResult(t, store, "party", "secret", ResultSuccess, DefaultCredentialName)
+	expectResult(t, store, "hashed", "secret", ResultSuccess, DefaultCredentialName)
+	payload, err := store.GetPayload("party")
+	must(t, err)
+	if payload == nil || string(payload.Content) != "x" {
+		t.Fatalf("unexpected payload after re-encrypting: %+v", payload)
+	}
+}
+
+func testExportImport(t *testing.T, store Store, key *crypto.Keyring) {
+	must(t, store.CreatePassword("party", "secret", StorageModeEncrypted))
+	_, err := store.SetCredential("party", "guests", CredentialUpdate{Password: "guests", StorageMode: StorageModeHash})
+	must(t, err)
+	_, err = store.UpdateEntryMetadata("party", EntryMetadataUpdate{Description: ptr("Party"), MaxUses: ptr(5)})
+	must(t, err)
+	_, err = store.SetRedirect("party", "https://party.example.com")
+	must(t, err)
+	_, err = store.SetRoute(Route{PathPrefix: "/party", EntryId: "party"})
+	must(t, err)
+
+	entries, err := store.ExportEntries()
+	must(t, err)
+	if len(entries) != 1 || len(entries[0].Credentials) != 2 {
+		t.Fatalf("unexpected export: %+v", entries)
+	}
+
+	// Into a fresh store sealed with another key
+	target := newMemoryStore(t, newKeyring(t))
+	result, err := target.ImportEntries(entries, ConflictFail)
+	must(t, err)
+	if !slices.Equal(result.Created, []string{"party"}) {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+	expectResult(t, target, "party", "secret", ResultSuccess, DefaultCredentialName)
+	expectResult(t, target, "party", "guests", ResultSuccess, "guests")
+	m := getMetadata(t, target, "party")
+	if m.Description != "Party" || m.MaxUses != 5 || !m.HasRedirect {
+		t.Fatalf("unexpected imported metadata: %+v", m)
+	}
+
+	if _, err := store.ImportEntries(entries, ConflictFail); !errors.Is(err, ErrImportConflict) {
+		t.Fatalf("expected ErrImportConflict importing over an existing entry, got %v", err)
+	}
+	result, err = store.ImportEntries(entries, ConflictSkip)
+	must(t, err)
+	if !slices.Equal(result.Skipped, []string{"party"}) {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+}
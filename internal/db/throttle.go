@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ThrottleState tracks recent validation failures for a single rate limiting
+// key (e.g. a client IP or an entry id).
+type ThrottleState struct {
+	Key           string
+	Failures      int
+	LastFailureOn time.Time
+	LockedUntil   time.Time
+}
+
+// UpdateThrottleState replaces the state for key with what update returns
+// for its current state, which has no failures if there is none. The row is
+// locked from being read until it is written, so concurrent updates of the
+// same key (e.g. from several replicas) are applied one after the other.
+func (passddb *PassdDb) UpdateThrottleState(key string, update func(state ThrottleState) ThrottleState) error {
+	tx, err := passddb.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Writing first takes the lock: the row's in Postgres, the database's in
+	// SQLite.
+	now := formatTimestamp(time.Now())
+	if _, err := tx.Exec(`INSERT INTO validation_throttles (key, failures, last_failure_on) VALUES (?, 0, ?)
+		ON CONFLICT(key) DO UPDATE SET failures = validation_throttles.failures`, key, now); err != nil {
+		return fmt.Errorf("failed to lock throttle state: %w", err)
+	}
+
+	state := ThrottleState{Key: key}
+	var lastFailureOn string
+	var lockedUntil sql.NullString
+	err = tx.QueryRow("SELECT failures, last_failure_on, locked_until FROM validation_throttles WHERE key = ?", key).
+		Scan(&state.Failures, &lastFailureOn, &lockedUntil)
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	if state.LastFailureOn, err = parseTimestamp(lastFailureOn); err != nil {
+		return err
+	}
+	if state.LockedUntil, err = parseNullTimestamp(lockedUntil); err != nil {
+		return err
+	}
+
+	state = update(state)
+	_, err = tx.Exec("UPDATE validation_throttles SET failures = ?, last_failure_on = ?, locked_until = ? WHERE key = ?",
+		state.Failures, formatTimestamp(state.LastFailureOn), nullTimestamp(state.LockedUntil), key)
+	if err != nil {
+		return fmt.Errorf("failed to save throttle state: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (passddb *PassdDb) DeleteThrottleState(key string) error {
+	if _, err := passddb.db.Exec("DELETE FROM validation_throttles WHERE key = ?", key); err != nil {
+		return fmt.Errorf("failed to delete throttle state: %w", err)
+	}
+	return nil
+}
+
+// PruneThrottleStates deletes all throttle state whose last failure was
+// before the given time & which isn't currently locked out.
+func (passddb *PassdDb) PruneThrottleStates(before time.Time) (int, error) {
+	cutoff := formatTimestamp(before)
+	result, err := passddb.db.Exec(
+		"DELETE FROM validation_throttles WHERE last_failure_on < ? AND (locked_until IS NULL OR locked_until < ?)",
+		cutoff, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune throttle state: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return int(rowsAffected), nil
+}
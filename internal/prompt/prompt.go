@@ -0,0 +1,61 @@
+package prompt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+var (
+	ErrMismatch error = errors.New("entries do not match")
+
+	// Shared so that consecutive reads from a pipe don't lose buffered input.
+	stdin *bufio.Reader = bufio.NewReader(os.Stdin)
+)
+
+// IsTerminal reports whether stdin is attached to a terminal.
+func IsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// ReadSecret reads a secret from stdin. On a terminal the user is shown
+// prompt & their input is not echoed; otherwise a single line is consumed.
+func ReadSecret(prompt string) ([]byte, error) {
+	if IsTerminal() {
+		fmt.Fprint(os.Stderr, prompt)
+		secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from terminal: %w", err)
+		}
+		return secret, nil
+	}
+
+	line, err := stdin.ReadBytes('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+		return nil, fmt.Errorf("failed to read from stdin: %w", err)
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// ReadNewSecret is like ReadSecret, but on a terminal the user has to enter
+// the secret twice.
+func ReadNewSecret(prompt string) ([]byte, error) {
+	secret, err := ReadSecret(prompt)
+	if err != nil || !IsTerminal() {
+		return secret, err
+	}
+	confirmation, err := ReadSecret("Confirm: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(secret, confirmation) {
+		return nil, ErrMismatch
+	}
+	return secret, nil
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/db"
+)
+
+// Store persists throttle state so that restarting the service doesn't reset
+// anyone's failure count. UpdateThrottleState must apply concurrent updates of
+// a key one after the other, including across replicas sharing the store.
+type Store interface {
+	UpdateThrottleState(key string, update func(state db.ThrottleState) db.ThrottleState) error
+	DeleteThrottleState(key string) error
+	PruneThrottleStates(before time.Time) (int, error)
+}
+
+// Policy controls how quickly a single key gets locked out.
+type Policy struct {
+	// FreeAttempts is the number of failures allowed before lockouts start.
+	FreeAttempts int
+	// BaseLockout is the lockout applied on the first failure past
+	// FreeAttempts; it doubles with each subsequent failure.
+	BaseLockout time.Duration
+	// MaxLockout caps the length of a single lockout.
+	MaxLockout time.Duration
+}
+
+func (p Policy) lockoutFor(failures int) time.Duration {
+	excess := failures - p.FreeAttempts
+	if excess <= 0 {
+		return 0
+	}
+	lockout := p.BaseLockout
+	for i := 1; i < excess && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, p.MaxLockout)
+}
+
+// Limiter applies exponential backoff to validation failures. Each attempt
+// is checked against several keys (e.g. the client IP & the entry id), each
+// of which can have its own Policy based on its prefix.
+//
+// Attempts are counted as failures as soon as they are reserved, before the
+// password is checked, so that a burst of concurrent attempts can't all get
+// in before the first of them is counted; successful ones are refunded.
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+	// resetAfter is how long a key has to go without failures before its
+	// count is forgotten.
+	resetAfter time.Duration
+	now        func() time.Time
+}
+
+func NewLimiter(store Store, policies map[string]Policy, resetAfter time.Duration) *Limiter {
+	return &Limiter{
+		store:      store,
+		policies:   policies,
+		resetAfter: resetAfter,
+		now:        time.Now,
+	}
+}
+
+// Key builds a rate limiting key from a kind (which selects the Policy) & a
+// value, e.g. Key("ip", "10.0.0.1").
+func Key(kind string, value string) string {
+	return kind + ":" + value
+}
+
+func (l *Limiter) policyFor(key string) (Policy, error) {
+	for kind, policy := range l.policies {
+		if strings.HasPrefix(key, kind+":") {
+			return policy, nil
+		}
+	}
+	return Policy{}, fmt.Errorf("no rate limiting policy for key %q", key)
+}
+
+// Reserve counts an attempt as a failure against each of keys, locking them
+// out once their policy's free attempts are exhausted, unless any of them is
+// already locked out. It returns how long the caller must wait in that case,
+// or zero if the attempt may proceed.
+func (l *Limiter) Reserve(keys ...string) (time.Duration, error) {
+	now := l.now()
+	reserved := []string{}
+	for _, key := range keys {
+		policy, err := l.policyFor(key)
+		if err != nil {
+			return 0, errors.Join(err, l.Release(reserved...))
+		}
+		var wait time.Duration
+		err = l.store.UpdateThrottleState(key, func(state db.ThrottleState) db.ThrottleState {
+			if state.LockedUntil.After(now) {
+				wait = state.LockedUntil.Sub(now)
+				return state
+			}
+			if now.Sub(state.LastFailureOn) > l.resetAfter {
+				state = db.ThrottleState{Key: key}
+			}
+			state.Failures++
+			state.LastFailureOn = now
+			if lockout := policy.lockoutFor(state.Failures); lockout > 0 {
+				state.LockedUntil = now.Add(lockout)
+			}
+			return state
+		})
+		if err != nil {
+			return 0, errors.Join(err, l.Release(reserved...))
+		}
+		if wait > 0 {
+			return wait, l.Release(reserved...)
+		}
+		reserved = append(reserved, key)
+	}
+	return 0, nil
+}
+
+// Release refunds an attempt reserved against each of keys, e.g. because it
+// succeeded or couldn't be checked, lifting the lockout it caused if any.
+func (l *Limiter) Release(keys ...string) error {
+	for _, key := range keys {
+		policy, err := l.policyFor(key)
+		if err != nil {
+			return err
+		}
+		err = l.store.UpdateThrottleState(key, func(state db.ThrottleState) db.ThrottleState {
+			state.Failures = max(state.Failures-1, 0)
+			if policy.lockoutFor(state.Failures) == 0 {
+				state.LockedUntil = time.Time{}
+			}
+			return state
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordSuccess clears any failures counted against keys.
+func (l *Limiter) RecordSuccess(keys ...string) error {
+	for _, key := range keys {
+		if err := l.store.DeleteThrottleState(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune forgets keys that have gone resetAfter without a failure.
+func (l *Limiter) Prune() (int, error) {
+	return l.store.PruneThrottleStates(l.now().Add(-l.resetAfter))
+}
@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+	"github.com/mrshanahan/simple-password-service/internal/db"
+)
+
+var testPolicies = map[string]Policy{
+	"ip": {FreeAttempts: 2, BaseLockout: time.Minute, MaxLockout: 4 * time.Minute},
+	"id": {FreeAttempts: 4, BaseLockout: time.Minute, MaxLockout: time.Hour},
+}
+
+func newLimiter(t *testing.T, now *time.Time) *Limiter {
+	t.Helper()
+	key, err := crypto.GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	store, err := db.NewMemoryStore(key)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	l := NewLimiter(store, testPolicies, time.Hour)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func reserve(t *testing.T, l *Limiter, keys ...string) time.Duration {
+	t.Helper()
+	wait, err := l.Reserve(keys...)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	return wait
+}
+
+func TestLockoutFor(t *testing.T) {
+	p := testPolicies["ip"]
+	for failures, expected := range map[int]time.Duration{
+		0: 0,
+		2: 0,
+		3: time.Minute,
+		4: 2 * time.Minute,
+		5: 4 * time.Minute,
+		9: 4 * time.Minute,
+	} {
+		if actual := p.lockoutFor(failures); actual != expected {
+			t.Errorf("%d failures: expected %v, got %v", failures, expected, actual)
+		}
+	}
+}
+
+// Every reserved attempt counts as a failure until it is released, so the
+// free attempts run out & lockouts double up to the maximum.
+func TestReserve(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	l := newLimiter(t, &now)
+	ip := Key("ip", "10.0.0.1")
+
+	for i := range 3 {
+		if wait := reserve(t, l, ip); wait != 0 {
+			t.Fatalf("attempt %d: expected to proceed, told to wait %v", i+1, wait)
+		}
+	}
+	if wait := reserve(t, l, ip); wait != time.Minute {
+		t.Fatalf("expected a 1m lockout, got %v", wait)
+	}
+	now = now.Add(30 * time.Second)
+	if wait := reserve(t, l, ip); wait != 30*time.Second {
+		t.Fatalf("expected the rest of the lockout, got %v", wait)
+	}
+
+	now = now.Add(time.Minute)
+	if wait := reserve(t, l, ip); wait != 0 {
+		t.Fatalf("expected to proceed once the lockout is over, told to wait %v", wait)
+	}
+	if wait := reserve(t, l, ip); wait != 2*time.Minute {
+		t.Fatalf("expected the lockout to double, got %v", wait)
+	}
+
+	// Successes clear the key, & other keys are unaffected throughout
+	if wait := reserve(t, l, Key("ip", "10.0.0.2")); wait != 0 {
+		t.Fatalf("expected another IP to proceed, told to wait %v", wait)
+	}
+	if err := l.RecordSuccess(ip); err != nil {
+		t.Fatalf("failed to record success: %v", err)
+	}
+	if wait := reserve(t, l, ip); wait != 0 {
+		t.Fatalf("expected to proceed after a success, told to wait %v", wait)
+	}
+}
+
+// Releasing refunds the attempt, so successful or unchecked attempts never
+// lead to a lockout.
+func TestRelease(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	l := newLimiter(t, &now)
+	ip := Key("ip", "10.0.0.1")
+
+	for i := range 10 {
+		if wait := reserve(t, l, ip); wait != 0 {
+			t.Fatalf("attempt %d: expected to proceed, told to wait %v", i+1, wait)
+		}
+		if err := l.Release(ip); err != nil {
+			t.Fatalf("failed to release: %v", err)
+		}
+	}
+
+	// Releasing the attempt that caused a lockout lifts it again
+	for range 3 {
+		reserve(t, l, ip)
+	}
+	if err := l.Release(ip); err != nil {
+		t.Fatalf("failed to release: %v", err)
+	}
+	if wait := reserve(t, l, ip); wait != 0 {
+		t.Fatalf("expected the released lockout to be lifted, told to wait %v", wait)
+	}
+}
+
+// An attempt locked out by one key isn't counted against the others.
+func TestReserveSeveralKeys(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	l := newLimiter(t, &now)
+	ip, id := Key("ip", "10.0.0.1"), Key("id", "party")
+
+	for range 3 {
+		reserve(t, l, id, ip)
+	}
+	for range 3 {
+		if wait := reserve(t, l, id, ip); wait == 0 {
+			t.Fatalf("expected the IP to be locked out")
+		}
+	}
+	// Only the 3 attempts that got through count against the id, so it can
+	// make 2 more, the second of which locks it out
+	for range 2 {
+		if wait := reserve(t, l, id); wait != 0 {
+			t.Fatalf("expected the id to have attempts left, told to wait %v", wait)
+		}
+	}
+	if wait := reserve(t, l, id); wait != time.Minute {
+		t.Fatalf("expected the id to be locked out now, got %v", wait)
+	}
+
+	if _, err := l.Reserve(Key("unknown", "x")); err == nil {
+		t.Fatalf("expected a key without a policy to be rejected")
+	}
+}
+
+// Concurrent attempts are each counted, so a burst can't get more attempts
+// in than the policy allows.
+func TestReserveConcurrently(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	l := newLimiter(t, &now)
+	ip := Key("ip", "10.0.0.1")
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			wait, err := l.Reserve(ip)
+			if err != nil {
+				t.Errorf("failed to reserve: %v", err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Fatalf("expected 3 attempts to get through, got %d", allowed)
+	}
+}
+
+// Failures are forgotten after resetAfter, both when counting & pruning.
+func TestResetAndPrune(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	l := newLimiter(t, &now)
+	ip := Key("ip", "10.0.0.1")
+
+	for range 3 {
+		reserve(t, l, ip)
+	}
+	now = now.Add(2 * time.Hour)
+	for i := range 3 {
+		if wait := reserve(t, l, ip); wait != 0 {
+			t.Fatalf("attempt %d: expected the count to have been reset, told to wait %v", i+1, wait)
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+	pruned, err := l.Prune()
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if pruned != 1 {
+		t.Fatalf("expected 1 key to be pruned, pruned %d", pruned)
+	}
+}
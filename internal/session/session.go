@@ -0,0 +1,253 @@
+package session
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/mrshanahan/simple-password-service/internal/db"
+)
+
+const (
+	// Algorithm is the JWS algorithm used to sign session tokens.
+	Algorithm string = "EdDSA"
+
+	// CredentialClaim holds a fingerprint of the credential a token was
+	// issued for, so that the token can be rejected once that credential
+	// changes or is removed.
+	CredentialClaim string = "passd_cred"
+)
+
+var ErrInvalidToken error = errors.New("invalid session token")
+
+// Store persists signing keys, sealed with the keyring.
+type Store interface {
+	ListSigningKeys() ([]db.SigningKey, error)
+	RotateSigningKey(key db.SigningKey) error
+	PruneSigningKeys(retiredBefore time.Time) (int, error)
+}
+
+// Session is what a verified session token says about its holder.
+type Session struct {
+	Id      string
+	EntryId string
+	// Credential is the fingerprint the token was issued with; it is up to
+	// the caller to check it against the entry's current credential.
+	Credential string
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+}
+
+// Manager issues & verifies session tokens: short-lived JWTs, signed with
+// Ed25519, whose subject is the id of the entry that was validated.
+type Manager struct {
+	store    Store
+	issuer   string
+	audience string
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu         sync.RWMutex
+	signingKey jwk.Key
+	publicKeys jwk.Set
+}
+
+// NewManager loads the signing keys from store, generating one if there is
+// no active key yet. Tokens are issued by issuer for audience & are valid for
+// ttl.
+func NewManager(store Store, issuer string, audience string, ttl time.Duration) (*Manager, error) {
+	m := &Manager{
+		store:    store,
+		issuer:   issuer,
+		audience: audience,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	if m.signingKey == nil {
+		if _, err := m.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Manager) TTL() time.Duration {
+	return m.ttl
+}
+
+func importKey(k db.SigningKey) (jwk.Key, error) {
+	if k.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported signing key algorithm: %s", k.Algorithm)
+	}
+	if len(k.PrivateKey) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key %s", k.Id)
+	}
+	key, err := jwk.Import(ed25519.NewKeyFromSeed(k.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to import signing key %s: %w", k.Id, err)
+	}
+	for name, value := range map[string]any{
+		jwk.KeyIDKey:     k.Id,
+		jwk.AlgorithmKey: jwa.EdDSA(),
+		jwk.KeyUsageKey:  jwk.ForSignature,
+	} {
+		if err := key.Set(name, value); err != nil {
+			return nil, fmt.Errorf("failed to set %s on signing key %s: %w", name, k.Id, err)
+		}
+	}
+	return key, nil
+}
+
+// Reload picks up keys rotated by other processes & forgets keys that were
+// retired long enough ago that every token they signed has expired.
+func (m *Manager) Reload() error {
+	if _, err := m.store.PruneSigningKeys(m.now().Add(-m.ttl)); err != nil {
+		return err
+	}
+	keys, err := m.store.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	var signingKey jwk.Key
+	privateKeys := jwk.NewSet()
+	for _, k := range keys {
+		key, err := importKey(k)
+		if err != nil {
+			return err
+		}
+		if k.RetiredOn.IsZero() && signingKey == nil {
+			signingKey = key
+		}
+		if err := privateKeys.AddKey(key); err != nil {
+			return fmt.Errorf("failed to add signing key %s: %w", k.Id, err)
+		}
+	}
+	publicKeys, err := jwk.PublicSetOf(privateKeys)
+	if err != nil {
+		return fmt.Errorf("failed to derive public signing keys: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signingKey = signingKey
+	m.publicKeys = publicKeys
+	return nil
+}
+
+// Rotate generates a new signing key & retires the current one, returning
+// the new key's id. Tokens signed by the retired key remain valid until they
+// expire.
+func (m *Manager) Rotate() (string, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	key, err := jwk.Import(private.Public())
+	if err != nil {
+		return "", fmt.Errorf("failed to import signing key: %w", err)
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute signing key id: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	if err := m.store.RotateSigningKey(db.SigningKey{
+		Id:         id,
+		Algorithm:  Algorithm,
+		PrivateKey: private.Seed(),
+		CreatedOn:  m.now(),
+	}); err != nil {
+		return "", err
+	}
+	return id, m.Reload()
+}
+
+// PublicKeys returns the keys that session tokens can be verified with, for
+// publishing as a JWKS.
+func (m *Manager) PublicKeys() jwk.Set {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.publicKeys
+}
+
+// Issue signs a token for the entry with the given id, validated with the
+// credential whose fingerprint is given. It expires after the manager's TTL,
+// or at notAfter if that is sooner & non-zero.
+func (m *Manager) Issue(entryId string, credential string, notAfter time.Time) (string, time.Time, error) {
+	if credential == "" {
+		return "", time.Time{}, fmt.Errorf("a credential fingerprint is required")
+	}
+	m.mu.RLock()
+	signingKey := m.signingKey
+	m.mu.RUnlock()
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token id: %w", err)
+	}
+	now := m.now().Truncate(time.Second)
+	expiresAt := now.Add(m.ttl)
+	if !notAfter.IsZero() && notAfter.Before(expiresAt) {
+		expiresAt = notAfter
+	}
+
+	token, err := jwt.NewBuilder().
+		JwtID(base64.RawURLEncoding.EncodeToString(idBytes)).
+		Issuer(m.issuer).
+		Subject(entryId).
+		Audience([]string{m.audience}).
+		Claim(CredentialClaim, credential).
+		IssuedAt(now).
+		NotBefore(now).
+		Expiration(expiresAt).
+		Build()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to build token: %w", err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.EdDSA(), signingKey))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return string(signed), expiresAt, nil
+}
+
+// Verify checks the signature, issuer, audience & lifetime of a session
+// token.
+func (m *Manager) Verify(token string) (Session, error) {
+	parsed, err := jwt.ParseString(token,
+		jwt.WithKeySet(m.PublicKeys()),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(m.audience),
+		jwt.WithValidate(true),
+		jwt.WithClock(jwt.ClockFunc(m.now)))
+	if err != nil {
+		return Session{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	var s Session
+	s.Id, _ = parsed.JwtID()
+	s.EntryId, _ = parsed.Subject()
+	s.IssuedAt, _ = parsed.IssuedAt()
+	s.ExpiresAt, _ = parsed.Expiration()
+	if s.EntryId == "" {
+		return Session{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	if err := parsed.Get(CredentialClaim, &s.Credential); err != nil || s.Credential == "" {
+		return Session{}, fmt.Errorf("%w: missing credential", ErrInvalidToken)
+	}
+	return s, nil
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/mrshanahan/simple-password-service/internal/crypto"
+	"github.com/mrshanahan/simple-password-service/internal/db"
+)
+
+func newStore(t *testing.T) Store {
+	key, err := crypto.GenerateKeyring()
+	if err != nil {
+		t.Fatalf("failed to generate keyring: %v", err)
+	}
+	store, err := db.NewMemoryStore(key)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	return store
+}
+
+func newManager(t *testing.T, store Store, now *time.Time) *Manager {
+	m := newManagerFor(t, store, "passd", "passd-session")
+	if now != nil {
+		m.now = func() time.Time { return *now }
+	}
+	return m
+}
+
+func newManagerFor(t *testing.T, store Store, issuer string, audience string) *Manager {
+	m, err := NewManager(store, issuer, audience, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	return m
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	m := newManager(t, newStore(t), &now)
+
+	token, expiresAt, err := m.Issue("party", "fingerprint", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected the token to last the TTL, expires at %v", expiresAt)
+	}
+	s, err := m.Verify(token)
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+	if s.EntryId != "party" || s.Credential != "fingerprint" || !s.ExpiresAt.Equal(expiresAt) || s.Id == "" {
+		t.Fatalf("unexpected session: %+v", s)
+	}
+
+	// Tokens don't outlive their entry
+	token, expiresAt, err = m.Issue("party", "fingerprint", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if !expiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the token to expire with the entry, expires at %v", expiresAt)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := m.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected an expired token to be rejected, got %v", err)
+	}
+
+	if _, _, err := m.Issue("party", "", time.Time{}); err == nil {
+		t.Fatalf("expected issuing a token without a credential to fail")
+	}
+	if _, err := m.Verify("not a token"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
+
+// Tokens are only accepted from the same issuer, for the same audience &
+// signed by one of the manager's keys.
+func TestVerifyRejectsOtherTokens(t *testing.T) {
+	store := newStore(t)
+	m := newManager(t, store, nil)
+
+	for name, other := range map[string]*Manager{
+		"issuer":   newManagerFor(t, store, "other", "passd-session"),
+		"audience": newManagerFor(t, store, "passd", "other"),
+		"key":      newManagerFor(t, newStore(t), "passd", "passd-session"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			token, _, err := other.Issue("party", "fingerprint", time.Time{})
+			if err != nil {
+				t.Fatalf("failed to issue token: %v", err)
+			}
+			if _, err := m.Verify(token); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("expected a token with another %s to be rejected, got %v", name, err)
+			}
+		})
+	}
+
+	// A validly signed token without the credential claim, e.g. from an
+	// older version, isn't enough
+	m.mu.RLock()
+	signingKey := m.signingKey
+	m.mu.RUnlock()
+	now := time.Now()
+	token, err := jwt.NewBuilder().
+		Issuer("passd").
+		Audience([]string{"passd-session"}).
+		Subject("party").
+		IssuedAt(now).
+		Expiration(now.Add(time.Hour)).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build token: %v", err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.EdDSA(), signingKey))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	if _, err := m.Verify(string(signed)); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a token without a credential to be rejected, got %v", err)
+	}
+}
+
+// Rotating keeps the retired key in the JWKS until its tokens have expired,
+// & other managers sharing the store pick the new key up on reload.
+func TestRotate(t *testing.T) {
+	store := newStore(t)
+	now := time.Now().Truncate(time.Second)
+	m := newManager(t, store, &now)
+	replica := newManager(t, store, &now)
+
+	before, _, err := m.Issue("party", "fingerprint", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	id, err := m.Rotate()
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	after, _, err := m.Issue("party", "fingerprint", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if m.PublicKeys().Len() != 2 {
+		t.Fatalf("expected both keys in the JWKS, got %d", m.PublicKeys().Len())
+	}
+	if _, ok := m.PublicKeys().LookupKeyID(id); !ok {
+		t.Fatalf("expected the new key %s in the JWKS", id)
+	}
+
+	if _, err := replica.Verify(after); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected the replica not to know the new key before reloading, got %v", err)
+	}
+	if err := replica.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	for _, token := range []string{before, after} {
+		if _, err := replica.Verify(token); err != nil {
+			t.Fatalf("failed to verify token after reloading: %v", err)
+		}
+	}
+
+	// Once the retired key's tokens have expired it is dropped
+	now = now.Add(2 * time.Hour)
+	if err := m.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if m.PublicKeys().Len() != 1 {
+		t.Fatalf("expected only the new key in the JWKS, got %d", m.PublicKeys().Len())
+	}
+}
+
+// The JWKS only publishes public keys, each usable to verify tokens.
+func TestPublicKeys(t *testing.T) {
+	m := newManager(t, newStore(t), nil)
+	token, _, err := m.Issue("party", "fingerprint", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	encoded, err := json.Marshal(m.PublicKeys())
+	if err != nil {
+		t.Fatalf("failed to encode JWKS: %v", err)
+	}
+	var raw struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		t.Fatalf("failed to decode JWKS: %v", err)
+	}
+	if len(raw.Keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(raw.Keys))
+	}
+	if _, ok := raw.Keys[0]["d"]; ok {
+		t.Fatalf("expected no private key material in the JWKS: %s", encoded)
+	}
+	if raw.Keys[0]["alg"] != Algorithm || raw.Keys[0]["use"] != "sig" || raw.Keys[0]["kid"] == "" {
+		t.Fatalf("unexpected JWKS key: %v", raw.Keys[0])
+	}
+
+	set, err := jwk.Parse(encoded)
+	if err != nil {
+		t.Fatalf("failed to parse JWKS: %v", err)
+	}
+	if _, err := jwt.ParseString(token, jwt.WithKeySet(set), jwt.WithIssuer("passd"), jwt.WithAudience("passd-session")); err != nil {
+		t.Fatalf("failed to verify token against the published JWKS: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Package api holds the request & response bodies of the passd HTTP API, for
+// the service & its clients to share.
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+const (
+	PayloadEncodingText   string = "text"
+	PayloadEncodingBase64 string = "base64"
+)
+
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+type ValidatePasswordRequest struct {
+	Id       string `json:"id" xml:"name" form:"name"`
+	Password string `json:"password" xml:"password" form:"password"`
+	// Session requests a session token for the entry on success.
+	Session bool `json:"session" xml:"session" form:"session"`
+}
+
+type ValidatePasswordResponse struct {
+	Result    bool       `json:"result"`
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Payload is the entry's payload, if it has one & the password matched.
+	Payload *PayloadResponse `json:"payload,omitempty"`
+}
+
+// PayloadResponse is an entry's payload as embedded in JSON responses. Text
+// content is included as is; anything else is base64-encoded.
+type PayloadResponse struct {
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Encoding    string `json:"encoding"`
+	Content     string `json:"content"`
+}
+
+// Bytes returns the payload's content, decoding it if need be.
+func (p PayloadResponse) Bytes() ([]byte, error) {
+	switch p.Encoding {
+	case PayloadEncodingText:
+		return []byte(p.Content), nil
+	case PayloadEncodingBase64:
+		return base64.StdEncoding.DecodeString(p.Content)
+	default:
+		return nil, fmt.Errorf("unknown payload encoding: %s", p.Encoding)
+	}
+}
+
+type UpsertPasswordRequest struct {
+	Password    string `json:"password" xml:"password" form:"password"`
+	StorageMode string `json:"storage_mode,omitempty" xml:"storage_mode" form:"storage_mode"`
+}
+
+type GetPasswordResponse struct {
+	Id          string `json:"id"`
+	Password    string `json:"password"`
+	StorageMode string `json:"storage_mode"`
+	Retrievable bool   `json:"retrievable"`
+}
+
+type GetPasswordEntryResponse struct {
+	Id              string               `json:"id"`
+	Credentials     []CredentialResponse `json:"credentials"`
+	Payload         *PayloadInfoResponse `json:"payload"`
+	HasRedirect     bool                 `json:"has_redirect"`
+	CreatedOn       time.Time            `json:"created_on"`
+	UpdatedOn       time.Time            `json:"updated_on"`
+	LastValidatedOn *time.Time           `json:"last_validated_on"`
+	ValidationCount int                  `json:"validation_count"`
+	Description     string               `json:"description"`
+	Tags            []string             `json:"tags"`
+	NotBefore       *time.Time           `json:"not_before"`
+	ExpiresAt       *time.Time           `json:"expires_at"`
+	MaxUses         *int                 `json:"max_uses"`
+	UseCount        int                  `json:"use_count"`
+	RemainingUses   *int                 `json:"remaining_uses"`
+	AllowedOrigins  []string             `json:"allowed_origins"`
+}
+
+type CredentialResponse struct {
+	Name        string     `json:"name"`
+	StorageMode string     `json:"storage_mode"`
+	CreatedOn   time.Time  `json:"created_on"`
+	NotBefore   *time.Time `json:"not_before"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type PayloadInfoResponse struct {
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename"`
+	Size        int       `json:"size"`
+	UpdatedOn   time.Time `json:"updated_on"`
+}
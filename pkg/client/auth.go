@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mrshanahan/quemot-dev-auth-client/pkg/auth"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Login describes how to get access tokens for the admin API from the OAuth2
+// provider that passd trusts.
+type Login struct {
+	// ProviderUrl is the provider's base URL, as passd is given it in
+	// PASSD_AUTH_PROVIDER_URL.
+	ProviderUrl string
+	ClientId    string
+	// ClientSecret selects the client credentials grant, e.g. for scripts
+	// running as a service account. Without it users log in with the device
+	// code grant.
+	ClientSecret string
+	// Cache, if set, keeps tokens between runs so that users don't have to
+	// log in every time.
+	Cache TokenCache
+	// Prompt is called with the URL & code that the user has to enter to
+	// complete a device code login.
+	Prompt func(*oauth2.DeviceAuthResponse)
+}
+
+// TokenSource returns a source of access tokens, refreshing them as they
+// expire. If there is no usable token in the cache, a device code login is
+// started & TokenSource blocks until the user completes it. ctx is also used
+// to refresh tokens later.
+func (l Login) TokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	config, err := auth.BuildDeviceCodeConfig(ctx, l.ClientId, l.ProviderUrl)
+	if err != nil {
+		return nil, err
+	}
+	var cached *oauth2.Token
+	if l.Cache != nil {
+		if cached, err = l.Cache.Load(); err != nil {
+			return nil, err
+		}
+	}
+
+	var source oauth2.TokenSource
+	if l.ClientSecret != "" {
+		credentials := clientcredentials.Config{
+			ClientID:     l.ClientId,
+			ClientSecret: l.ClientSecret,
+			TokenURL:     config.LoginConfig.Endpoint.TokenURL,
+			Scopes:       config.LoginConfig.Scopes,
+		}
+		source = oauth2.ReuseTokenSource(cached, credentials.TokenSource(ctx))
+	} else {
+		if cached != nil {
+			source = config.LoginConfig.TokenSource(ctx, cached)
+			if _, err := source.Token(); err != nil {
+				slog.Debug("cached token cannot be refreshed; logging in again", "err", err)
+				source = nil
+			}
+		}
+		if source == nil {
+			token, err := l.deviceLogin(ctx, &config.LoginConfig)
+			if err != nil {
+				return nil, err
+			}
+			source = config.LoginConfig.TokenSource(ctx, token)
+		}
+	}
+
+	if l.Cache == nil {
+		return source, nil
+	}
+	return &cachingTokenSource{source: source, cache: l.Cache}, nil
+}
+
+func (l Login) deviceLogin(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	if l.Prompt == nil {
+		return nil, fmt.Errorf("not logged in & no way to prompt for a device code login")
+	}
+	response, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device code login: %w", err)
+	}
+	l.Prompt(response)
+	token, err := config.DeviceAccessToken(ctx, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete device code login: %w", err)
+	}
+	return token, nil
+}
+
+// TokenCache keeps tokens between runs.
+type TokenCache interface {
+	// Load returns the cached token, or nil if there isn't one.
+	Load() (*oauth2.Token, error)
+	Save(token *oauth2.Token) error
+}
+
+// FileTokenCache is a TokenCache that keeps the token as JSON in the file at
+// the given path, readable only by its owner.
+type FileTokenCache string
+
+func (path FileTokenCache) Load() (*oauth2.Token, error) {
+	contents, err := os.ReadFile(string(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read token cache: %w", err)
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(contents, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token cache %s: %w", path, err)
+	}
+	return &token, nil
+}
+
+func (path FileTokenCache) Save(token *oauth2.Token) error {
+	contents, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(string(path)), 0o700); err != nil {
+		return fmt.Errorf("failed to create token cache directory: %w", err)
+	}
+	if err := os.WriteFile(string(path), contents, 0o600); err != nil {
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+	return nil
+}
+
+// Clear removes the cached token, if there is one.
+func (path FileTokenCache) Clear() error {
+	if err := os.Remove(string(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove token cache: %w", err)
+	}
+	return nil
+}
+
+// cachingTokenSource saves each new token from source to cache. Failing to
+// save one is logged rather than failing the request that needed it.
+type cachingTokenSource struct {
+	source oauth2.TokenSource
+	cache  TokenCache
+
+	mu    sync.Mutex
+	saved string
+}
+
+func (s *cachingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.saved {
+		if err := s.cache.Save(token); err != nil {
+			slog.Warn("failed to cache token", "err", err)
+		} else {
+			s.saved = token.AccessToken
+		}
+	}
+	return token, nil
+}
@@ -0,0 +1,150 @@
+// Package client calls the passd admin API & /validate from Go, as an
+// alternative to hand-written HTTP requests.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+	"golang.org/x/oauth2"
+)
+
+var ErrNotFound error = errors.New("no entry with id exists")
+
+// StatusError is returned for responses with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+	// Message is the message in the response body, if there was one.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("passd returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("passd returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+type Client struct {
+	baseUrl    string
+	httpClient *http.Client
+}
+
+// New returns a client for the passd instance at baseUrl that authenticates
+// admin requests with tokens from tokens. tokens may be nil for instances
+// with authentication disabled.
+func New(baseUrl string, tokens oauth2.TokenSource) *Client {
+	httpClient := http.DefaultClient
+	if tokens != nil {
+		httpClient = oauth2.NewClient(context.Background(), tokens)
+	}
+	return &Client{baseUrl: strings.TrimRight(baseUrl, "/"), httpClient: httpClient}
+}
+
+// do sends a request with body encoded as JSON, if it isn't nil, & decodes
+// the response into result, if it isn't nil. ErrNotFound is returned for 404s
+// & a *StatusError for any other status code besides 200 & 204.
+func (c *Client) do(ctx context.Context, method string, path string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if result == nil {
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		return nil
+	case http.StatusNoContent, http.StatusCreated:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		var errorResponse passdapi.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errorResponse)
+		return &StatusError{StatusCode: resp.StatusCode, Message: errorResponse.Message}
+	}
+}
+
+func entryPath(id string) string {
+	return "/admin/api/" + url.PathEscape(id)
+}
+
+// List returns the metadata of every entry.
+func (c *Client) List(ctx context.Context) ([]passdapi.GetPasswordEntryResponse, error) {
+	var entries []passdapi.GetPasswordEntryResponse
+	if err := c.do(ctx, http.MethodGet, "/admin/api/", nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Get returns the password of the entry with the given id. Passwords stored
+// as hashes are not retrievable, so Password is empty for them.
+func (c *Client) Get(ctx context.Context, id string) (*passdapi.GetPasswordResponse, error) {
+	var response passdapi.GetPasswordResponse
+	if err := c.do(ctx, http.MethodGet, entryPath(id), nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetMetadata returns the metadata of the entry with the given id.
+func (c *Client) GetMetadata(ctx context.Context, id string) (*passdapi.GetPasswordEntryResponse, error) {
+	var response passdapi.GetPasswordEntryResponse
+	if err := c.do(ctx, http.MethodGet, entryPath(id)+"/meta", nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Upsert creates the entry with the given id or replaces its default
+// credential's password.
+func (c *Client) Upsert(ctx context.Context, id string, request passdapi.UpsertPasswordRequest) error {
+	return c.do(ctx, http.MethodPost, entryPath(id), request, nil)
+}
+
+// Delete deletes the entry with the given id.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, entryPath(id), nil, nil)
+}
+
+// Validate checks a password as a public site would, using up one of the
+// entry's uses if it matches. It doesn't need a token.
+func (c *Client) Validate(ctx context.Context, request passdapi.ValidatePasswordRequest) (*passdapi.ValidatePasswordResponse, error) {
+	var response passdapi.ValidatePasswordResponse
+	if err := c.do(ctx, http.MethodPost, "/validate", request, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
@@ -0,0 +1,301 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	passdapi "github.com/mrshanahan/simple-password-service/pkg/api"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testClientId     = "passd-cli"
+	testClientSecret = "service-secret"
+)
+
+// fakeProvider is an OAuth2 provider that supports just enough of discovery,
+// the device code, client credentials & refresh token grants for the client.
+// Device code logins are approved as soon as the user is prompted.
+type fakeProvider struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	approved     bool
+	issued       int
+	deviceLogins int
+	refreshes    int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	p := &fakeProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, map[string]any{
+			"issuer":                        p.URL,
+			"authorization_endpoint":        p.URL + "/auth",
+			"token_endpoint":                p.URL + "/token",
+			"device_authorization_endpoint": p.URL + "/device",
+			"jwks_uri":                      p.URL + "/certs",
+		})
+	})
+	mux.HandleFunc("POST /device", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.deviceLogins++
+		p.approved = false
+		writeJson(w, http.StatusOK, map[string]any{
+			"device_code":      "device-code",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": p.URL + "/activate",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("POST /token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if id != testClientId || secret != testClientSecret {
+			writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		if r.PostForm.Get("device_code") != "device-code" {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if !p.approved {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+			return
+		}
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != "refresh-token" {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		p.refreshes++
+	default:
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	p.issued++
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token":  fmt.Sprintf("access-token-%d", p.issued),
+		"refresh_token": "refresh-token",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+// prompt approves the device code login it is shown.
+func (p *fakeProvider) prompt(t *testing.T) func(*oauth2.DeviceAuthResponse) {
+	return func(response *oauth2.DeviceAuthResponse) {
+		if response.UserCode != "ABCD-EFGH" || response.VerificationURI != p.URL+"/activate" {
+			t.Errorf("unexpected device code prompt: %+v", response)
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.approved = true
+	}
+}
+
+func noPrompt(t *testing.T) func(*oauth2.DeviceAuthResponse) {
+	return func(*oauth2.DeviceAuthResponse) {
+		t.Errorf("expected no device code login")
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// newFakePassd returns the URL of a passd instance that lists no entries &
+// records the Authorization header of the last request.
+func newFakePassd(t *testing.T, authorization *string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*authorization = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/admin/api/":
+			writeJson(w, http.StatusOK, []passdapi.GetPasswordEntryResponse{})
+		case "/admin/api/missing":
+			writeJson(w, http.StatusNotFound, passdapi.ErrorResponse{Message: "not found"})
+		default:
+			writeJson(w, http.StatusBadRequest, passdapi.ErrorResponse{Message: "invalid id"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func expectAuthorized(t *testing.T, tokens oauth2.TokenSource, expected string) {
+	t.Helper()
+	var authorization string
+	c := New(newFakePassd(t, &authorization), tokens)
+	if _, err := c.List(context.Background()); err != nil {
+		t.Fatalf("failed to list entries: %v", err)
+	}
+	if authorization != "Bearer "+expected {
+		t.Fatalf("expected the request to use %s, got %q", expected, authorization)
+	}
+}
+
+func TestDeviceLogin(t *testing.T) {
+	provider := newFakeProvider(t)
+	cache := FileTokenCache(filepath.Join(t.TempDir(), "passd", "token.json"))
+	login := Login{ProviderUrl: provider.URL, ClientId: testClientId, Cache: cache, Prompt: provider.prompt(t)}
+
+	tokens, err := login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	expectAuthorized(t, tokens, "access-token-1")
+	if cached, err := cache.Load(); err != nil || cached == nil || cached.AccessToken != "access-token-1" {
+		t.Fatalf("expected the token to be cached, got %+v, %v", cached, err)
+	}
+
+	// The cached token is used on the next run without logging in again
+	login.Prompt = noPrompt(t)
+	tokens, err = login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load cached token: %v", err)
+	}
+	expectAuthorized(t, tokens, "access-token-1")
+
+	// & is refreshed once it has expired
+	expired, _ := cache.Load()
+	expired.Expiry = time.Now().Add(-time.Minute)
+	if err := cache.Save(expired); err != nil {
+		t.Fatalf("failed to save token: %v", err)
+	}
+	tokens, err = login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to refresh cached token: %v", err)
+	}
+	expectAuthorized(t, tokens, "access-token-2")
+	if cached, _ := cache.Load(); cached.AccessToken != "access-token-2" {
+		t.Fatalf("expected the refreshed token to be cached, got %s", cached.AccessToken)
+	}
+	if provider.deviceLogins != 1 || provider.refreshes != 1 {
+		t.Fatalf("expected 1 login & 1 refresh, got %d & %d", provider.deviceLogins, provider.refreshes)
+	}
+}
+
+// A cached token that can't be refreshed any more leads to a new login.
+func TestDeviceLoginAfterRefreshFails(t *testing.T) {
+	provider := newFakeProvider(t)
+	cache := FileTokenCache(filepath.Join(t.TempDir(), "token.json"))
+	revoked := &oauth2.Token{AccessToken: "old", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Minute)}
+	if err := cache.Save(revoked); err != nil {
+		t.Fatalf("failed to save token: %v", err)
+	}
+
+	login := Login{ProviderUrl: provider.URL, ClientId: testClientId, Cache: cache, Prompt: provider.prompt(t)}
+	tokens, err := login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	expectAuthorized(t, tokens, "access-token-1")
+	if provider.deviceLogins != 1 {
+		t.Fatalf("expected a device code login, got %d", provider.deviceLogins)
+	}
+
+	// Without a way to prompt, there's nothing to fall back to
+	if err := cache.Save(revoked); err != nil {
+		t.Fatalf("failed to save token: %v", err)
+	}
+	login.Prompt = nil
+	if _, err := login.TokenSource(context.Background()); err == nil {
+		t.Fatalf("expected logging in without a prompt to fail")
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	provider := newFakeProvider(t)
+	cache := FileTokenCache(filepath.Join(t.TempDir(), "token.json"))
+	login := Login{
+		ProviderUrl:  provider.URL,
+		ClientId:     testClientId,
+		ClientSecret: testClientSecret,
+		Cache:        cache,
+		Prompt:       noPrompt(t),
+	}
+
+	tokens, err := login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get token source: %v", err)
+	}
+	expectAuthorized(t, tokens, "access-token-1")
+	expectAuthorized(t, tokens, "access-token-1")
+	if provider.issued != 1 {
+		t.Fatalf("expected the token to be reused, got %d tokens", provider.issued)
+	}
+
+	// A valid cached token is used as is, & an expired one is replaced with
+	// a new one from the client credentials grant rather than refreshed
+	tokens, err = login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get token source: %v", err)
+	}
+	expectAuthorized(t, tokens, "access-token-1")
+	expired, _ := cache.Load()
+	expired.Expiry = time.Now().Add(-time.Minute)
+	if err := cache.Save(expired); err != nil {
+		t.Fatalf("failed to save token: %v", err)
+	}
+	tokens, err = login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get token source: %v", err)
+	}
+	expectAuthorized(t, tokens, "access-token-2")
+	if provider.deviceLogins != 0 || provider.refreshes != 0 {
+		t.Fatalf("expected only client credentials grants, got %d logins & %d refreshes", provider.deviceLogins, provider.refreshes)
+	}
+
+	login = Login{ProviderUrl: provider.URL, ClientId: testClientId, ClientSecret: "wrong"}
+	tokens, err = login.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get token source: %v", err)
+	}
+	if _, err := New(newFakePassd(t, new(string)), tokens).List(context.Background()); err == nil {
+		t.Fatalf("expected a wrong client secret to be rejected")
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	c := New(newFakePassd(t, new(string)), nil)
+	if _, err := c.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	_, err := c.Get(context.Background(), "bad id")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest || statusErr.Message != "invalid id" {
+		t.Fatalf("expected a 400 with the response's message, got %v", err)
+	}
+}